@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// apiKeyHeader carries the client's API key
+	apiKeyHeader = "X-API-Key"
+	// apiKeyTenantKey stores the tenant a validated key is bound to in the Gin context
+	apiKeyTenantKey = "api_key_tenant"
+)
+
+// apiKeyAuth checks requests against a set of API keys. Only key hashes are
+// kept, and every key is compared in constant time so response timing does
+// not reveal how much of a key matched. With no keys configured every request
+// is allowed, keeping local development open.
+type apiKeyAuth struct {
+	hashes       [][sha256.Size]byte
+	tenants      []string // Tenant each key is bound to, by index into hashes; empty for keys valid for every tenant
+	protectReads bool     // Also require a key on read endpoints
+}
+
+// loadAPIKeyAuth reads API keys from OCS_API_KEYS (comma-separated) and
+// OCS_API_KEYS_FILE (one key per line, # starts a comment), tenant-bound keys
+// from OCS_TENANT_API_KEYS and OCS_TENANT_API_KEYS_FILE in the same formats
+// with each entry written tenant=key, and whether read endpoints are
+// protected from OCS_API_KEYS_PROTECT_READS. Tenant-bound keys always
+// protect reads, since an open read endpoint would serve any tenant.
+func loadAPIKeyAuth() (*apiKeyAuth, error) {
+	keys, err := readAPIKeys("OCS_API_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	tenantEntries, err := readAPIKeys("OCS_TENANT_API_KEYS")
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &apiKeyAuth{}
+	for _, key := range keys {
+		auth.add(key, "")
+	}
+	for _, entry := range tenantEntries {
+		tenantID, key, ok := strings.Cut(entry, "=")
+		if !ok || !tenantIDPattern.MatchString(tenantID) || key == "" {
+			return nil, fmt.Errorf("invalid tenant API key entry: write tenant=key")
+		}
+		auth.add(key, tenantID)
+	}
+	auth.protectReads = len(tenantEntries) > 0
+
+	if value := os.Getenv("OCS_API_KEYS_PROTECT_READS"); value != "" {
+		protectReads, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OCS_API_KEYS_PROTECT_READS %q: %w", value, err)
+		}
+		if protectReads && len(auth.hashes) == 0 {
+			return nil, fmt.Errorf("OCS_API_KEYS_PROTECT_READS is set but no API keys are configured")
+		}
+		if !protectReads && len(tenantEntries) > 0 {
+			return nil, fmt.Errorf("OCS_API_KEYS_PROTECT_READS cannot be false with tenant API keys configured")
+		}
+		auth.protectReads = protectReads
+	}
+	return auth, nil
+}
+
+// readAPIKeys reads the keys listed in the env variable name (comma-separated)
+// and in the file named by name_FILE
+func readAPIKeys(name string) ([]string, error) {
+	keys := splitList(os.Getenv(name))
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		fileKeys, err := readAPIKeysFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return keys, nil
+}
+
+// add registers a key, bound to tenantID unless it is empty
+func (a *apiKeyAuth) add(key, tenantID string) {
+	a.hashes = append(a.hashes, sha256.Sum256([]byte(key)))
+	a.tenants = append(a.tenants, tenantID)
+}
+
+// keyTenants returns the distinct tenants keys are bound to
+func (a *apiKeyAuth) keyTenants() []string {
+	var tenants []string
+	for _, tenantID := range a.tenants {
+		if tenantID != "" && !slices.Contains(tenants, tenantID) {
+			tenants = append(tenants, tenantID)
+		}
+	}
+	return tenants
+}
+
+// readAPIKeysFile reads one API key per line, skipping blank lines and comments
+func readAPIKeysFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open API keys file: %w", err)
+	}
+	defer file.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+	return keys, nil
+}
+
+// enabled reports whether any API key is configured
+func (a *apiKeyAuth) enabled() bool {
+	return len(a.hashes) > 0
+}
+
+// valid reports whether key matches a configured API key, and the tenant
+// the key is bound to
+func (a *apiKeyAuth) valid(key string) (bool, string) {
+	hash := sha256.Sum256([]byte(key))
+	match, index := 0, 0
+	for i := range a.hashes {
+		equal := subtle.ConstantTimeCompare(hash[:], a.hashes[i][:])
+		match |= equal
+		index = subtle.ConstantTimeSelect(equal, i, index)
+	}
+	if match != 1 {
+		return false, ""
+	}
+	return true, a.tenants[index]
+}
+
+// requireKey rejects requests without a valid API key with 401 when keys are
+// configured, recording the tenant a valid key is bound to
+func (a *apiKeyAuth) requireKey(c *gin.Context) {
+	if !a.enabled() {
+		return
+	}
+
+	key := c.GetHeader(apiKeyHeader)
+	if key == "" {
+		respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("Missing %s header", apiKeyHeader))
+		c.Abort()
+		return
+	}
+	ok, tenantID := a.valid(key)
+	if !ok {
+		requestLogger(c).Warn("Rejected request with invalid API key", "client_ip", c.ClientIP(), "path", c.FullPath())
+		respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid API key")
+		c.Abort()
+		return
+	}
+	c.Set(apiKeyTenantKey, tenantID)
+}
+
+// requireUnscopedKey rejects requests authenticated with a tenant-bound key
+// with 403, for endpoints affecting every tenant. It runs after requireKey.
+func (a *apiKeyAuth) requireUnscopedKey(c *gin.Context) {
+	if tenantID := c.GetString(apiKeyTenantKey); tenantID != "" {
+		respondError(c, http.StatusForbidden, ErrCodeInvalidTenant, fmt.Sprintf("API key for tenant %q cannot be used here", tenantID))
+		c.Abort()
+	}
+}
+
+// requireKeyForReads applies requireKey to read endpoints when reads are protected
+func (a *apiKeyAuth) requireKeyForReads(c *gin.Context) {
+	if a.protectReads {
+		a.requireKey(c)
+	}
+}
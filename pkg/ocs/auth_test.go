@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authRouter serves one protected write and one read endpoint behind auth
+func authRouter(auth *apiKeyAuth) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(requestIDMiddleware)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.Group("/", auth.requireKey).POST("/collect_istio_metrics", ok)
+	router.Group("/", auth.requireKeyForReads).GET("/get_ocs_prompt", ok)
+	return router
+}
+
+func authStatus(router *gin.Engine, method, path, key string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keysFile, []byte("# agents\nfile-key\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	open, err := loadAPIKeyAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(authRouter(open), "POST", "/collect_istio_metrics", ""); got != http.StatusOK {
+		t.Errorf("without keys configured, status = %d, want 200", got)
+	}
+
+	t.Setenv("OCS_API_KEYS", "env-key-1, env-key-2")
+	t.Setenv("OCS_API_KEYS_FILE", keysFile)
+	auth, err := loadAPIKeyAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := authRouter(auth)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		want   int
+	}{
+		{"write without key", "POST", "/collect_istio_metrics", "", http.StatusUnauthorized},
+		{"write with wrong key", "POST", "/collect_istio_metrics", "env-key", http.StatusUnauthorized},
+		{"write with env key", "POST", "/collect_istio_metrics", "env-key-2", http.StatusOK},
+		{"write with file key", "POST", "/collect_istio_metrics", "file-key", http.StatusOK},
+		{"read stays open", "GET", "/get_ocs_prompt", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := authStatus(router, tt.method, tt.path, tt.key); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	t.Setenv("OCS_API_KEYS_PROTECT_READS", "true")
+	auth, err = loadAPIKeyAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router = authRouter(auth)
+	if got := authStatus(router, "GET", "/get_ocs_prompt", ""); got != http.StatusUnauthorized {
+		t.Errorf("protected read without key: status = %d, want 401", got)
+	}
+	if got := authStatus(router, "GET", "/get_ocs_prompt", "file-key"); got != http.StatusOK {
+		t.Errorf("protected read with key: status = %d, want 200", got)
+	}
+}
+
+func TestPprofRoutes(t *testing.T) {
+	t.Setenv("OCS_API_KEYS", "ops-key")
+	t.Setenv("OCS_TENANT_API_KEYS", "acme=acme-key")
+	auth, err := loadAPIKeyAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := authRouter(auth)
+	if got := authStatus(router, "GET", "/debug/pprof/heap", "ops-key"); got != http.StatusNotFound {
+		t.Errorf("before mounting, status = %d, want 404", got)
+	}
+
+	registerPprofRoutes(router, auth)
+	tests := []struct {
+		path string
+		key  string
+		want int
+	}{
+		{"/debug/pprof/heap", "", http.StatusUnauthorized},
+		{"/debug/pprof/heap", "acme-key", http.StatusForbidden},
+		{"/debug/pprof/heap", "ops-key", http.StatusOK},
+		{"/debug/pprof/", "ops-key", http.StatusOK},
+		{"/debug/pprof/cmdline", "ops-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := authStatus(router, "GET", tt.path, tt.key); got != tt.want {
+			t.Errorf("GET %s with key %q: status = %d, want %d", tt.path, tt.key, got, tt.want)
+		}
+	}
+}
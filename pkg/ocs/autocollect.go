@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+)
+
+// minAutoCollectInterval keeps scheduled collection from hammering Prometheus
+const minAutoCollectInterval = 10 * time.Second
+
+// loadAutoCollectInterval reads AUTO_COLLECT_INTERVAL, returning 0 when
+// scheduled collection is disabled (unset or "off")
+func loadAutoCollectInterval() (time.Duration, error) {
+	value := os.Getenv("AUTO_COLLECT_INTERVAL")
+	if value == "" || value == "off" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < minAutoCollectInterval {
+		return 0, fmt.Errorf("invalid AUTO_COLLECT_INTERVAL %q: use a duration of at least %s, or off", value, minAutoCollectInterval)
+	}
+	return interval, nil
+}
+
+// AutoCollectStatus reports scheduled collection on /collect/auto
+type AutoCollectStatus struct {
+	Enabled        bool   `json:"enabled"`
+	Interval       string `json:"interval,omitempty"`
+	Running        bool   `json:"running"` // A collection is in progress
+	Runs           int    `json:"runs"`
+	Failures       int    `json:"failures"`
+	LastRunAt      string `json:"last_run_at,omitempty"`
+	LastSuccessAt  string `json:"last_success_at,omitempty"`
+	LastError      string `json:"last_error,omitempty"` // Error of the last run; cleared by a successful run
+	LastDocumentID string `json:"last_document_id,omitempty"`
+	LastDurationMs int64  `json:"last_duration_ms,omitempty"`
+	NextRunAt      string `json:"next_run_at,omitempty"`
+}
+
+// autoCollector runs the collection pipeline on a schedule and keeps the
+// outcome of the last run
+type autoCollector struct {
+	interval time.Duration
+	done     chan struct{} // Closed when the collector has stopped
+
+	mu     sync.Mutex
+	status AutoCollectStatus
+}
+
+// StartAutoCollect collects immediately and then every interval until the
+// server begins shutting down, which also cancels a collection in progress
+func (s *Server) StartAutoCollect(interval time.Duration) {
+	collector := &autoCollector{
+		interval: interval,
+		done:     make(chan struct{}),
+		status:   AutoCollectStatus{Enabled: true, Interval: interval.String()},
+	}
+	s.autoCollector = collector
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.shutdown
+		cancel()
+	}()
+
+	go func() {
+		defer close(collector.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.runAutoCollect(ctx, collector)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				slog.Info("Stopped scheduled collection")
+				return
+			}
+		}
+	}()
+	slog.Info("Started scheduled collection", "interval", interval.String())
+}
+
+// WaitAutoCollect waits for scheduled collection to stop after shutdown has
+// begun, or for ctx to expire
+func (s *Server) WaitAutoCollect(ctx context.Context) {
+	if s.autoCollector == nil {
+		return
+	}
+	select {
+	case <-s.autoCollector.done:
+	case <-ctx.Done():
+	}
+}
+
+// runAutoCollect runs one scheduled collection with the current config, as
+// POST /collect_istio_metrics does without query parameters. Scheduled
+// collection is only available without tenants, so it saves to the default tenant.
+func (s *Server) runAutoCollect(ctx context.Context, collector *autoCollector) {
+	started := time.Now()
+	collector.mu.Lock()
+	collector.status.Running = true
+	collector.mu.Unlock()
+
+	logger := slog.With("trigger", "schedule")
+	docID, err := s.autoCollectOnce(ctx, logger)
+	if ctx.Err() != nil {
+		// Shutting down; the interrupted run is neither a success nor a failure
+		collector.mu.Lock()
+		collector.status.Running = false
+		collector.mu.Unlock()
+		return
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	status := &collector.status
+	status.Running = false
+	status.Runs++
+	status.LastRunAt = started.Format(time.RFC3339)
+	status.LastDurationMs = time.Since(started).Milliseconds()
+	status.NextRunAt = started.Add(collector.interval).Format(time.RFC3339)
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+		autoCollectRunsTotal.WithLabelValues("error").Inc()
+		logger.Error("Scheduled collection failed", "error", err)
+		return
+	}
+	status.LastError = ""
+	status.LastSuccessAt = status.LastRunAt
+	status.LastDocumentID = docID
+	autoCollectRunsTotal.WithLabelValues("success").Inc()
+}
+
+// autoCollectOnce collects and saves the topology with the current config, returning the saved document's ID
+func (s *Server) autoCollectOnce(ctx context.Context, logger *slog.Logger) (string, error) {
+	config := s.config()
+	if len(config.Workload) == 0 {
+		// runCollection records its own outcome; this failure happens before it runs
+		err := fmt.Errorf("no source workloads configured in ocs_config.yaml")
+		s.defaultTenant.lastCollection.record(err)
+		return "", err
+	}
+
+	fromTimestamp, toTimestamp := config.defaultCollectionRange(config.CollectionMode, time.Now())
+	// Scheduled runs are always quiet, as backfill is; quiet_queries is for requested collections
+	queryOpts := prometheus.QueryOptions{
+		Logger:        prometheus.NewQueryLogger(logger, true),
+		DecayHalfLife: config.edgeDecayHalfLife(),
+		MetricName:    config.MetricName,
+		Namespaces:    config.Namespaces,
+		ExtraFilters:  config.ExtraFilters,
+		Reporter:      config.Reporter,
+	}
+	run, err := s.runCollection(ctx, s.defaultTenant, config, fromTimestamp, toTimestamp, queryOpts, false, logger)
+	if err != nil {
+		return "", err
+	}
+	return run.docID.Hex(), nil
+}
+
+// autoCollectStatusHandler handles GET /collect/auto, reporting scheduled collection's last run
+func (s *Server) autoCollectStatusHandler(c *gin.Context) {
+	if s.autoCollector == nil {
+		c.JSON(http.StatusOK, AutoCollectStatus{Enabled: false})
+		return
+	}
+	s.autoCollector.mu.Lock()
+	status := s.autoCollector.status
+	s.autoCollector.mu.Unlock()
+	c.JSON(http.StatusOK, status)
+}
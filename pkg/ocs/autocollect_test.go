@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadAutoCollectInterval(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"off", 0, false},
+		{"5m", 5 * time.Minute, false},
+		{"1s", 0, true},
+		{"often", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("AUTO_COLLECT_INTERVAL", tt.value)
+		got, err := loadAutoCollectInterval()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("AUTO_COLLECT_INTERVAL=%q: got %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAutoCollectRecordsFailureAndStops(t *testing.T) {
+	// Without workloads the run fails before touching Prometheus or MongoDB
+	s := &Server{ocsConfig: &OCSConfig{}, defaultTenant: newTenant("", nil), shutdown: make(chan struct{})}
+	s.StartAutoCollect(time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	var status AutoCollectStatus
+	for time.Now().Before(deadline) {
+		s.autoCollector.mu.Lock()
+		status = s.autoCollector.status
+		s.autoCollector.mu.Unlock()
+		if status.Runs > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Runs != 1 || status.Failures != 1 || !strings.Contains(status.LastError, "no source workloads") {
+		t.Fatalf("status after first run = %+v", status)
+	}
+	if status.LastSuccessAt != "" || status.NextRunAt == "" {
+		t.Errorf("status after a failed run = %+v", status)
+	}
+	s.defaultTenant.lastCollection.mu.Lock()
+	lastError := s.defaultTenant.lastCollection.lastError
+	s.defaultTenant.lastCollection.mu.Unlock()
+	if !strings.Contains(lastError, "no source workloads") {
+		t.Errorf("last collection error = %q, want the scheduled run's error", lastError)
+	}
+
+	close(s.shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.WaitAutoCollect(ctx)
+	if ctx.Err() != nil {
+		t.Error("scheduled collection did not stop on shutdown")
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+)
+
+const (
+	// defaultBackfillInterval is the window length when interval is not given
+	defaultBackfillInterval = time.Hour
+	// maxBackfillWindows caps how many windows, and so snapshots, one backfill may create
+	maxBackfillWindows = 2000
+	// defaultBackfillConcurrency and maxBackfillConcurrency bound how many windows are collected at once
+	defaultBackfillConcurrency = 2
+	maxBackfillConcurrency     = 8
+	// maxBackfillFailures caps the failed windows kept on a job for reporting
+	maxBackfillFailures = 50
+	// maxFinishedBackfills is how many finished jobs are kept for their progress to be read
+	maxFinishedBackfills = 20
+)
+
+// Backfill job statuses
+const (
+	BackfillStatusRunning   = "running"
+	BackfillStatusCompleted = "completed"
+	BackfillStatusCancelled = "cancelled"
+)
+
+// backfillWindow is one interval of a backfill, collected with a range query
+// and saved as a snapshot timestamped at its end
+type backfillWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+// backfillWindows splits [from, to) into consecutive windows of interval,
+// the last one ending at to and possibly shorter
+func backfillWindows(from, to time.Time, interval time.Duration) []backfillWindow {
+	var windows []backfillWindow
+	for start := from; start.Before(to); start = start.Add(interval) {
+		end := start.Add(interval)
+		if end.After(to) {
+			end = to
+		}
+		windows = append(windows, backfillWindow{From: start, To: end})
+	}
+	return windows
+}
+
+// BackfillFailure reports a window that could not be collected or saved
+type BackfillFailure struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Error string `json:"error"`
+}
+
+// BackfillProgress is a backfill job's state as reported by the API
+type BackfillProgress struct {
+	ID               string            `json:"id"`
+	Status           string            `json:"status"`
+	From             string            `json:"from"`
+	To               string            `json:"to"`
+	Interval         string            `json:"interval"`
+	Concurrency      int               `json:"concurrency"`
+	TotalWindows     int               `json:"total_windows"`
+	CompletedWindows int               `json:"completed_windows"` // Windows saved or failed so far
+	Saved            int               `json:"saved"`
+	Failed           int               `json:"failed"`
+	Failures         []BackfillFailure `json:"failures,omitempty"` // The first maxBackfillFailures failed windows
+	StartedAt        string            `json:"started_at"`
+	FinishedAt       string            `json:"finished_at,omitempty"`
+}
+
+// backfillJob collects topology for each window of a backfill in the background
+type backfillJob struct {
+	tenant *tenant // Tenant the snapshots are saved for; only it can see the job
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the job has finished
+
+	mu       sync.Mutex
+	progress BackfillProgress
+}
+
+// snapshot returns a copy of the job's progress
+func (j *backfillJob) snapshot() BackfillProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	progress := j.progress
+	progress.Failures = append([]BackfillFailure(nil), j.progress.Failures...)
+	return progress
+}
+
+// record counts a finished window, keeping its error when it failed
+func (j *backfillJob) record(window backfillWindow, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress.CompletedWindows++
+	if err == nil {
+		j.progress.Saved++
+		return
+	}
+	j.progress.Failed++
+	if len(j.progress.Failures) < maxBackfillFailures {
+		j.progress.Failures = append(j.progress.Failures, BackfillFailure{
+			From:  window.From.Format(time.RFC3339),
+			To:    window.To.Format(time.RFC3339),
+			Error: err.Error(),
+		})
+	}
+}
+
+// finish marks the job completed, or cancelled if ctx was cancelled first
+func (j *backfillJob) finish(ctx context.Context) BackfillProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress.Status = BackfillStatusCompleted
+	if ctx.Err() != nil {
+		j.progress.Status = BackfillStatusCancelled
+	}
+	j.progress.FinishedAt = time.Now().Format(time.RFC3339)
+	return j.progress
+}
+
+// backfillRegistry tracks the running backfill and recently finished ones.
+// Only one backfill runs at a time, across all tenants, so history seeding
+// can't crowd out regular collection on Prometheus.
+type backfillRegistry struct {
+	mu       sync.Mutex
+	jobs     map[string]*backfillJob
+	finished []string // IDs of finished jobs, oldest first
+	running  *backfillJob
+}
+
+func newBackfillRegistry() *backfillRegistry {
+	return &backfillRegistry{jobs: make(map[string]*backfillJob)}
+}
+
+// errBackfillRunning is returned when a backfill is started while another is running
+var errBackfillRunning = errors.New("a backfill is already running")
+
+// start registers job as the running backfill
+func (r *backfillRegistry) start(job *backfillJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running != nil {
+		// Another tenant's job ID is not revealed
+		if r.running.tenant != job.tenant {
+			return errBackfillRunning
+		}
+		return fmt.Errorf("%w: %s", errBackfillRunning, r.running.progress.ID)
+	}
+	r.running = job
+	r.jobs[job.progress.ID] = job
+	return nil
+}
+
+// markFinished marks the running job finished, forgetting the oldest finished
+// jobs beyond maxFinishedBackfills
+func (r *backfillRegistry) markFinished(job *backfillJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == job {
+		r.running = nil
+	}
+	r.finished = append(r.finished, job.progress.ID)
+	for len(r.finished) > maxFinishedBackfills {
+		delete(r.jobs, r.finished[0])
+		r.finished = r.finished[1:]
+	}
+}
+
+// get returns the job with the given ID if it belongs to t
+func (r *backfillRegistry) get(id string, t *tenant) (*backfillJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok || job.tenant != t {
+		return nil, false
+	}
+	return job, true
+}
+
+// cancelRunning cancels the running backfill, if any
+func (r *backfillRegistry) cancelRunning() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running != nil {
+		r.running.cancel()
+	}
+}
+
+// startBackfillHandler handles POST /collect/backfill. It validates the
+// request, starts collecting each window in the background and answers 202
+// with the job's progress; GET /collect/backfill/:id reports later progress.
+func (s *Server) startBackfillHandler(c *gin.Context) {
+	config := s.config()
+	if len(config.Workload) == 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeNoWorkloads, "No source workloads configured in ocs_config.yaml")
+		return
+	}
+
+	fromStr := c.Query("from")
+	if fromStr == "" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidTimestamp, "from is required")
+		return
+	}
+	from, err := parseTimestamp(fromStr)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidTimestamp, fmt.Sprintf("invalid from: %v", err))
+		return
+	}
+	to := time.Now()
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := parseTimestamp(toStr)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidTimestamp, fmt.Sprintf("invalid to: %v", err))
+			return
+		}
+		to = *parsed
+	}
+	if !from.Before(to) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidTimestamp, "from must be before to")
+		return
+	}
+	if to.After(time.Now()) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidTimestamp, "to must not be in the future")
+		return
+	}
+	// Snapshots older than the retention period would be expired as soon as they are saved
+	if retention := config.retention(); retention > 0 && from.Before(time.Now().Add(-retention)) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidTimestamp, fmt.Sprintf("from is older than retention_days (%d), its snapshots would expire immediately", *config.RetentionDays))
+		return
+	}
+
+	interval := defaultBackfillInterval
+	if intervalStr := c.Query("interval"); intervalStr != "" {
+		interval, err = time.ParseDuration(intervalStr)
+		if err != nil || interval <= 0 {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "interval must be a positive duration, e.g. 1h")
+			return
+		}
+	}
+
+	concurrency, err := parseIntParam(c, "concurrency", defaultBackfillConcurrency)
+	if err != nil || concurrency < 1 || concurrency > maxBackfillConcurrency {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("concurrency must be between 1 and %d", maxBackfillConcurrency))
+		return
+	}
+
+	// Counted before splitting so a tiny interval can't allocate millions of windows
+	if count := (to.Sub(*from) + interval - 1) / interval; count > maxBackfillWindows {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("%d windows exceed the limit of %d; use a longer interval or a shorter range", count, maxBackfillWindows))
+		return
+	}
+
+	// Namespaces from the query parameter override the config, as for collect_istio_metrics
+	namespaces := config.Namespaces
+	if namespacesStr := c.Query("namespaces"); namespacesStr != "" {
+		namespaces = splitList(namespacesStr)
+		if err := prometheus.ValidateNames("namespace", namespaces); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	windows := backfillWindows(*from, to, interval)
+
+	// The job outlives the request; it stops when cancelled or the server shuts down
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &backfillJob{
+		tenant: tenantOf(c),
+		cancel: cancel,
+		done:   make(chan struct{}),
+		progress: BackfillProgress{
+			ID:           newRequestID(),
+			Status:       BackfillStatusRunning,
+			From:         from.Format(time.RFC3339),
+			To:           to.Format(time.RFC3339),
+			Interval:     interval.String(),
+			Concurrency:  concurrency,
+			TotalWindows: len(windows),
+			StartedAt:    time.Now().Format(time.RFC3339),
+		},
+	}
+	if err := s.backfills.start(job); err != nil {
+		cancel()
+		respondError(c, http.StatusConflict, ErrCodeBackfillRunning, err.Error())
+		return
+	}
+
+	logger := requestLogger(c).With("backfill_id", job.progress.ID)
+	logger.Info("Starting backfill", "from", job.progress.From, "to", job.progress.To, "interval", job.progress.Interval, "window_count", len(windows), "concurrency", concurrency)
+
+	queryOpts := prometheus.QueryOptions{
+		Logger:        prometheus.NewQueryLogger(logger, true),
+		DecayHalfLife: config.edgeDecayHalfLife(),
+		MetricName:    config.MetricName,
+		Namespaces:    namespaces,
+		ExtraFilters:  config.ExtraFilters,
+		Reporter:      config.Reporter,
+	}
+	go s.runBackfill(ctx, job, windows, config, queryOpts, logger)
+
+	c.JSON(http.StatusAccepted, job.snapshot())
+}
+
+// runBackfill collects and saves each window with up to the job's concurrency
+// windows in flight, then marks the job finished. Windows that fail are
+// recorded and skipped so one bad hour doesn't lose the rest of the history.
+func (s *Server) runBackfill(ctx context.Context, job *backfillJob, windows []backfillWindow, config *OCSConfig, opts prometheus.QueryOptions, logger *slog.Logger) {
+	defer close(job.done)
+	defer s.backfills.markFinished(job)
+	defer job.cancel()
+
+	pending := make(chan backfillWindow)
+	var wg sync.WaitGroup
+	for i := 0; i < job.progress.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for window := range pending {
+				err := s.backfillWindow(ctx, job.tenant, window, config, opts)
+				if ctx.Err() != nil {
+					// Cancelled mid-window; neither saved nor a failure
+					return
+				}
+				if err != nil {
+					logger.Warn("Backfill window failed", "from", window.From, "to", window.To, "error", err)
+				}
+				job.record(window, err)
+			}
+		}()
+	}
+
+dispatch:
+	for _, window := range windows {
+		select {
+		case pending <- window:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	progress := job.finish(ctx)
+	if progress.Saved > 0 {
+		// A backfill into an empty database may have saved the latest snapshot
+		job.tenant.promptCache.Invalidate()
+		s.notifier.Publish()
+	}
+	logger.Info("Finished backfill", "status", progress.Status, "saved", progress.Saved, "failed", progress.Failed, "total", progress.TotalWindows)
+}
+
+// backfillWindow collects one window with a range query and saves it as a
+// snapshot timestamped at the window's end
+func (s *Server) backfillWindow(ctx context.Context, t *tenant, window backfillWindow, config *OCSConfig, opts prometheus.QueryOptions) error {
+	result, err := s.istioConnector.QueryMetrics(ctx, config.Workload, &window.From, &window.To, opts)
+	if err != nil {
+		return fmt.Errorf("failed to query Prometheus: %w", err)
+	}
+	graph := extractTopology(result, config, opts.Logger.Logger())
+	if _, err := t.repo.SaveAdjacencyListAt(window.To, graph); err != nil {
+		return fmt.Errorf("failed to save to MongoDB: %w", err)
+	}
+	return nil
+}
+
+// getBackfillHandler handles GET /collect/backfill/:id, reporting a backfill's progress
+func (s *Server) getBackfillHandler(c *gin.Context) {
+	job, ok := s.backfills.get(c.Param("id"), tenantOf(c))
+	if !ok {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Backfill not found")
+		return
+	}
+	c.JSON(http.StatusOK, job.snapshot())
+}
+
+// cancelBackfillHandler handles DELETE /collect/backfill/:id. It cancels the
+// backfill and waits for in-flight windows to stop, so the reported progress
+// is final. Windows already saved are kept.
+func (s *Server) cancelBackfillHandler(c *gin.Context) {
+	job, ok := s.backfills.get(c.Param("id"), tenantOf(c))
+	if !ok {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Backfill not found")
+		return
+	}
+	job.cancel()
+	select {
+	case <-job.done:
+	case <-c.Request.Context().Done():
+		return
+	}
+	requestLogger(c).Info("Cancelled backfill", "backfill_id", c.Param("id"))
+	c.JSON(http.StatusOK, job.snapshot())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBackfillWindows(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(2*time.Hour + 30*time.Minute)
+
+	windows := backfillWindows(from, to, time.Hour)
+	if len(windows) != 3 {
+		t.Fatalf("got %d windows, want 3", len(windows))
+	}
+	for i, window := range windows {
+		if i > 0 && !window.From.Equal(windows[i-1].To) {
+			t.Errorf("window %d starts at %s, want the previous end %s", i, window.From, windows[i-1].To)
+		}
+	}
+	if !windows[0].From.Equal(from) {
+		t.Errorf("first window starts at %s, want %s", windows[0].From, from)
+	}
+	if last := windows[2]; !last.To.Equal(to) || last.To.Sub(last.From) != 30*time.Minute {
+		t.Errorf("last window = %s..%s, want a 30m window ending at %s", last.From, last.To, to)
+	}
+
+	if windows := backfillWindows(from, from.Add(2*time.Hour), time.Hour); len(windows) != 2 {
+		t.Errorf("an exact multiple gave %d windows, want 2", len(windows))
+	}
+}
+
+func TestStartBackfillRejectsInvalidNamespaces(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{
+		ocsConfig:     &OCSConfig{Workload: []string{"frontend"}},
+		defaultTenant: newTenant("", &fakeStore{}),
+	}
+	router := gin.New()
+	router.POST("/collect/backfill", s.resolveTenant, s.startBackfillHandler)
+
+	query := url.Values{
+		"from":       {time.Now().Add(-2 * time.Hour).Format(time.RFC3339)},
+		"namespaces": {"default,bad\x01ns"},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/collect/backfill?"+query.Encode(), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// promptCache holds the most recently built OCS prompt for the running
+// config. Entries expire after a TTL and are invalidated whenever a new
+// snapshot is saved; a config reload changes the config the entry was built
+// for, which also misses.
+type promptCache struct {
+	mu         sync.Mutex
+	response   *OCSPromptResponse
+	config     *OCSConfig
+	expires    time.Time
+	generation uint64 // Incremented by Invalidate
+}
+
+// newPromptCache creates an empty prompt cache
+func newPromptCache() *promptCache {
+	return &promptCache{}
+}
+
+// Get returns the cached prompt if it was built for config and has not
+// expired. It also returns the cache generation to pass to Set, so a prompt
+// built from a snapshot that was superseded in the meantime isn't cached.
+func (pc *promptCache) Get(config *OCSConfig) (*OCSPromptResponse, uint64, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.response == nil || pc.config != config || time.Now().After(pc.expires) {
+		return nil, pc.generation, false
+	}
+	return pc.response, pc.generation, true
+}
+
+// Set caches a prompt built for config for ttl, unless the cache was
+// invalidated since generation was obtained from Get
+func (pc *promptCache) Set(generation uint64, config *OCSConfig, response *OCSPromptResponse, ttl time.Duration) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if generation != pc.generation {
+		return
+	}
+	pc.response = response
+	pc.config = config
+	pc.expires = time.Now().Add(ttl)
+}
+
+// Invalidate drops the cached prompt
+func (pc *promptCache) Invalidate() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.response = nil
+	pc.config = nil
+	pc.generation++
+}
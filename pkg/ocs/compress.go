@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipMinSize is the smallest response body worth compressing; below it the
+// gzip header and CPU cost outweigh the savings
+const gzipMinSize = 1024
+
+// gzipMiddleware compresses response bodies of at least gzipMinSize bytes for
+// clients that accept gzip. The body is buffered so its size is known before
+// headers are sent, letting small responses pass through unchanged and
+// Content-Length be set on compressed ones. Responses that already carry a
+// Content-Encoding, such as /metrics, are left alone, and so are streams
+// that flush as they go.
+func gzipMiddleware(c *gin.Context) {
+	c.Header("Vary", "Accept-Encoding")
+	if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Next()
+		return
+	}
+
+	w := &bufferedWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	defer func() { c.Writer = w.ResponseWriter }()
+
+	c.Next()
+	w.flush()
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// bufferedWriter holds the response body until the handler chain finishes,
+// unless the handler flushes it to stream the response
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	streaming bool // Set by Flush; writes then go straight to the client uncompressed
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.WriteString(s)
+	}
+	return w.body.WriteString(s)
+}
+
+// WriteHeaderNow is deferred to flush so headers can still change
+func (w *bufferedWriter) WriteHeaderNow() {
+	if w.streaming {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// Flush switches to streaming, since a response flushed as it is written
+// can't be measured first: the body so far is sent as is, and so is
+// everything written after it
+func (w *bufferedWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.ResponseWriter.WriteHeaderNow()
+		w.ResponseWriter.Write(w.body.Bytes())
+		w.body.Reset()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to set write deadlines
+func (w *bufferedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush writes the buffered body, compressed if it is large enough
+func (w *bufferedWriter) flush() {
+	if w.streaming {
+		return
+	}
+	body := w.body.Bytes()
+	header := w.Header()
+
+	if len(body) >= gzipMinSize && header.Get("Content-Encoding") == "" && bodyAllowed(w.Status()) {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(body); err == nil && gz.Close() == nil {
+			header.Set("Content-Encoding", "gzip")
+			body = compressed.Bytes()
+		}
+	}
+
+	if len(body) > 0 {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		header.Del("Transfer-Encoding")
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	w.ResponseWriter.Write(body)
+}
+
+// bodyAllowed reports whether a response with the given status may have a body
+func bodyAllowed(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
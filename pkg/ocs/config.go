@@ -1,22 +1,67 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+)
+
+const (
+	// defaultOCSConfigPath and defaultPrometheusConfigPath are used when no
+	// path is given by flag or environment variable
+	defaultOCSConfigPath        = "pkg/ocs/ocs_config.yaml"
+	defaultPrometheusConfigPath = "config/prometheus_config.yaml"
+	// defaultPromptCacheTTL is how long get_ocs_prompt responses are cached when not configured
+	defaultPromptCacheTTL = 30 * time.Second
+	// defaultErrorRateThreshold is the error rate above which /topology/errors reports an edge
+	defaultErrorRateThreshold = 0.05
+	// defaultSpecVersion and defaultDomain describe prompts when not configured
+	defaultSpecVersion = "0.1"
+	defaultDomain      = "compute.k8s"
+	// defaultUnknownWorkload is the workload name exclude_unknown drops when unknown_workloads is not set
+	defaultUnknownWorkload = "unknown"
+	// defaultCollectionWindow is the range forced by collection_mode range when
+	// neither timestamps nor time_window_minutes are given
+	defaultCollectionWindow = 5 * time.Minute
 )
 
-// loadOCSConfig loads the OCS configuration from YAML file
-func loadOCSConfig() (*OCSConfig, error) {
-	configPath := filepath.Join(filepath.Dir(os.Args[0]), "pkg/ocs/ocs_config.yaml")
-	// Try relative path if absolute doesn't work
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		configPath = "pkg/ocs/ocs_config.yaml"
+// envOrDefault returns the value of the environment variable key, or def if it is unset or empty
+func envOrDefault(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
 	}
+	return def
+}
 
+// loadOCSConfig loads the OCS configuration from the YAML file at configPath.
+// When the file doesn't exist but OCS config environment variables are set,
+// the config is built from them instead, so containers can run without
+// mounting a file.
+func loadOCSConfig(configPath string) (*OCSConfig, error) {
 	data, err := os.ReadFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		config, envErr := ocsConfigFromEnv()
+		if envErr != nil {
+			return nil, envErr
+		}
+		if config != nil {
+			slog.Info("OCS config file not found, using config from environment", "path", configPath)
+			return config, nil
+		}
+		return nil, fmt.Errorf("failed to read OCS config: %w (or set OCS_WORKLOADS to configure from the environment)", err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read OCS config: %w", err)
 	}
@@ -29,27 +74,390 @@ func loadOCSConfig() (*OCSConfig, error) {
 	return &config, nil
 }
 
-// loadPrometheusConfig loads Prometheus configuration
-func loadPrometheusConfig() (*PrometheusConfig, error) {
-	configPath := "config/prometheus_config.yaml"
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("prometheus config not found: %s", configPath)
+// ocsConfigFromEnv builds an OCS config from OCS_WORKLOADS, OCS_NAMESPACES,
+// OCS_TIME_WINDOW_MINUTES, OCS_METRIC_NAME, OCS_LOG_LEVEL and OCS_LOG_FORMAT,
+// leaving everything else at its default. It returns nil when none is set.
+// Required fields are left to Validate like those of a file.
+func ocsConfigFromEnv() (*OCSConfig, error) {
+	config := &OCSConfig{
+		Workload:   splitList(os.Getenv("OCS_WORKLOADS")),
+		Namespaces: splitList(os.Getenv("OCS_NAMESPACES")),
+		MetricName: os.Getenv("OCS_METRIC_NAME"),
+		LogLevel:   os.Getenv("OCS_LOG_LEVEL"),
+		LogFormat:  os.Getenv("OCS_LOG_FORMAT"),
 	}
+	set := len(config.Workload) > 0 || len(config.Namespaces) > 0 || config.MetricName != "" || config.LogLevel != "" || config.LogFormat != ""
 
-	data, err := os.ReadFile(configPath)
+	if value := os.Getenv("OCS_TIME_WINDOW_MINUTES"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OCS_TIME_WINDOW_MINUTES %q: %w", value, err)
+		}
+		config.TimeWindowMinutes = &minutes
+		set = true
+	}
+
+	if !set {
+		return nil, nil
+	}
+	return config, nil
+}
+
+// metricTypes are the recognized values of a metric's type
+var metricTypes = map[string]bool{
+	"counter":   true,
+	"gauge":     true,
+	"histogram": true,
+	"summary":   true,
+}
+
+// specVersions are the OCS spec versions prompts can be generated for
+var specVersions = []string{"0.1"}
+
+// domainPattern matches dot-separated resource domains such as compute.k8s
+var domainPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*(\.[a-z0-9]+(-[a-z0-9]+)*)*$`)
+
+// ConfigValidationError lists every problem found in an OCS config
+type ConfigValidationError struct {
+	Problems []string
+	// Fields holds the path of the field each problem is about, such as
+	// metrics[0].type, in the same order as Problems
+	Fields []string
+}
+
+func (e *ConfigValidationError) Error() string {
+	return fmt.Sprintf("%d problem(s) found: %s", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// Validate checks the config for missing required fields and out-of-range
+// values. It reports every problem found as a *ConfigValidationError.
+func (c *OCSConfig) Validate() error {
+	var problems, fields []string
+	addf := func(field, format string, v ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, v...))
+		fields = append(fields, field)
+	}
+
+	for i, metric := range c.Metrics {
+		path := fmt.Sprintf("metrics[%d]", i)
+		if metric.Name == "" {
+			addf(path+".name", "metrics[%d]: name is required", i)
+		}
+		if !metricTypes[strings.ToLower(metric.Type)] {
+			addf(path+".type", "metrics[%d]: unrecognized type %q, must be one of counter, gauge, histogram or summary", i, metric.Type)
+		}
+		if err := validateUnit(metric.Unit); err != nil {
+			addf(path+".unit", "metrics[%d]: %v", i, err)
+		}
+		thresholds, err := parseHealthThresholds(metric.HealthConfig)
+		if err != nil {
+			addf(path+".health_config", "metrics[%d]: invalid health_config: %v", i, err)
+		}
+		if thresholds != nil && thresholds.query == "" {
+			if !prometheus.IsValidMetricName(metric.Name) {
+				addf(path+".name", "metrics[%d]: name %q is not a valid PromQL metric name, set health_config.query to score its health", i, metric.Name)
+			}
+			if _, ok := healthAggregations[strings.ToLower(metric.AggregationLogic)]; !ok {
+				addf(path+".aggregation_logic", "metrics[%d]: aggregation_logic %q cannot be used for health scoring: must be average, sum, max or min", i, metric.AggregationLogic)
+			}
+		}
+	}
+
+	if len(c.Workload) == 0 {
+		addf("workload", "workload: at least one source workload is required")
+	}
+	seen := make(map[string]bool, len(c.Workload))
+	for i, workload := range c.Workload {
+		if workload == "" {
+			addf(fmt.Sprintf("workload[%d]", i), "workload[%d]: name must not be empty", i)
+		} else if seen[workload] {
+			addf(fmt.Sprintf("workload[%d]", i), "workload[%d]: %q is listed more than once", i, workload)
+		} else if err := prometheus.ValidateNames("workload", []string{workload}); err != nil {
+			addf(fmt.Sprintf("workload[%d]", i), "workload[%d]: %v", i, err)
+		}
+		seen[workload] = true
+	}
+
+	for i, namespace := range c.Namespaces {
+		if namespace == "" {
+			addf(fmt.Sprintf("namespaces[%d]", i), "namespaces[%d]: name must not be empty", i)
+		} else if err := prometheus.ValidateNames("namespace", []string{namespace}); err != nil {
+			addf(fmt.Sprintf("namespaces[%d]", i), "namespaces[%d]: %v", i, err)
+		}
+	}
+
+	if c.TimeWindowMinutes != nil && *c.TimeWindowMinutes <= 0 {
+		addf("time_window_minutes", "time_window_minutes must be positive, got %d", *c.TimeWindowMinutes)
+	}
+
+	if c.RetentionDays != nil && *c.RetentionDays <= 0 {
+		addf("retention_days", "retention_days must be positive, got %d", *c.RetentionDays)
+	}
+
+	if c.SnapshotBucketSeconds != nil && *c.SnapshotBucketSeconds <= 0 {
+		addf("snapshot_bucket_seconds", "snapshot_bucket_seconds must be positive, got %d", *c.SnapshotBucketSeconds)
+	}
+
+	if c.PromptCacheTTLSeconds != nil && *c.PromptCacheTTLSeconds < 0 {
+		addf("prompt_cache_ttl_seconds", "prompt_cache_ttl_seconds must not be negative, got %d", *c.PromptCacheTTLSeconds)
+	}
+	if c.MaxContextDefinitions != nil && *c.MaxContextDefinitions <= 0 {
+		addf("max_context_definitions", "max_context_definitions must be positive, got %d", *c.MaxContextDefinitions)
+	}
+
+	if c.ErrorRateThreshold != nil && (*c.ErrorRateThreshold < 0 || *c.ErrorRateThreshold > 1) {
+		addf("error_rate_threshold", "error_rate_threshold must be between 0 and 1, got %g", *c.ErrorRateThreshold)
+	}
+
+	if c.ErrorClassification != nil {
+		if err := c.ErrorClassification.Validate(); err != nil {
+			addf("error_classification", "invalid error_classification: %v", err)
+		}
+	}
+
+	if c.EdgeDecayHalfLifeMinutes != nil && *c.EdgeDecayHalfLifeMinutes < 0 {
+		addf("edge_decay_half_life_minutes", "edge_decay_half_life_minutes must not be negative, got %g", *c.EdgeDecayHalfLifeMinutes)
+	}
+
+	if c.MetricName != "" && !prometheus.IsValidMetricName(c.MetricName) {
+		addf("metric_name", "metric_name %q is not a valid PromQL metric name", c.MetricName)
+	}
+
+	if !isValidCollectionMode(c.CollectionMode) {
+		addf("collection_mode", "invalid collection_mode %q: must be %q or %q", c.CollectionMode, CollectionModeInstant, CollectionModeRange)
+	}
+
+	for i, label := range c.IdentityLabels {
+		switch {
+		case !prometheus.IsValidLabelName(label):
+			addf(fmt.Sprintf("identity_labels[%d]", i), "identity_labels entry %q is not a valid label name", label)
+		case label == "workload" || label == "workload_namespace":
+			addf(fmt.Sprintf("identity_labels[%d]", i), "identity_labels entry %q is already part of the identity", label)
+		case label == prometheus.RawWorkloadLabel:
+			addf(fmt.Sprintf("identity_labels[%d]", i), "identity_labels entry %q is reserved for names rewritten by workload_rules and workload_aliases", label)
+		}
+	}
+
+	for i, workload := range c.UnknownWorkloads {
+		if workload == "" {
+			addf(fmt.Sprintf("unknown_workloads[%d]", i), "unknown_workloads[%d]: name must not be empty", i)
+		}
+	}
+
+	if err := prometheus.ValidateExtraFilters(c.ExtraFilters); err != nil {
+		addf("extra_filters", "invalid extra_filters: %v", err)
+	}
+	if err := prometheus.ValidateReporter(c.Reporter); err != nil {
+		addf("reporter", "invalid reporter: %v", err)
+	}
+
+	if _, err := prometheus.NewWorkloadNormalizer(c.WorkloadRules, c.WorkloadAliases); err != nil {
+		addf("workload_rules", "invalid workload normalization: %v", err)
+	}
+
+	if c.SpecVersion != "" && !slices.Contains(specVersions, c.SpecVersion) {
+		addf("spec_version", "unsupported spec_version %q: must be one of %s", c.SpecVersion, strings.Join(specVersions, ", "))
+	}
+
+	if c.Domain != "" && !domainPattern.MatchString(c.Domain) {
+		addf("domain", "domain %q must be lowercase dot-separated names, e.g. %q", c.Domain, defaultDomain)
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "", "info", "debug":
+	default:
+		addf("log_level", "invalid log_level %q: must be \"info\" or \"debug\"", c.LogLevel)
+	}
+
+	switch strings.ToLower(c.LogFormat) {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		addf("log_format", "invalid log_format %q: must be %q or %q", c.LogFormat, LogFormatText, LogFormatJSON)
+	}
+
+	if len(problems) > 0 {
+		return &ConfigValidationError{Problems: problems, Fields: fields}
+	}
+	return nil
+}
+
+// unknownWorkloads returns the workload names exclude_unknown drops
+func (c *OCSConfig) unknownWorkloads() []string {
+	if len(c.UnknownWorkloads) == 0 {
+		return []string{defaultUnknownWorkload}
+	}
+	return c.UnknownWorkloads
+}
+
+// workloadNormalizer returns the normalizer for workload_rules and
+// workload_aliases, or nil when neither is configured
+func (c *OCSConfig) workloadNormalizer() *prometheus.WorkloadNormalizer {
+	normalizer, err := prometheus.NewWorkloadNormalizer(c.WorkloadRules, c.WorkloadAliases)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read Prometheus config: %w", err)
+		// Invalid rules are rejected by Validate
+		return nil
 	}
+	return normalizer
+}
 
-	var config PrometheusConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse Prometheus config: %w", err)
+// promptCacheTTL returns how long get_ocs_prompt responses are cached, or zero to disable caching
+func (c *OCSConfig) promptCacheTTL() time.Duration {
+	if c.PromptCacheTTLSeconds == nil {
+		return defaultPromptCacheTTL
+	}
+	return time.Duration(*c.PromptCacheTTLSeconds) * time.Second
+}
+
+// maxContextDefinitions returns how many context definitions get_ocs_prompt
+// returns at most, or zero for no cap
+func (c *OCSConfig) maxContextDefinitions() int {
+	if c.MaxContextDefinitions == nil {
+		return 0
 	}
+	return *c.MaxContextDefinitions
+}
 
-	if len(config.PrometheusInstances) == 0 {
-		return nil, fmt.Errorf("no Prometheus instances configured")
+// parseConfigStrict parses an OCS config from body, in either JSON or YAML
+// with the config file's field names. Unlike the config file, unknown fields
+// are rejected so a misspelled field is reported rather than ignored.
+func parseConfigStrict(body []byte) (*OCSConfig, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, errors.New("request body is empty")
 	}
 
+	var config OCSConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
+// parseCollectOverrides parses a collection request body of OCS config
+// overrides. YAML is a superset of JSON, so either format is accepted with
+// the config file's field names; unknown fields are rejected so a typo isn't
+// silently ignored. An empty body yields nil.
+func parseCollectOverrides(body []byte) (*CollectOverrides, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	var overrides CollectOverrides
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&overrides); err != nil {
+		return nil, err
+	}
+	return &overrides, nil
+}
+
+// apply returns a copy of config with the overridden fields replaced and
+// validated like a config file. config itself is left unchanged.
+func (o *CollectOverrides) apply(config *OCSConfig) (*OCSConfig, error) {
+	overridden := *config
+	if o.Workload != nil {
+		overridden.Workload = o.Workload
+	}
+	if o.Namespaces != nil {
+		overridden.Namespaces = o.Namespaces
+	}
+	if o.MetricName != "" {
+		overridden.MetricName = o.MetricName
+	}
+
+	if err := overridden.Validate(); err != nil {
+		return nil, err
+	}
+	return &overridden, nil
+}
+
+// isValidCollectionMode reports whether mode is a collection mode; empty selects by timestamps
+func isValidCollectionMode(mode string) bool {
+	return mode == "" || mode == CollectionModeInstant || mode == CollectionModeRange
+}
+
+// collectionWindow returns how far back a range query reaches when no timestamps are given
+func (c *OCSConfig) collectionWindow() time.Duration {
+	if c.TimeWindowMinutes == nil {
+		return defaultCollectionWindow
+	}
+	return time.Duration(*c.TimeWindowMinutes) * time.Minute
+}
+
+// defaultCollectionRange returns the range to query when no timestamps are
+// given: the collection window ending at now when mode forces a range query,
+// or when no mode is set and time_window_minutes is configured. Otherwise it
+// returns nils for an instant query.
+func (c *OCSConfig) defaultCollectionRange(mode string, now time.Time) (*time.Time, *time.Time) {
+	if mode == CollectionModeRange || (mode == "" && c.TimeWindowMinutes != nil) {
+		from := now.Add(-c.collectionWindow())
+		return &from, &now
+	}
+	return nil, nil
+}
+
+// specVersion returns the OCS spec version prompts are generated for
+func (c *OCSConfig) specVersion() string {
+	if c.SpecVersion == "" {
+		return defaultSpecVersion
+	}
+	return c.SpecVersion
+}
+
+// domain returns the domain context definitions are generated in
+func (c *OCSConfig) domain() string {
+	if c.Domain == "" {
+		return defaultDomain
+	}
+	return c.Domain
+}
+
+// errorRateThreshold returns the default error rate above which edges are reported as failing
+func (c *OCSConfig) errorRateThreshold() float64 {
+	if c.ErrorRateThreshold == nil {
+		return defaultErrorRateThreshold
+	}
+	return *c.ErrorRateThreshold
+}
+
+// errorClassification returns the rules classifying requests as errors for
+// error_rates. Response codes default to 5xx when none are listed, so
+// listing only response flags adds them to the conventional rule.
+func (c *OCSConfig) errorClassification() prometheus.ErrorClassification {
+	var classification prometheus.ErrorClassification
+	if c.ErrorClassification != nil {
+		classification = *c.ErrorClassification
+	}
+	if classification.ResponseCodes == nil {
+		classification.ResponseCodes = prometheus.DefaultErrorResponseCodes
+	}
+	if classification.ResponseFlags == nil {
+		classification.ResponseFlags = []string{}
+	}
+	return classification
+}
+
+// edgeDecayHalfLife returns the configured edge weight half-life, or zero for no decay
+func (c *OCSConfig) edgeDecayHalfLife() time.Duration {
+	if c.EdgeDecayHalfLifeMinutes == nil {
+		return 0
+	}
+	return time.Duration(*c.EdgeDecayHalfLifeMinutes * float64(time.Minute))
+}
+
+// snapshotBucket returns the time bucket collections are merged within, or zero to save each separately
+func (c *OCSConfig) snapshotBucket() time.Duration {
+	if c.SnapshotBucketSeconds == nil {
+		return 0
+	}
+	return time.Duration(*c.SnapshotBucketSeconds) * time.Second
+}
+
+// retention returns how long topology snapshots are kept, or zero to keep them forever
+func (c *OCSConfig) retention() time.Duration {
+	if c.RetentionDays == nil {
+		return 0
+	}
+	return time.Duration(*c.RetentionDays) * 24 * time.Hour
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+)
+
+func TestCollectOverrides(t *testing.T) {
+	config := &OCSConfig{
+		Workload:   []string{"app", "database"},
+		Namespaces: []string{"default"},
+	}
+
+	overrides, err := parseCollectOverrides([]byte(`{"workload": ["checkout"], "metric_name": "istio_tcp_connections_opened_total"}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	overridden, err := overrides.apply(config)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !reflect.DeepEqual(overridden.Workload, []string{"checkout"}) || overridden.MetricName != "istio_tcp_connections_opened_total" {
+		t.Errorf("overrides not applied: %+v", overridden)
+	}
+	if !reflect.DeepEqual(overridden.Namespaces, []string{"default"}) {
+		t.Errorf("namespaces = %v, want the config's", overridden.Namespaces)
+	}
+	if !reflect.DeepEqual(config.Workload, []string{"app", "database"}) || config.MetricName != "" {
+		t.Errorf("running config was modified: %+v", config)
+	}
+
+	if overrides, err := parseCollectOverrides([]byte("  \n")); overrides != nil || err != nil {
+		t.Errorf("empty body: got %v, %v; want no overrides", overrides, err)
+	}
+	if _, err := parseCollectOverrides([]byte(`{"workloads": ["typo"]}`)); err == nil {
+		t.Error("expected unknown fields to be rejected")
+	}
+
+	invalid, _ := parseCollectOverrides([]byte(`{"workload": ["a", "a"], "metric_name": "not a metric"}`))
+	var validationErr *ConfigValidationError
+	if _, err := invalid.apply(config); !errors.As(err, &validationErr) || len(validationErr.Problems) != 2 {
+		t.Errorf("expected two validation problems, got %v", err)
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	var config OCSConfig
+	if err := yaml.Unmarshal([]byte("error_classification:\n  response_flags: [UH, UF]\n"), &config); err != nil {
+		t.Fatal(err)
+	}
+	got := config.errorClassification()
+	want := prometheus.ErrorClassification{ResponseCodes: []string{"5xx"}, ResponseFlags: []string{"UH", "UF"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errorClassification() = %+v, want flags added to the 5xx default %+v", got, want)
+	}
+
+	if got := (&OCSConfig{}).errorClassification(); !reflect.DeepEqual(got.ResponseCodes, []string{"5xx"}) || len(got.ResponseFlags) != 0 {
+		t.Errorf("default errorClassification() = %+v, want 5xx only", got)
+	}
+}
+
+func TestLoadOCSConfigFromEnv(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "ocs_config.yaml")
+	if _, err := loadOCSConfig(missing); err == nil {
+		t.Fatal("missing file without environment config was accepted")
+	}
+
+	t.Setenv("OCS_WORKLOADS", "frontend, cart")
+	t.Setenv("OCS_TIME_WINDOW_MINUTES", "15")
+	config, err := loadOCSConfig(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Workload, []string{"frontend", "cart"}) || config.TimeWindowMinutes == nil || *config.TimeWindowMinutes != 15 {
+		t.Errorf("config = %+v, want workloads and time window from the environment", config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("environment config is invalid: %v", err)
+	}
+
+	// A config file takes precedence over the environment
+	if err := os.WriteFile(missing, []byte("workload: [checkout]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := loadOCSConfig(missing); err != nil || !reflect.DeepEqual(config.Workload, []string{"checkout"}) {
+		t.Errorf("with a file, config = %+v, %v; want the file's workloads", config, err)
+	}
+
+	t.Setenv("OCS_TIME_WINDOW_MINUTES", "soon")
+	if _, err := loadOCSConfig(filepath.Join(t.TempDir(), "absent.yaml")); err == nil {
+		t.Error("invalid OCS_TIME_WINDOW_MINUTES was accepted")
+	}
+}
+
+func TestValidateRejectsUnquotableNames(t *testing.T) {
+	config := &OCSConfig{Workload: []string{"api.v1", "line\nbreak"}, Namespaces: []string{"\xff"}}
+	var validationErr *ConfigValidationError
+	if err := config.Validate(); !errors.As(err, &validationErr) || len(validationErr.Problems) != 2 {
+		t.Errorf("Validate() = %v, want problems for the control character and the invalid UTF-8", err)
+	}
+}
+
+func TestValidateConfigHandler(t *testing.T) {
+	router := gin.New()
+	router.POST("/config/validate", validateConfigHandler)
+	validate := func(body string) (int, ErrorResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/config/validate", strings.NewReader(body)))
+		var resp ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+
+	if code, resp := validate("workload: [app]\nmetrics:\n  - {name: latency, type: gauge, unit: ms}\n"); code != http.StatusOK || resp.Status != "success" {
+		t.Errorf("valid config: %d %+v, want 200 success", code, resp)
+	}
+
+	code, resp := validate(`{"workload": [], "metrics": [{"name": "latency", "type": "meter"}], "time_window_minutes": 0}`)
+	problems, _ := resp.Details.([]interface{})
+	var fields []string
+	for _, problem := range problems {
+		fields = append(fields, problem.(map[string]interface{})["field"].(string))
+	}
+	want := []string{"metrics[0].type", "workload", "time_window_minutes"}
+	if code != http.StatusBadRequest || resp.Code != ErrCodeInvalidConfig || !reflect.DeepEqual(fields, want) {
+		t.Errorf("invalid config: %d %s with fields %v, want 400 invalid_config with fields %v", code, resp.Code, fields, want)
+	}
+
+	for _, body := range []string{"", "workload: [app]\nworkloads: [db]\n", "workload: [app"} {
+		if code, resp := validate(body); code != http.StatusBadRequest || resp.Code != ErrCodeInvalidConfig {
+			t.Errorf("body %q: %d %s, want 400 invalid_config", body, code, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+	"github.com/contexture/ocs/pkg/ocs/store"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// MetricsQuerier runs the Prometheus queries collections are built from.
+// *prometheus.IstioConnector is the real implementation; tests can inject fakes.
+type MetricsQuerier interface {
+	// QueryMetrics queries the topology metric for the source workloads, as a
+	// range query when from and to are set and an instant query otherwise
+	QueryMetrics(ctx context.Context, sourceWorkloads []string, from, to *time.Time, opts prometheus.QueryOptions) (*prometheus.QueryResult, error)
+	// Queries returns the PromQL QueryMetrics runs, without running it
+	Queries(sourceWorkloads []string, opts prometheus.QueryOptions) ([]string, error)
+	// CollectWorkloadMetrics returns the current per-workload value of each query's metric
+	CollectWorkloadMetrics(ctx context.Context, queries []prometheus.WorkloadMetricQuery, qualify bool, opts prometheus.QueryOptions) (topology.WorkloadMetrics, []error)
+	// Ping returns the error for every unreachable Prometheus instance
+	Ping() map[string]error
+	// InstanceCount returns the number of configured Prometheus instances
+	InstanceCount() int
+}
+
+// TopologyStore holds a tenant's topology snapshots.
+// *store.MongoDBRepository is the real implementation; tests can inject fakes.
+type TopologyStore interface {
+	// GetLatestSnapshot returns the newest snapshot, or nil when none has been saved
+	GetLatestSnapshot() (*store.AdjacencyListDocument, error)
+	// GetAdjacencyListByID returns a snapshot by its hex ID, failing with
+	// store.ErrInvalidSnapshotID or store.ErrSnapshotNotFound
+	GetAdjacencyListByID(id string) (*store.AdjacencyListDocument, error)
+	// ListAdjacencyLists returns a page of snapshots newest-first and the total count
+	ListAdjacencyLists(limit, offset int) ([]store.AdjacencyListDocument, int64, error)
+	// ListSnapshotsBetween returns up to limit snapshots taken between from and to inclusive, oldest-first
+	ListSnapshotsBetween(from, to time.Time, limit int) ([]store.AdjacencyListDocument, error)
+	// SaveAdjacencyList saves a snapshot taken now
+	SaveAdjacencyList(graph topology.Graph) (primitive.ObjectID, error)
+	// SaveAdjacencyListAt saves a snapshot taken at timestamp
+	SaveAdjacencyListAt(timestamp time.Time, graph topology.Graph) (primitive.ObjectID, error)
+	// SaveAdjacencyListInBucket saves a snapshot taken now, merging it into the
+	// snapshot of the same epoch-aligned time bucket if there is one
+	SaveAdjacencyListInBucket(bucket time.Duration, graph topology.Graph) (primitive.ObjectID, error)
+	// PruneOlderThan deletes snapshots taken before t and returns how many were deleted
+	PruneOlderThan(t time.Time) (int64, error)
+	// EnsureRetentionIndex has the store expire snapshots older than retention
+	EnsureRetentionIndex(retention time.Duration) error
+	// DatabaseName names where the snapshots are stored, for logging
+	DatabaseName() string
+}
+
+// StoreBackend is the snapshot storage shared by every tenant. It serves the
+// default tenant itself and creates the other tenants' stores.
+type StoreBackend interface {
+	TopologyStore
+	// Ping checks that the storage is reachable
+	Ping() error
+	// Close releases the storage's connections, including those of stores created from it
+	Close() error
+}
+
+// Store backends selectable with STORE_BACKEND
+const (
+	storeBackendMongoDB = "mongodb"
+	storeBackendMemory  = "memory"
+)
+
+// newStoreBackend connects to the snapshot storage selected by STORE_BACKEND:
+// MongoDB by default, or process memory for demos and tests that don't need
+// snapshots to survive a restart
+func newStoreBackend() (StoreBackend, error) {
+	switch backend := envOrDefault("STORE_BACKEND", storeBackendMongoDB); backend {
+	case storeBackendMongoDB:
+		repo, err := store.NewMongoDBRepository()
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize MongoDB: %w", err)
+		}
+		repo.OnWriteError = func(error) { mongodbWriteErrorsTotal.Inc() }
+		return repo, nil
+	case storeBackendMemory:
+		slog.Warn("Storing topology snapshots in memory; they are lost when the server stops")
+		return store.NewInMemoryRepository(), nil
+	default:
+		return nil, fmt.Errorf("invalid STORE_BACKEND %q: must be %q or %q", backend, storeBackendMongoDB, storeBackendMemory)
+	}
+}
+
+// forDatabase returns the store for another database of backend, as used
+// for each tenant's snapshots
+func forDatabase(backend StoreBackend, dbName string) (TopologyStore, error) {
+	switch b := backend.(type) {
+	case *store.MongoDBRepository:
+		return b.ForDatabase(dbName)
+	case *store.InMemoryRepository:
+		return b.ForDatabase(dbName)
+	default:
+		return nil, fmt.Errorf("store backend %T does not support tenants", backend)
+	}
+}
+
+var (
+	_ MetricsQuerier = (*prometheus.IstioConnector)(nil)
+	_ StoreBackend   = (*store.MongoDBRepository)(nil)
+	_ StoreBackend   = (*store.InMemoryRepository)(nil)
+)
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+)
+
+// Error codes returned in ErrorResponse.Code that clients can switch on
+const (
+	ErrCodeInvalidRequest        = "invalid_request"
+	ErrCodeInvalidConfig         = "invalid_config"
+	ErrCodeInvalidTimestamp      = "invalid_timestamp"
+	ErrCodeNoWorkloads           = "no_workloads_configured"
+	ErrCodePrometheusUnreachable = "prometheus_unreachable"
+	ErrCodePrometheusTimeout     = "prometheus_timeout"
+	ErrCodePrometheusQueryFailed = "prometheus_query_failed"
+	ErrCodePromQLError           = "promql_error"
+	ErrCodeUnexpectedResultType  = "unexpected_result_type"
+	ErrCodeDatabaseError         = "database_error"
+	ErrCodeSnapshotTooLarge      = "snapshot_too_large"
+	ErrCodeNotFound              = "not_found"
+	ErrCodeUnauthorized          = "unauthorized"
+	ErrCodeRateLimited           = "rate_limited"
+	ErrCodeBackfillRunning       = "backfill_running"
+	ErrCodeInvalidTenant         = "invalid_tenant"
+)
+
+// respondError writes an ErrorResponse with the given HTTP status, error code and message
+func respondError(c *gin.Context, status int, code, message string) {
+	respondErrorDetails(c, status, code, message, nil)
+}
+
+// respondErrorDetails writes an ErrorResponse with additional details
+func respondErrorDetails(c *gin.Context, status int, code, message string, details interface{}) {
+	c.JSON(status, ErrorResponse{
+		Status:    "error",
+		Message:   message,
+		Code:      code,
+		Details:   details,
+		RequestID: requestID(c),
+	})
+}
+
+// statusClientClosedRequest is logged for requests abandoned because the
+// client disconnected; nothing is written since no one is listening
+const statusClientClosedRequest = 499
+
+// prometheusErrorCode classifies a Prometheus query error. Errors reported
+// by the Prometheus API surface as *prometheus.QueryError, transport
+// failures from the HTTP client as *url.Error. Results of the wrong type
+// surface as *prometheus.ResultTypeError.
+func prometheusErrorCode(err error) string {
+	var queryErr *prometheus.QueryError
+	if errors.As(err, &queryErr) {
+		return ErrCodePromQLError
+	}
+	var resultTypeErr *prometheus.ResultTypeError
+	if errors.As(err, &resultTypeErr) {
+		return ErrCodeUnexpectedResultType
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if urlErr.Timeout() {
+			return ErrCodePrometheusTimeout
+		}
+		return ErrCodePrometheusUnreachable
+	}
+	return ErrCodePrometheusQueryFailed
+}
+
+// respondPrometheusError reports a failed Prometheus query. PromQL errors
+// are reported with Prometheus's message and error type rather than the
+// failover and retry context around them.
+func respondPrometheusError(c *gin.Context, err error) {
+	var queryErr *prometheus.QueryError
+	if errors.As(err, &queryErr) {
+		respondErrorDetails(c, http.StatusInternalServerError, ErrCodePromQLError, "PromQL error: "+queryErr.Message, gin.H{"error_type": queryErr.ErrorType})
+		return
+	}
+	var resultTypeErr *prometheus.ResultTypeError
+	if errors.As(err, &resultTypeErr) {
+		respondErrorDetails(c, http.StatusInternalServerError, ErrCodeUnexpectedResultType, resultTypeErr.Error(), gin.H{"expected": resultTypeErr.Expected, "got": resultTypeErr.Got})
+		return
+	}
+	respondError(c, http.StatusInternalServerError, prometheusErrorCode(err), fmt.Sprintf("Failed to query Prometheus: %v", err))
+}
+
+// respondConfigError reports an invalid OCS config, listing each validation problem in details
+func respondConfigError(c *gin.Context, err error) {
+	var validationErr *ConfigValidationError
+	if errors.As(err, &validationErr) {
+		respondErrorDetails(c, http.StatusBadRequest, ErrCodeInvalidConfig, "Invalid OCS config", validationErr.Problems)
+		return
+	}
+	respondError(c, http.StatusBadRequest, ErrCodeInvalidConfig, fmt.Sprintf("Invalid OCS config: %v", err))
+}
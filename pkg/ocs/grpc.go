@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/contexture/ocs/pkg/ocs/ocspb"
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+	"github.com/contexture/ocs/pkg/ocs/store"
+)
+
+// gRPC metadata keys mirroring the HTTP headers of the same purpose
+const (
+	grpcAPIKeyMetadata    = "x-api-key"
+	grpcTenantMetadata    = "x-tenant-id"
+	grpcRequestIDMetadata = "x-request-id"
+)
+
+// grpcContextKey keys the values grpcService.intercept stores in a request's context
+type grpcContextKey int
+
+const (
+	grpcTenantKey grpcContextKey = iota
+	grpcLoggerKey
+)
+
+// grpcAccess is the protection an RPC gets, matching the HTTP route it mirrors
+type grpcAccess int
+
+const (
+	grpcAccessOpen  grpcAccess = iota // Like /health: no key, no tenant
+	grpcAccessRead                    // Like the reads group: a key only when reads are protected
+	grpcAccessWrite                   // Like the writes group: always a key when keys are configured
+)
+
+// grpcMethodAccess maps each RPC to its protection; unlisted methods are rejected
+var grpcMethodAccess = map[string]grpcAccess{
+	ocspb.OCS_HealthCheck_FullMethodName:         grpcAccessOpen,
+	ocspb.OCS_GetOCSPrompt_FullMethodName:        grpcAccessRead,
+	ocspb.OCS_CollectIstioMetrics_FullMethodName: grpcAccessWrite,
+}
+
+// loadGRPCPort reads GRPC_PORT, the port the gRPC API listens on. It returns
+// an empty string when the gRPC API is disabled (unset).
+func loadGRPCPort() (string, error) {
+	value := os.Getenv("GRPC_PORT")
+	if value == "" {
+		return "", nil
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > math.MaxUint16 {
+		return "", fmt.Errorf("invalid GRPC_PORT %q: must be a port number", value)
+	}
+	return value, nil
+}
+
+// grpcService implements the OCS gRPC service on top of the same Server the
+// HTTP handlers use, so both APIs serve the same snapshots, config and tenants
+type grpcService struct {
+	ocspb.UnimplementedOCSServer
+	server  *Server
+	auth    *apiKeyAuth
+	collect *rateLimiter // Shared with POST /collect_istio_metrics; nil allows everything
+}
+
+// newGRPCServer creates the gRPC server for the OCS service, serving TLS
+// with tlsConfig when it is set
+func newGRPCServer(server *Server, auth *apiKeyAuth, limits endpointLimits, tlsConfig *tls.Config) *grpc.Server {
+	service := &grpcService{server: server, auth: auth, collect: limits.collect}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(service.intercept)}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(opts...)
+	ocspb.RegisterOCSServer(grpcServer, service)
+	return grpcServer
+}
+
+// intercept applies to every RPC what the Gin middleware applies to HTTP
+// requests: a request ID and logger, in-flight tracking, API key
+// authentication, tenant resolution and the collect rate limit
+func (g *grpcService) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	atomic.AddInt64(&g.server.inFlight, 1)
+	defer atomic.AddInt64(&g.server.inFlight, -1)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := firstMetadata(md, grpcRequestIDMetadata)
+	if id == "" || len(id) > maxRequestIDLength {
+		id = newRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(grpcRequestIDMetadata, id))
+	logger := slog.With("request_id", id, "rpc", info.FullMethod)
+
+	access, ok := grpcMethodAccess[info.FullMethod]
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "unknown method %s", info.FullMethod)
+	}
+	if access == grpcAccessOpen {
+		return handler(context.WithValue(ctx, grpcLoggerKey, logger), req)
+	}
+
+	apiKey := firstMetadata(md, grpcAPIKeyMetadata)
+	var keyTenant string
+	if g.auth.enabled() && (access == grpcAccessWrite || g.auth.protectReads) {
+		if apiKey == "" {
+			return nil, status.Errorf(codes.Unauthenticated, "missing %s metadata", grpcAPIKeyMetadata)
+		}
+		valid, tenantID := g.auth.valid(apiKey)
+		if !valid {
+			logger.Warn("Rejected request with invalid API key", "client_ip", grpcClientIP(ctx))
+			return nil, status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		keyTenant = tenantID
+	}
+
+	t := g.server.defaultTenant
+	if g.server.multiTenant() {
+		selected, httpStatus, err := g.server.selectTenant(firstMetadata(md, grpcTenantMetadata), keyTenant)
+		if err != nil {
+			code := codes.InvalidArgument
+			if httpStatus == http.StatusForbidden {
+				code = codes.PermissionDenied
+			}
+			return nil, status.Error(code, err.Error())
+		}
+		t = selected
+		logger = logger.With("tenant", t.id)
+	}
+
+	if info.FullMethod == ocspb.OCS_CollectIstioMetrics_FullMethodName && g.collect != nil {
+		allowed, _, wait := g.collect.take(g.collect.clientKeyFor(apiKey, grpcClientIP(ctx)), time.Now())
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			logger.Warn("Rate limit exceeded", "endpoint", g.collect.name, "client_ip", grpcClientIP(ctx), "retry_after_seconds", retryAfter)
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limit of %s exceeded, retry in %ds", g.collect.spec, retryAfter)
+		}
+	}
+
+	ctx = context.WithValue(ctx, grpcTenantKey, t)
+	ctx = context.WithValue(ctx, grpcLoggerKey, logger)
+	return handler(ctx, req)
+}
+
+// firstMetadata returns the first value of key in md, or empty when absent
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcClientIP returns the IP address of the RPC's caller
+func grpcClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// grpcTenantOf returns the tenant intercept selected for the RPC
+func grpcTenantOf(ctx context.Context) *tenant {
+	return ctx.Value(grpcTenantKey).(*tenant)
+}
+
+// grpcLogger returns the logger intercept tagged with the RPC's request ID
+func grpcLogger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(grpcLoggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// GetOCSPrompt builds the OCS prompt as GET /get_ocs_prompt does, without long-polling
+func (g *grpcService) GetOCSPrompt(ctx context.Context, req *ocspb.GetOCSPromptRequest) (*ocspb.GetOCSPromptResponse, error) {
+	depth := int(req.GetDepth())
+	if depth < 0 {
+		return nil, status.Error(codes.InvalidArgument, "depth must not be negative")
+	}
+	if depth == 0 {
+		depth = 1
+	}
+	limit := int(req.GetLimit())
+	if limit < 0 || limit > maxPromptLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxPromptLimit)
+	}
+
+	config := g.server.config()
+	t := grpcTenantOf(ctx)
+
+	var response *OCSPromptResponse
+	var hit bool
+	var err error
+	if req.GetNoCache() || config.promptCacheTTL() <= 0 || depth > 1 {
+		var snapshot *store.AdjacencyListDocument
+		if snapshot, err = t.repo.GetLatestSnapshot(); err == nil {
+			response = buildPrompt(config, snapshot, depth)
+		}
+	} else {
+		response, hit, err = cachedPrompt(t, config)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to retrieve topology from MongoDB: %v", err)
+	}
+	grpcLogger(ctx).Info("Served OCS prompt", "cache_hit", hit, "snapshot_id", response.SnapshotID)
+
+	response = filterPrompt(response, req.GetWorkloads(), req.GetDomain(), config)
+	if limit > 0 || req.GetCursor() != "" {
+		if limit == 0 {
+			limit = defaultPromptLimit
+		}
+		response = paginatePrompt(response, req.GetCursor(), limit)
+	}
+	return promptToProto(response), nil
+}
+
+// CollectIstioMetrics collects the topology as POST /collect_istio_metrics
+// does with the equivalent query parameters. Config overrides and debug
+// output are only available over HTTP.
+func (g *grpcService) CollectIstioMetrics(ctx context.Context, req *ocspb.CollectIstioMetricsRequest) (*ocspb.CollectIstioMetricsResponse, error) {
+	config := g.server.config()
+	if len(config.Workload) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "no source workloads configured in ocs_config.yaml")
+	}
+
+	mode := req.GetMode()
+	if mode == "" {
+		mode = config.CollectionMode
+	}
+	if !isValidCollectionMode(mode) {
+		return nil, status.Errorf(codes.InvalidArgument, "mode must be %q or %q", CollectionModeInstant, CollectionModeRange)
+	}
+	fromTimestamp, toTimestamp, err := parseCollectionRange(config, mode, req.GetFromTimestamp(), req.GetToTimestamp(), req.GetWindow())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	namespaces := config.Namespaces
+	if len(req.GetNamespaces()) > 0 {
+		namespaces = req.GetNamespaces()
+	}
+
+	logger := grpcLogger(ctx)
+	queryOpts := prometheus.QueryOptions{
+		Logger:        prometheus.NewQueryLogger(logger, config.QuietQueries),
+		DecayHalfLife: config.edgeDecayHalfLife(),
+		MetricName:    config.MetricName,
+		Namespaces:    namespaces,
+		ExtraFilters:  config.ExtraFilters,
+		Reporter:      config.Reporter,
+	}
+	run, err := g.server.runCollection(ctx, grpcTenantOf(ctx), config, fromTimestamp, toTimestamp, queryOpts, req.GetDryRun(), logger)
+	var collectErr *collectionError
+	if errors.As(err, &collectErr) {
+		switch {
+		case collectErr.saving && errors.Is(err, store.ErrDocumentTooLarge):
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case collectErr.saving:
+			return nil, status.Error(codes.Internal, err.Error())
+		case ctx.Err() != nil:
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case prometheusErrorCode(err) == ErrCodePromQLError:
+			var queryErr *prometheus.QueryError
+			errors.As(err, &queryErr)
+			return nil, status.Error(codes.Internal, "PromQL error: "+queryErr.Message)
+		case prometheusErrorCode(err) == ErrCodePrometheusQueryFailed, prometheusErrorCode(err) == ErrCodeUnexpectedResultType:
+			return nil, status.Error(codes.Internal, err.Error())
+		default:
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+	}
+
+	response := &ocspb.CollectIstioMetricsResponse{
+		Status:          "success",
+		Message:         "Metrics collected and saved to MongoDB",
+		AdjacencyList:   make(map[string]*ocspb.Workloads, len(run.graph.AdjacencyList)),
+		EdgeWeights:     make(map[string]*ocspb.EdgeWeights, len(run.graph.EdgeWeights)),
+		WorkloadMetrics: make(map[string]*ocspb.MetricValues, len(run.graph.WorkloadMetrics)),
+		Persisted:       !req.GetDryRun(),
+		Timestamp:       time.Now().Format(time.RFC3339),
+		Mode:            CollectionModeInstant,
+		Warnings:        run.result.Warnings,
+	}
+	for source, destinations := range run.graph.AdjacencyList {
+		response.AdjacencyList[source] = &ocspb.Workloads{Workloads: destinations}
+	}
+	for source, weights := range run.graph.EdgeWeights {
+		response.EdgeWeights[source] = &ocspb.EdgeWeights{Weights: weights}
+	}
+	for workload, values := range run.graph.WorkloadMetrics {
+		response.WorkloadMetrics[workload] = &ocspb.MetricValues{Values: values}
+	}
+	if req.GetDryRun() {
+		response.Message = "Metrics collected (dry run, not saved)"
+	} else {
+		response.DocumentId = run.docID.Hex()
+	}
+	if fromTimestamp != nil && toTimestamp != nil {
+		response.Mode = CollectionModeRange
+		response.FromTimestamp = fromTimestamp.Format(time.RFC3339)
+		response.ToTimestamp = toTimestamp.Format(time.RFC3339)
+	}
+	return response, nil
+}
+
+// HealthCheck reports liveness as GET /health does
+func (g *grpcService) HealthCheck(ctx context.Context, req *ocspb.HealthCheckRequest) (*ocspb.HealthCheckResponse, error) {
+	return &ocspb.HealthCheckResponse{
+		Status:     "healthy",
+		Prometheus: g.server.istioConnector.InstanceCount() > 0,
+		Mongodb:    g.server.storeBackend != nil,
+		Timestamp:  time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// promptToProto converts an OCS prompt to its gRPC message
+func promptToProto(response *OCSPromptResponse) *ocspb.GetOCSPromptResponse {
+	message := &ocspb.GetOCSPromptResponse{
+		SpecVersion:        response.SpecVersion,
+		SnapshotId:         response.SnapshotID,
+		NextCursor:         response.NextCursor,
+		ContextDefinitions: make([]*ocspb.ContextDefinition, 0, len(response.ContextDefinitions)),
+	}
+	for _, definition := range response.ContextDefinitions {
+		message.ContextDefinitions = append(message.ContextDefinitions, contextDefinitionToProto(definition))
+	}
+	return message
+}
+
+// contextDefinitionToProto converts a context definition to its gRPC message
+func contextDefinitionToProto(definition OCSContextDefinition) *ocspb.ContextDefinition {
+	message := &ocspb.ContextDefinition{
+		ResourceId: definition.ResourceID,
+		Domain:     definition.Domain,
+		Identity:   make(map[string]string, len(definition.Identity)),
+		Policy:     definition.Policy,
+		LastSeen:   definition.LastSeen,
+	}
+	for key, value := range definition.Identity {
+		message.Identity[key] = fmt.Sprint(value)
+	}
+	for _, metric := range definition.Metrics {
+		message.Metrics = append(message.Metrics, metricToProto(metric))
+	}
+	if definition.Topology != nil {
+		message.Topology = topologyToProto(definition.Topology)
+	}
+	if definition.Health != nil {
+		message.Health = &ocspb.WorkloadHealth{Status: definition.Health.Status}
+		for _, metric := range definition.Health.Metrics {
+			message.Health.Metrics = append(message.Health.Metrics, &ocspb.MetricHealth{Name: metric.Name, Value: metric.Value, Status: metric.Status})
+		}
+	}
+	return message
+}
+
+// metricToProto converts a configured metric to its gRPC message. A
+// health_config that can't be represented as a Struct is left out.
+func metricToProto(metric MetricConfig) *ocspb.Metric {
+	message := &ocspb.Metric{
+		Name:             metric.Name,
+		Type:             metric.Type,
+		Unit:             metric.Unit,
+		Description:      metric.Description,
+		AggregationLogic: metric.AggregationLogic,
+	}
+	if len(metric.HealthConfig) > 0 {
+		if healthConfig, err := structpb.NewStruct(metric.HealthConfig); err == nil {
+			message.HealthConfig = healthConfig
+		}
+	}
+	return message
+}
+
+// topologyToProto converts the topology topology.ForWorkload produces to its gRPC message
+func topologyToProto(fields map[string]interface{}) *ocspb.Topology {
+	message := &ocspb.Topology{}
+	if dependencies, ok := fields["dependencies"].([]string); ok {
+		message.Dependencies = dependencies
+	}
+	if weights, ok := fields["dependency_weights"].(map[string]float64); ok {
+		message.DependencyWeights = weights
+	}
+	if dependents, ok := fields["dependents"].([]string); ok {
+		message.Dependents = dependents
+	}
+	if weights, ok := fields["dependent_weights"].(map[string]float64); ok {
+		message.DependentWeights = weights
+	}
+	if hops, ok := fields["transitive_dependencies"].(map[string]int); ok {
+		message.TransitiveDependencies = make(map[string]int32, len(hops))
+		for workload, hop := range hops {
+			message.TransitiveDependencies[workload] = int32(hop)
+		}
+	}
+	return message
+}
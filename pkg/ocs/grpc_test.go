@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/contexture/ocs/pkg/ocs/ocspb"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+func TestGRPCIntercept(t *testing.T) {
+	t.Setenv("OCS_API_KEYS", "admin-key")
+	t.Setenv("OCS_TENANT_API_KEYS", "acme=acme-key,globex=globex-key")
+	auth, err := loadAPIKeyAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{tenants: map[string]*tenant{
+		"acme":   newTenant("acme", nil),
+		"globex": newTenant("globex", nil),
+	}}
+	service := &grpcService{server: s, auth: auth}
+
+	// The handler echoes the selected tenant's ID
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if ctx.Value(grpcTenantKey) == nil {
+			return "", nil
+		}
+		return grpcTenantOf(ctx).id, nil
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		key        string
+		tenant     string
+		wantCode   codes.Code
+		wantTenant string
+	}{
+		{"tenant key selects its tenant", ocspb.OCS_GetOCSPrompt_FullMethodName, "acme-key", "", codes.OK, "acme"},
+		{"admin key selects by metadata", ocspb.OCS_CollectIstioMetrics_FullMethodName, "admin-key", "globex", codes.OK, "globex"},
+		{"tenant key for another tenant", ocspb.OCS_GetOCSPrompt_FullMethodName, "acme-key", "globex", codes.PermissionDenied, ""},
+		{"admin key without tenant", ocspb.OCS_GetOCSPrompt_FullMethodName, "admin-key", "", codes.InvalidArgument, ""},
+		{"invalid key", ocspb.OCS_CollectIstioMetrics_FullMethodName, "wrong", "acme", codes.Unauthenticated, ""},
+		{"no key", ocspb.OCS_GetOCSPrompt_FullMethodName, "", "acme", codes.Unauthenticated, ""},
+		{"health check stays open", ocspb.OCS_HealthCheck_FullMethodName, "", "", codes.OK, ""},
+		{"unknown method", "/ocs.v1.OCS/Drop", "admin-key", "acme", codes.Unimplemented, ""},
+	}
+	for _, tt := range tests {
+		md := metadata.MD{}
+		if tt.key != "" {
+			md.Set(grpcAPIKeyMetadata, tt.key)
+		}
+		if tt.tenant != "" {
+			md.Set(grpcTenantMetadata, tt.tenant)
+		}
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+
+		got, err := service.intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+		if code := status.Code(err); code != tt.wantCode {
+			t.Errorf("%s: code = %v, want %v (%v)", tt.name, code, tt.wantCode, err)
+			continue
+		}
+		if tt.wantCode == codes.OK && got != tt.wantTenant {
+			t.Errorf("%s: tenant = %q, want %q", tt.name, got, tt.wantTenant)
+		}
+	}
+}
+
+func TestGRPCCollectRejectsInvalidRequests(t *testing.T) {
+	s := &Server{ocsConfig: &OCSConfig{Workload: []string{"app"}}, defaultTenant: newTenant("", nil)}
+	service := &grpcService{server: s}
+	ctx := context.WithValue(context.Background(), grpcTenantKey, s.defaultTenant)
+
+	requests := map[string]*ocspb.CollectIstioMetricsRequest{
+		"unknown mode":         {Mode: "sometimes"},
+		"window with instant":  {Mode: CollectionModeInstant, Window: "30m"},
+		"from without to":      {FromTimestamp: "2024-01-01T00:00:00Z"},
+		"unparseable from/to":  {FromTimestamp: "yesterday", ToTimestamp: "today"},
+		"window and timestamp": {Window: "1h", ToTimestamp: "2024-01-01T00:00:00Z"},
+	}
+	for name, req := range requests {
+		if _, err := service.CollectIstioMetrics(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: got %v, want InvalidArgument", name, err)
+		}
+	}
+}
+
+func TestTopologyToProto(t *testing.T) {
+	adjacency := map[string][]string{"app": {"db"}, "db": {"cache"}}
+	weights := topology.EdgeWeights{"app": {"db": 12}}
+	workloadTopology := topology.ForWorkload(adjacency, topology.Reverse(adjacency), weights, "app", 2)
+
+	message := topologyToProto(workloadTopology)
+	if len(message.Dependencies) != 1 || message.Dependencies[0] != "db" {
+		t.Errorf("dependencies = %v, want [db]", message.Dependencies)
+	}
+	if message.DependencyWeights["db"] != 12 {
+		t.Errorf("dependency_weights = %v, want db: 12", message.DependencyWeights)
+	}
+	if message.TransitiveDependencies["cache"] != 2 {
+		t.Errorf("transitive_dependencies = %v, want cache at 2 hops", message.TransitiveDependencies)
+	}
+}
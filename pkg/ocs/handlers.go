@@ -1,171 +1,1595 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v3"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+	"github.com/contexture/ocs/pkg/ocs/store"
+	"github.com/contexture/ocs/pkg/ocs/topology"
 )
 
-// Server holds the server state
-type Server struct {
-	ocsConfig      *OCSConfig
-	istioConnector *IstioConnector
-	mongoRepo      *MongoDBRepository
+// Server holds the server state
+type Server struct {
+	configMu       sync.RWMutex // Guards ocsConfig, which is swapped whole on reload
+	ocsConfig      *OCSConfig
+	ocsConfigPath  string // File ocsConfig is loaded and reloaded from
+	istioConnector MetricsQuerier
+	storeBackend   StoreBackend       // Snapshot storage shared by every tenant
+	defaultTenant  *tenant            // The only tenant when OCS_TENANTS is unset
+	tenants        map[string]*tenant // Configured tenants by ID; nil when single-tenant
+	notifier       *SnapshotNotifier
+	backfills      *backfillRegistry
+	autoCollector  *autoCollector // Nil unless scheduled collection is enabled
+	webhooks       *webhookSender // Nil unless WEBHOOK_URLS is set
+	startedAt      time.Time      // When the server was created, for uptime
+	inFlight       int64          // Number of requests currently being handled
+	shutdown       chan struct{}  // Closed when the server begins shutting down
+}
+
+const (
+	// maxPromptWait caps how long get_ocs_prompt may long-poll for a new snapshot
+	maxPromptWait = 5 * time.Minute
+
+	// defaultTopologiesLimit and maxTopologiesLimit bound the page size of the topologies listing
+	defaultTopologiesLimit = 20
+	maxTopologiesLimit     = 100
+	// defaultPromptLimit and maxPromptLimit bound the page size of a paginated OCS prompt
+	defaultPromptLimit = 100
+	maxPromptLimit     = 1000
+	// maxUnionSnapshots caps how many snapshots topology/union merges in one request
+	maxUnionSnapshots = 1000
+	// defaultPathMaxHops and maxPathMaxHops bound the length of paths topology/path enumerates
+	defaultPathMaxHops = 6
+	maxPathMaxHops     = 10
+	// defaultPathLimit and maxPathLimit bound how many paths topology/path returns
+	defaultPathLimit = 20
+	maxPathLimit     = 100
+	// pageRankDamping and pageRankIterations configure the PageRank scores of topology/centrality
+	pageRankDamping    = 0.85
+	pageRankIterations = 50
+)
+
+// NewServer creates a new server instance from the OCS and Prometheus config files
+func NewServer(ocsConfigPath, promConfigPath string) (*Server, error) {
+	// Load configurations
+	ocsConfig, err := loadOCSConfig(ocsConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCS config: %w", err)
+	}
+	if err := ocsConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid OCS config: %w", err)
+	}
+	setupLogging(ocsConfig.LogLevel, ocsConfig.LogFormat)
+	slog.Info("Loaded OCS config", "path", ocsConfigPath)
+
+	promConfig, err := prometheus.LoadConfig(promConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Prometheus config: %w", err)
+	}
+	promConfig.Version = version
+	slog.Info("Loaded Prometheus config", "path", promConfigPath, "instance_count", len(promConfig.PrometheusInstances), "mode", promConfig.Mode, "auth", promConfig.AuthMethod())
+
+	// Initialize Istio connector
+	istioConnector := prometheus.NewIstioConnector(promConfig)
+	istioConnector.ObserveQuery = observePrometheusQuery
+	istioConnector.ObserveCache = observeQueryCache
+
+	// Initialize snapshot storage
+	backend, err := newStoreBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	tenantIDs, err := loadTenantIDs()
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	webhooks, err := loadWebhookSender()
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	if webhooks != nil {
+		slog.Info("Sending topology change webhooks", "url_count", len(webhooks.urls))
+	}
+	defaultTenant, tenants, err := newTenants(backend, tenantIDs)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	if len(tenantIDs) > 0 {
+		slog.Info("Serving multiple tenants", "tenant_count", len(tenantIDs))
+	}
+
+	s := &Server{
+		ocsConfig:      ocsConfig,
+		ocsConfigPath:  ocsConfigPath,
+		istioConnector: istioConnector,
+		storeBackend:   backend,
+		defaultTenant:  defaultTenant,
+		tenants:        tenants,
+		notifier:       NewSnapshotNotifier(),
+		backfills:      newBackfillRegistry(),
+		webhooks:       webhooks,
+		shutdown:       make(chan struct{}),
+		startedAt:      time.Now(),
+	}
+
+	if retention := ocsConfig.retention(); retention > 0 {
+		for _, t := range s.allTenants() {
+			if err := t.repo.EnsureRetentionIndex(retention); err != nil {
+				backend.Close()
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// Close closes all connections and abandons pending webhook deliveries
+func (s *Server) Close() error {
+	if s.webhooks != nil {
+		s.webhooks.stop()
+	}
+	return s.storeBackend.Close()
+}
+
+// WaitWebhooks waits for in-flight webhook deliveries to finish after
+// shutdown has begun, or for ctx to expire
+func (s *Server) WaitWebhooks(ctx context.Context) {
+	if s.webhooks != nil {
+		s.webhooks.wait(ctx)
+	}
+}
+
+// trackInFlight is middleware counting the requests currently being handled
+func (s *Server) trackInFlight(c *gin.Context) {
+	atomic.AddInt64(&s.inFlight, 1)
+	defer atomic.AddInt64(&s.inFlight, -1)
+	c.Next()
+}
+
+// InFlight returns the number of requests currently being handled
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+// BeginShutdown releases long-polling and streaming clients so they don't hold up draining,
+// cancels a running backfill, and returns the number of requests in flight
+func (s *Server) BeginShutdown() int64 {
+	close(s.shutdown)
+	s.backfills.cancelRunning()
+	return s.InFlight()
+}
+
+// getOCSPromptHandler handles the get_ocs_prompt endpoint.
+// With wait and since query parameters it long-polls until a snapshot newer
+// than since exists, returning 304 if none arrives before the timeout.
+func (s *Server) getOCSPromptHandler(c *gin.Context) {
+	depth, err := parseDepthParam(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "depth must be a positive integer")
+		return
+	}
+
+	waitStr := c.Query("wait")
+	sinceStr := c.Query("since")
+	if waitStr == "" || sinceStr == "" {
+		s.respondWithCachedPrompt(c, depth)
+		return
+	}
+
+	wait, err := time.ParseDuration(waitStr)
+	if err != nil || wait <= 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid wait duration. Use a positive Go duration (e.g., 30s)")
+		return
+	}
+	if wait > maxPromptWait {
+		wait = maxPromptWait
+	}
+
+	since, err := primitive.ObjectIDFromHex(sinceStr)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("invalid since snapshot ID: %v", err))
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		// Subscribe before checking so a collection finishing in between still wakes us
+		updated := s.notifier.Subscribe()
+
+		snapshot, err := tenantOf(c).repo.GetLatestSnapshot()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+			return
+		}
+		if snapshot != nil && snapshot.ID != since {
+			s.writePrompt(c, s.config(), snapshot, depth)
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			c.Status(http.StatusNotModified)
+			return
+		case <-s.shutdown:
+			c.Status(http.StatusNotModified)
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// bulkPromptHandler handles POST get_ocs_prompt, returning the context
+// definitions of exactly the resources in the request body, in the requested
+// order. Resources the topology doesn't know are included with an empty
+// topology so callers can tell "no dependencies" from a missing entry.
+func (s *Server) bulkPromptHandler(c *gin.Context) {
+	depth, err := parseDepthParam(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "depth must be a positive integer")
+		return
+	}
+
+	var request PromptRequest
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Failed to parse request body: %v", err))
+		return
+	}
+	keys, err := request.workloadKeys()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	config := s.config()
+	t := tenantOf(c)
+	var response *OCSPromptResponse
+	if depth == 1 && c.Query("stale_after") == "" && config.promptCacheTTL() > 0 {
+		response, _, err = cachedPrompt(t, config)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+			return
+		}
+	} else {
+		staleAfter, err := parseStaleAfterParam(c)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		snapshot, err := t.repo.GetLatestSnapshot()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+			return
+		}
+		if snapshot != nil && staleAfter > 0 {
+			snapshot = dropStaleEdges(snapshot, time.Now().Add(-staleAfter))
+		}
+		response = buildPrompt(config, snapshot, depth)
+	}
+
+	renderPrompt(c, selectContextDefinitions(response, keys, config))
+}
+
+// workloadKeys returns the workload keys of the requested resources in
+// request order, without duplicates. Resource IDs are workload- followed by
+// the workload key.
+func (r PromptRequest) workloadKeys() ([]string, error) {
+	if len(r.ResourceIDs)+len(r.Workloads) == 0 {
+		return nil, fmt.Errorf("resource_ids or workloads must list at least one resource")
+	}
+	if len(r.ResourceIDs)+len(r.Workloads) > maxPromptLimit {
+		return nil, fmt.Errorf("at most %d resources can be requested at once", maxPromptLimit)
+	}
+
+	keys := make([]string, 0, len(r.ResourceIDs)+len(r.Workloads))
+	seen := make(map[string]bool, cap(keys))
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, id := range r.ResourceIDs {
+		key, ok := strings.CutPrefix(id, "workload-")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("resource_id %q is not a workload resource ID such as workload-frontend", id)
+		}
+		add(key)
+	}
+	for _, workload := range r.Workloads {
+		if workload == "" {
+			return nil, fmt.Errorf("workloads must not contain empty names")
+		}
+		add(workload)
+	}
+	return keys, nil
+}
+
+// selectContextDefinitions returns a copy of response holding the context
+// definitions of the given workload keys in that order. A key matches a
+// definition by its workload key, or by bare name for namespace-qualified
+// definitions as filterPrompt does. Keys matching nothing get a definition
+// with an empty topology.
+func selectContextDefinitions(response *OCSPromptResponse, keys []string, config *OCSConfig) *OCSPromptResponse {
+	byKey := make(map[string][]OCSContextDefinition, len(response.ContextDefinitions))
+	for _, def := range response.ContextDefinitions {
+		name, _ := def.Identity["workload"].(string)
+		namespace, _ := def.Identity["namespace"].(string)
+		key := topology.QualifyWorkload(namespace, name)
+		byKey[key] = append(byKey[key], def)
+		if namespace != "" {
+			byKey[name] = append(byKey[name], def)
+		}
+	}
+
+	selected := *response
+	selected.ContextDefinitions = make([]OCSContextDefinition, 0, len(keys))
+	included := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		matches, ok := byKey[key]
+		if !ok {
+			def := newContextDefinition(key, config)
+			def.Topology = map[string]interface{}{
+				"dependencies": []string{},
+				"dependents":   []string{},
+			}
+			matches = []OCSContextDefinition{def}
+		}
+		for _, def := range matches {
+			// A bare name and its qualified key may both be requested
+			if !included[def.ResourceID] {
+				included[def.ResourceID] = true
+				selected.ContextDefinitions = append(selected.ContextDefinitions, def)
+			}
+		}
+	}
+	selected.NextCursor = ""
+	return &selected
+}
+
+// previewPromptHandler handles the preview_prompt endpoint.
+// It builds the prompt from the latest topology using the OCS config in the
+// request body, without persisting it or replacing the running config.
+func (s *Server) previewPromptHandler(c *gin.Context) {
+	depth, err := parseDepthParam(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "depth must be a positive integer")
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
+
+	// YAML is a superset of JSON, so this accepts either format with the config file's field names
+	var config OCSConfig
+	if err := yaml.Unmarshal(body, &config); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidConfig, fmt.Sprintf("Failed to parse OCS config: %v", err))
+		return
+	}
+
+	if err := config.Validate(); err != nil {
+		respondConfigError(c, err)
+		return
+	}
+
+	s.respondWithPrompt(c, &config, depth)
+}
+
+// respondWithCachedPrompt serves the OCS prompt for the running config from
+// the prompt cache, rebuilding and caching it on a miss. no_cache=true bypasses
+// the cache, as does a depth beyond immediate dependencies or stale_after.
+func (s *Server) respondWithCachedPrompt(c *gin.Context, depth int) {
+	config := s.config()
+	ttl := config.promptCacheTTL()
+
+	noCache, err := parseBoolParam(c, "no_cache")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "no_cache must be true or false")
+		return
+	}
+
+	// Prompts without stale edges depend on the request time, so they aren't cached
+	if noCache || ttl <= 0 || depth > 1 || c.Query("stale_after") != "" {
+		s.respondWithPrompt(c, config, depth)
+		return
+	}
+
+	response, hit, err := cachedPrompt(tenantOf(c), config)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+		return
+	}
+
+	requestLogger(c).Info("Served OCS prompt", "cache_hit", hit, "snapshot_id", response.SnapshotID)
+	writePromptResponse(c, config, response)
+}
+
+// cachedPrompt returns the tenant's OCS prompt for config from its prompt
+// cache, building and caching it from the latest snapshot on a miss
+func cachedPrompt(t *tenant, config *OCSConfig) (*OCSPromptResponse, bool, error) {
+	response, generation, hit := t.promptCache.Get(config)
+	if hit {
+		return response, true, nil
+	}
+	snapshot, err := t.repo.GetLatestSnapshot()
+	if err != nil {
+		return nil, false, err
+	}
+	response = buildPrompt(config, snapshot, 1)
+	t.promptCache.Set(generation, config, response, config.promptCacheTTL())
+	return response, false, nil
+}
+
+// respondWithPrompt builds the OCS prompt from the latest topology and the given config
+func (s *Server) respondWithPrompt(c *gin.Context, config *OCSConfig, depth int) {
+	// Get latest topology from MongoDB
+	snapshot, err := tenantOf(c).repo.GetLatestSnapshot()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+		return
+	}
+
+	s.writePrompt(c, config, snapshot, depth)
+}
+
+// writePrompt writes the OCS prompt built from a topology snapshot, which may be nil, and the given config.
+// With stale_after, edges not observed within that window are left out.
+func (s *Server) writePrompt(c *gin.Context, config *OCSConfig, snapshot *store.AdjacencyListDocument, depth int) {
+	staleAfter, err := parseStaleAfterParam(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if snapshot != nil && staleAfter > 0 {
+		snapshot = dropStaleEdges(snapshot, time.Now().Add(-staleAfter))
+	}
+	writePromptResponse(c, config, buildPrompt(config, snapshot, depth))
+}
+
+// writePromptResponse writes an OCS prompt, narrowed to the workload and
+// domain query parameters when given, capped at max_context_definitions or
+// the max_definitions query parameter, and paginated when cursor or limit is
+func writePromptResponse(c *gin.Context, config *OCSConfig, response *OCSPromptResponse) {
+	var workloads []string
+	for _, value := range c.QueryArray("workload") {
+		workloads = append(workloads, splitList(value)...)
+	}
+	response = filterPrompt(response, workloads, c.Query("domain"), config)
+
+	maxDefinitions, err := parseIntParam(c, "max_definitions", config.maxContextDefinitions())
+	if err != nil || maxDefinitions < 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "max_definitions must be a non-negative integer, 0 for no cap")
+		return
+	}
+	if maxDefinitions > 0 {
+		response = capPrompt(response, maxDefinitions)
+	}
+
+	if _, paginated := c.GetQuery("limit"); paginated || c.Query("cursor") != "" {
+		limit, err := parseIntParam(c, "limit", defaultPromptLimit)
+		if err != nil || limit <= 0 || limit > maxPromptLimit {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxPromptLimit))
+			return
+		}
+		response = paginatePrompt(response, c.Query("cursor"), limit)
+	}
+	renderPrompt(c, response)
+}
+
+// renderPrompt writes an OCS prompt as JSON, or as MessagePack when the client prefers it
+func renderPrompt(c *gin.Context, response *OCSPromptResponse) {
+	// JSON unless the client prefers MessagePack, which is smaller and faster
+	// to encode; the same struct tags name the fields in both
+	switch c.NegotiateFormat(binding.MIMEJSON, binding.MIMEMSGPACK2, binding.MIMEMSGPACK) {
+	case binding.MIMEMSGPACK2, binding.MIMEMSGPACK:
+		c.Render(http.StatusOK, render.MsgPack{Data: response})
+	default:
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// paginatePrompt returns a copy of response holding at most limit context
+// definitions, starting after the one whose resource_id is cursor. Context
+// definitions are sorted by resource_id, so the cursor stays valid across
+// snapshots and pages neither overlap nor skip definitions that still exist.
+// NextCursor is set when more definitions remain.
+func paginatePrompt(response *OCSPromptResponse, cursor string, limit int) *OCSPromptResponse {
+	definitions := response.ContextDefinitions
+	start := sort.Search(len(definitions), func(i int) bool {
+		return definitions[i].ResourceID > cursor
+	})
+	end := min(start+limit, len(definitions))
+
+	page := *response
+	page.ContextDefinitions = definitions[start:end]
+	page.NextCursor = ""
+	if end < len(definitions) {
+		page.NextCursor = definitions[end-1].ResourceID
+	}
+	return &page
+}
+
+// capPrompt returns a copy of response holding the context definitions of
+// the maxDefinitions most-connected workloads, by dependencies plus
+// dependents, with ties broken by resource_id. The kept definitions stay
+// sorted by resource_id so pagination still works. Truncated and
+// TotalDefinitions are set when definitions were dropped.
+func capPrompt(response *OCSPromptResponse, maxDefinitions int) *OCSPromptResponse {
+	if len(response.ContextDefinitions) <= maxDefinitions {
+		return response
+	}
+
+	kept := slices.Clone(response.ContextDefinitions)
+	sort.SliceStable(kept, func(i, j int) bool {
+		di, dj := definitionDegree(kept[i]), definitionDegree(kept[j])
+		if di != dj {
+			return di > dj
+		}
+		return kept[i].ResourceID < kept[j].ResourceID
+	})
+	kept = kept[:maxDefinitions]
+	sortContextDefinitions(kept)
+
+	capped := *response
+	capped.ContextDefinitions = kept
+	capped.Truncated = true
+	capped.TotalDefinitions = len(response.ContextDefinitions)
+	return &capped
+}
+
+// definitionDegree returns how many direct dependencies and dependents a context definition lists
+func definitionDegree(definition OCSContextDefinition) int {
+	dependencies, _ := definition.Topology["dependencies"].([]string)
+	dependents, _ := definition.Topology["dependents"].([]string)
+	return len(dependencies) + len(dependents)
+}
+
+// filterPrompt returns a copy of response keeping only context definitions
+// in domain for the requested workloads. A workload matches by its bare name
+// or its namespace/workload key. Requested workloads absent from the prompt
+// are included with an empty topology so callers can tell "no dependencies"
+// from "unknown". Empty workloads and domain keep everything.
+func filterPrompt(response *OCSPromptResponse, workloads []string, domain string, config *OCSConfig) *OCSPromptResponse {
+	if len(workloads) == 0 && domain == "" {
+		return response
+	}
+
+	requested := make(map[string]bool, len(workloads))
+	for _, workload := range workloads {
+		requested[workload] = true
+	}
+	found := make(map[string]bool, len(workloads))
+
+	filtered := *response
+	filtered.ContextDefinitions = make([]OCSContextDefinition, 0)
+	for _, def := range response.ContextDefinitions {
+		if domain != "" && def.Domain != domain {
+			continue
+		}
+		if len(requested) > 0 {
+			name, _ := def.Identity["workload"].(string)
+			namespace, _ := def.Identity["namespace"].(string)
+			key := topology.QualifyWorkload(namespace, name)
+			if !requested[key] && !requested[name] {
+				continue
+			}
+			found[key] = true
+			found[name] = true
+		}
+		filtered.ContextDefinitions = append(filtered.ContextDefinitions, def)
+	}
+
+	for _, workload := range workloads {
+		if found[workload] {
+			continue
+		}
+		found[workload] = true
+
+		def := newContextDefinition(workload, config)
+		if domain != "" && def.Domain != domain {
+			continue
+		}
+		def.Topology = map[string]interface{}{
+			"dependencies": []string{},
+			"dependents":   []string{},
+		}
+		filtered.ContextDefinitions = append(filtered.ContextDefinitions, def)
+	}
+	sortContextDefinitions(filtered.ContextDefinitions)
+
+	return &filtered
+}
+
+// buildPrompt builds the OCS prompt from a topology snapshot, which may be nil, and the given config.
+// depth is passed through to buildContextDefinitions.
+func buildPrompt(config *OCSConfig, snapshot *store.AdjacencyListDocument, depth int) *OCSPromptResponse {
+	// Initialize empty map if nil
+	adjacencyList := make(map[string][]string)
+	var edgeWeights topology.EdgeWeights
+	var lastSeen map[string]time.Time
+	var workloadLabels topology.WorkloadLabels
+	var workloadMetrics topology.WorkloadMetrics
+	if snapshot != nil {
+		if snapshotAdjacency := adjacencyListOf(snapshot); snapshotAdjacency != nil {
+			adjacencyList = snapshotAdjacency
+		}
+		edgeWeights = snapshot.EdgeWeights
+		lastSeen = workloadLastSeen(snapshot)
+		workloadLabels = snapshot.WorkloadLabels
+		workloadMetrics = snapshot.WorkloadMetrics
+	}
+
+	// Build context definitions
+	contextDefinitions := buildContextDefinitions(adjacencyList, edgeWeights, lastSeen, workloadLabels, workloadMetrics, config, depth)
+
+	// Build response
+	response := &OCSPromptResponse{
+		SpecVersion:        config.specVersion(),
+		ContextDefinitions: contextDefinitions,
+	}
+	if snapshot != nil {
+		response.SnapshotID = snapshot.ID.Hex()
+	}
+
+	return response
+}
+
+// collectIstioMetricsHandler handles the collect_istio_metrics endpoint
+func (s *Server) collectIstioMetricsHandler(c *gin.Context) {
+	defer countCollectRequest(c)
+
+	config := s.config()
+
+	// Overrides in the body replace config fields for this collection only
+	body, err := c.GetRawData()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
+	overrides, err := parseCollectOverrides(body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidConfig, fmt.Sprintf("Failed to parse config overrides: %v", err))
+		return
+	}
+	if overrides != nil {
+		if config, err = overrides.apply(config); err != nil {
+			respondConfigError(c, err)
+			return
+		}
+	}
+
+	if len(config.Workload) == 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeNoWorkloads, "No source workloads configured in ocs_config.yaml")
+		return
+	}
+
+	// The mode query parameter overrides the configured collection mode
+	mode := c.DefaultQuery("mode", config.CollectionMode)
+	if !isValidCollectionMode(mode) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("mode must be %q or %q", CollectionModeInstant, CollectionModeRange))
+		return
+	}
+
+	// Parse and validate timestamps
+	fromTimestamp, toTimestamp, err := parseTimestampParams(c, config, mode)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidTimestamp, err.Error())
+		return
+	}
+
+	dryRun, err := parseBoolParam(c, "dry_run")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "dry_run must be true or false")
+		return
+	}
+
+	debug, err := parseBoolParam(c, "debug")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "debug must be true or false")
+		return
+	}
+
+	// Namespaces from the query parameter override the config
+	namespaces := config.Namespaces
+	if namespacesStr := c.Query("namespaces"); namespacesStr != "" {
+		namespaces = splitList(namespacesStr)
+		if err := prometheus.ValidateNames("namespace", namespaces); err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	// Filters from the query parameter are added to the configured ones, replacing those on the same label
+	extraFilters, err := parseFilterParams(c, config.ExtraFilters)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	logger := requestLogger(c)
+
+	// Query Prometheus via Istio connector
+	queryOpts := prometheus.QueryOptions{
+		Logger:        prometheus.NewQueryLogger(logger, config.QuietQueries),
+		DecayHalfLife: config.edgeDecayHalfLife(),
+		MetricName:    config.MetricName,
+		Namespaces:    namespaces,
+		ExtraFilters:  extraFilters,
+		Reporter:      config.Reporter,
+	}
+	run, err := s.runCollection(c.Request.Context(), tenantOf(c), config, fromTimestamp, toTimestamp, queryOpts, dryRun, logger)
+	var collectErr *collectionError
+	if errors.As(err, &collectErr) {
+		switch {
+		case collectErr.saving && errors.Is(collectErr.err, store.ErrDocumentTooLarge):
+			respondError(c, http.StatusInternalServerError, ErrCodeSnapshotTooLarge, collectErr.err.Error())
+		case collectErr.saving:
+			respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to save to MongoDB: %v", collectErr.err))
+		case c.Request.Context().Err() != nil:
+			logger.Info("Client disconnected, abandoned Prometheus query", "error", collectErr.err)
+			c.AbortWithStatus(statusClientClosedRequest)
+		default:
+			respondPrometheusError(c, collectErr.err)
+		}
+		return
+	}
+	graph := run.graph
+
+	response := gin.H{
+		"status":         "success",
+		"message":        "Metrics collected and saved to MongoDB",
+		"adjacency_list": graph.AdjacencyList,
+		"edge_weights":   graph.EdgeWeights,
+		"edge_instances": graph.EdgeInstances,
+		"persisted":      !dryRun,
+		"timestamp":      time.Now().Format(time.RFC3339),
+	}
+
+	if graph.EdgeErrorRates != nil {
+		response["edge_error_rates"] = graph.EdgeErrorRates
+	}
+	if len(run.result.Warnings) > 0 {
+		response["warnings"] = run.result.Warnings
+	}
+	if graph.WorkloadLabels != nil {
+		response["workload_labels"] = graph.WorkloadLabels
+	}
+	if graph.WorkloadMetrics != nil {
+		response["workload_metrics"] = graph.WorkloadMetrics
+	}
+
+	if dryRun {
+		response["message"] = "Metrics collected (dry run, not saved)"
+		response["dry_run"] = true
+	} else {
+		response["document_id"] = run.docID.Hex()
+	}
+
+	if len(namespaces) > 0 {
+		response["namespaces"] = namespaces
+	}
+	if overrides != nil {
+		response["overrides"] = overrides
+	}
+
+	if debug {
+		// The queries can't fail to build here, QueryMetrics already built them
+		queries, _ := s.istioConnector.Queries(config.Workload, queryOpts)
+		response["debug"] = gin.H{
+			"queries":      queries,
+			"result_count": len(run.result.Data.Result),
+		}
+	}
+
+	if fromTimestamp != nil && toTimestamp != nil {
+		response["mode"] = CollectionModeRange
+		response["from_timestamp"] = fromTimestamp.Format(time.RFC3339)
+		response["to_timestamp"] = toTimestamp.Format(time.RFC3339)
+
+		// If the range came from a window rather than timestamps, include that info
+		fromStr := c.Query("from_timestamp")
+		toStr := c.Query("to_timestamp")
+		if window := c.Query("window"); window != "" {
+			response["window"] = window
+		} else if fromStr == "" && toStr == "" {
+			response["time_window_minutes"] = int(config.collectionWindow() / time.Minute)
+		}
+	} else {
+		response["mode"] = CollectionModeInstant
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// collectionRun is the outcome of one collection run through runCollection
+type collectionRun struct {
+	result *prometheus.QueryResult
+	graph  topology.Graph
+	docID  primitive.ObjectID // Nil for dry runs
+}
+
+// collectionError reports which step of a collection run failed
+type collectionError struct {
+	saving bool // The topology was collected but could not be saved
+	err    error
+}
+
+func (e *collectionError) Error() string {
+	if e.saving {
+		return fmt.Sprintf("failed to save to MongoDB: %v", e.err)
+	}
+	return fmt.Sprintf("failed to query Prometheus: %v", e.err)
+}
+
+func (e *collectionError) Unwrap() error {
+	return e.err
+}
+
+// runCollection queries Prometheus with config and saves the extracted
+// topology as the tenant's latest snapshot unless dryRun is set; a dry run
+// skips the health metric queries too. Errors are *collectionError. The
+// outcome is recorded for /status, except for dry runs and queries abandoned
+// because ctx was cancelled.
+func (s *Server) runCollection(ctx context.Context, t *tenant, config *OCSConfig, fromTimestamp, toTimestamp *time.Time, opts prometheus.QueryOptions, dryRun bool, logger *slog.Logger) (*collectionRun, error) {
+	result, err := s.istioConnector.QueryMetrics(ctx, config.Workload, fromTimestamp, toTimestamp, opts)
+	if err != nil {
+		err := &collectionError{err: err}
+		if !dryRun && ctx.Err() == nil {
+			t.lastCollection.record(err)
+		}
+		return nil, err
+	}
+
+	run := &collectionRun{result: result, graph: extractTopology(result, config, logger)}
+	logger.Info("Extracted adjacency list", "source_count", len(run.graph.AdjacencyList), "dry_run", dryRun)
+	// A dry run checks the topology query only, so health metrics aren't queried
+	if dryRun {
+		return run, nil
+	}
+	s.collectHealthMetrics(ctx, config, &run.graph, opts, logger)
+
+	// The snapshot being replaced is what webhook receivers are told the topology changed from
+	var previous *store.AdjacencyListDocument
+	if s.webhooks != nil {
+		if previous, err = t.repo.GetLatestSnapshot(); err != nil {
+			logger.Warn("Failed to load the previous snapshot; skipping topology change webhooks", "error", err)
+		}
+	}
+
+	run.docID, err = s.saveTopology(t, config, run.graph)
+	if err != nil {
+		err := &collectionError{saving: true, err: err}
+		t.lastCollection.record(err)
+		return nil, err
+	}
+	t.lastCollection.record(nil)
+	logger.Info("Saved adjacency list to MongoDB", "document_id", run.docID.Hex())
+
+	if s.webhooks != nil {
+		adjacencyList, err := storedAdjacency(t, config, run.docID, run.graph.AdjacencyList)
+		if err != nil {
+			logger.Warn("Failed to load the saved snapshot; skipping topology change webhooks", "error", err)
+		} else if event := newTopologyChangeEvent(t.id, previous, run.docID, time.Now(), adjacencyList); event != nil {
+			s.webhooks.send(event, logger)
+		}
+	}
+	return run, nil
+}
+
+// storedAdjacency returns the edges saved as docID. That is the collected
+// adjacency list unless snapshot_bucket_seconds merged it into the bucket's
+// earlier collections, in which case the merged snapshot is loaded.
+func storedAdjacency(t *tenant, config *OCSConfig, docID primitive.ObjectID, collected map[string][]string) (map[string][]string, error) {
+	if config.snapshotBucket() <= 0 {
+		return collected, nil
+	}
+	stored, err := t.repo.GetAdjacencyListByID(docID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return adjacencyListOf(stored), nil
+}
+
+// extractTopology extracts the edges and labels config asks for from a Prometheus result.
+// With exclude_unknown, series to or from unattributed workloads are first
+// dropped, then workload names in result are rewritten to their canonical names.
+// With drop_self_edges, series from a workload to itself are dropped last, so
+// workloads normalized onto the same name don't leave a self-loop behind.
+func extractTopology(result *prometheus.QueryResult, config *OCSConfig, logger *slog.Logger) topology.Graph {
+	qualify := config.QualifyNamespaces
+	if config.ExcludeUnknown {
+		dropped := prometheus.DropWorkloads(result, config.unknownWorkloads(), qualify)
+		logger.Debug("Dropped edges with unknown workloads", "dropped_edges", dropped, "unknown_workloads", config.unknownWorkloads())
+	}
+	identityLabels := config.IdentityLabels
+	if normalizer := config.workloadNormalizer(); normalizer != nil {
+		normalizer.Apply(result)
+		identityLabels = append(slices.Clip(identityLabels), prometheus.RawWorkloadLabel)
+	}
+	if config.DropSelfEdges {
+		dropped := prometheus.DropSelfEdges(result, qualify)
+		logger.Debug("Dropped self-referential edges", "dropped_edges", dropped)
+	}
+	graph := topology.Graph{
+		AdjacencyList:  prometheus.ExtractAdjacencyList(result, qualify),
+		EdgeWeights:    prometheus.ExtractEdgeWeights(result, qualify),
+		EdgeInstances:  prometheus.ExtractEdgeInstances(result, qualify),
+		EdgeLastSeen:   prometheus.ExtractEdgeLastSeen(result, qualify),
+		WorkloadLabels: prometheus.ExtractWorkloadLabels(result, qualify, identityLabels),
+	}
+	if config.ErrorRates {
+		graph.EdgeErrorRates = prometheus.ExtractEdgeErrorRates(result, qualify, config.errorClassification())
+	}
+	return graph
+}
+
+// collectHealthMetrics adds the current values of metrics with health
+// thresholds to topology. Failed metric queries are logged rather than
+// failing the collection, leaving those metrics unscored.
+func (s *Server) collectHealthMetrics(ctx context.Context, config *OCSConfig, graph *topology.Graph, opts prometheus.QueryOptions, logger *slog.Logger) {
+	values, errs := s.istioConnector.CollectWorkloadMetrics(ctx, workloadMetricQueries(config.Metrics, config.QualifyNamespaces), config.QualifyNamespaces, opts)
+	for _, err := range errs {
+		logger.Warn("Failed to collect health metric", "error", err)
+	}
+	graph.WorkloadMetrics = values
+}
+
+// saveTopology saves a freshly collected topology as the tenant's latest
+// snapshot, invalidating its cached prompt and waking long-polling clients.
+// With snapshot_bucket_seconds it is merged into the current bucket's snapshot.
+func (s *Server) saveTopology(t *tenant, config *OCSConfig, graph topology.Graph) (primitive.ObjectID, error) {
+	var docID primitive.ObjectID
+	var err error
+	if bucket := config.snapshotBucket(); bucket > 0 {
+		docID, err = t.repo.SaveAdjacencyListInBucket(bucket, graph)
+	} else {
+		docID, err = t.repo.SaveAdjacencyList(graph)
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	topologyEdges.Set(float64(topology.CountEdges(graph.AdjacencyList)))
+	t.promptCache.Invalidate()
+	s.notifier.Publish()
+	return docID, nil
 }
 
-// NewServer creates a new server instance
-func NewServer() (*Server, error) {
-	// Load configurations
-	ocsConfig, err := loadOCSConfig()
+// listTopologiesHandler handles the topologies endpoint, listing stored snapshots newest-first
+func (s *Server) listTopologiesHandler(c *gin.Context) {
+	limit, err := parseIntParam(c, "limit", defaultTopologiesLimit)
+	if err != nil || limit <= 0 || limit > maxTopologiesLimit {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxTopologiesLimit))
+		return
+	}
+
+	offset, err := parseIntParam(c, "offset", 0)
+	if err != nil || offset < 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "offset must be a non-negative integer")
+		return
+	}
+
+	docs, total, err := tenantOf(c).repo.ListAdjacencyLists(limit, offset)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load OCS config: %w", err)
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to list topologies from MongoDB: %v", err))
+		return
 	}
-	log.Printf("Loaded OCS config")
 
-	promConfig, err := loadPrometheusConfig()
+	topologies := make([]TopologySummary, 0, len(docs))
+	for _, doc := range docs {
+		topologies = append(topologies, TopologySummary{
+			DocumentID:       doc.ID.Hex(),
+			SchemaVersion:    doc.SchemaVersion,
+			Timestamp:        doc.Timestamp.Format(time.RFC3339),
+			SourceCount:      doc.SourceCount,
+			TotalConnections: doc.TotalConnections,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"topologies": topologies,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// pruneTopologiesHandler handles DELETE on the topologies endpoint, deleting snapshots older than before
+func (s *Server) pruneTopologiesHandler(c *gin.Context) {
+	beforeStr := c.Query("before")
+	if beforeStr == "" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "before timestamp must be provided")
+		return
+	}
+
+	before, err := parseTimestamp(beforeStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load Prometheus config: %w", err)
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidTimestamp, "invalid before format. Use RFC3339 (e.g., 2024-01-01T00:00:00Z) or Unix timestamp")
+		return
 	}
-	log.Printf("Loaded Prometheus config, using URL: %s", promConfig.PrometheusInstances[0].BaseURL)
 
-	// Initialize Istio connector
-	istioConnector := NewIstioConnector(promConfig.PrometheusInstances[0].BaseURL)
+	t := tenantOf(c)
+	deleted, err := t.repo.PruneOlderThan(*before)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to prune topologies from MongoDB: %v", err))
+		return
+	}
+	if deleted > 0 {
+		// The cached prompt may have been built from a deleted snapshot
+		t.promptCache.Invalidate()
+	}
+	requestLogger(c).Info("Pruned adjacency lists", "deleted_count", deleted, "before", before.Format(time.RFC3339))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"deleted": deleted,
+		"before":  before.Format(time.RFC3339),
+	})
+}
 
-	// Initialize MongoDB repository
-	mongoRepo, err := NewMongoDBRepository()
+// getTopologyHandler handles the topologies/:id endpoint, returning a single stored snapshot
+func (s *Server) getTopologyHandler(c *gin.Context) {
+	doc, err := tenantOf(c).repo.GetAdjacencyListByID(c.Param("id"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize MongoDB: %w", err)
+		respondSnapshotError(c, err)
+		return
 	}
 
-	return &Server{
-		ocsConfig:      ocsConfig,
-		istioConnector: istioConnector,
-		mongoRepo:      mongoRepo,
-	}, nil
+	c.JSON(http.StatusOK, doc)
 }
 
-// Close closes all connections
-func (s *Server) Close() error {
-	return s.mongoRepo.Close()
+// diffTopologiesHandler handles the topologies/diff endpoint, comparing the edges of two snapshots
+func (s *Server) diffTopologiesHandler(c *gin.Context) {
+	fromID := c.Query("from")
+	toID := c.Query("to")
+	if fromID == "" || toID == "" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "both from and to snapshot IDs must be provided")
+		return
+	}
+
+	repo := tenantOf(c).repo
+	fromDoc, err := repo.GetAdjacencyListByID(fromID)
+	if err != nil {
+		respondSnapshotError(c, fmt.Errorf("from: %w", err))
+		return
+	}
+
+	toDoc, err := repo.GetAdjacencyListByID(toID)
+	if err != nil {
+		respondSnapshotError(c, fmt.Errorf("to: %w", err))
+		return
+	}
+
+	added, removed, unchanged := topology.Diff(adjacencyListOf(fromDoc), adjacencyListOf(toDoc))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":          "success",
+		"from":            fromDoc.ID.Hex(),
+		"to":              toDoc.ID.Hex(),
+		"from_timestamp":  fromDoc.Timestamp.Format(time.RFC3339),
+		"to_timestamp":    toDoc.Timestamp.Format(time.RFC3339),
+		"added":           added,
+		"removed":         removed,
+		"unchanged":       unchanged,
+		"added_count":     topology.CountEdges(added),
+		"removed_count":   topology.CountEdges(removed),
+		"unchanged_count": topology.CountEdges(unchanged),
+	})
 }
 
-// getOCSPromptHandler handles the get_ocs_prompt endpoint
-func (s *Server) getOCSPromptHandler(c *gin.Context) {
-	// Get latest topology from MongoDB
-	adjacencyList, err := s.mongoRepo.GetLatestAdjacencyList()
+// topologyUnionHandler handles the topology/union endpoint, merging every
+// snapshot taken between from and to into one topology so that intermittent
+// edges missed by individual collections are still reported
+func (s *Server) topologyUnionHandler(c *gin.Context) {
+	fromStr := c.Query("from")
+	toStr := c.Query("to")
+	if fromStr == "" || toStr == "" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "both from and to timestamps must be provided")
+		return
+	}
+	from, err := parseTimestamp(fromStr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err),
-		})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("invalid from timestamp: %v", err))
+		return
+	}
+	to, err := parseTimestamp(toStr)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("invalid to timestamp: %v", err))
+		return
+	}
+	if from.After(*to) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "from must not be after to")
 		return
 	}
 
-	// Initialize empty map if nil
-	if adjacencyList == nil {
-		adjacencyList = make(map[string][]string)
+	// Read one past the cap to tell a full range from an oversized one
+	snapshots, err := tenantOf(c).repo.ListSnapshotsBetween(*from, *to, maxUnionSnapshots+1)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topologies from MongoDB: %v", err))
+		return
+	}
+	if len(snapshots) > maxUnionSnapshots {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("the time range holds more than %d snapshots; narrow from and to", maxUnionSnapshots))
+		return
 	}
 
-	// Build context definitions
-	contextDefinitions := buildContextDefinitions(adjacencyList, s.ocsConfig)
+	adjacencyLists := make([]map[string][]string, 0, len(snapshots))
+	for i := range snapshots {
+		adjacencyLists = append(adjacencyLists, adjacencyListOf(&snapshots[i]))
+	}
+	union, observations := topology.Union(adjacencyLists)
 
-	// Build response
-	response := OCSPromptResponse{
-		SpecVersion:        "0.1",
-		ContextDefinitions: contextDefinitions,
+	c.JSON(http.StatusOK, gin.H{
+		"status":            "success",
+		"from":              from.UTC().Format(time.RFC3339),
+		"to":                to.UTC().Format(time.RFC3339),
+		"snapshot_count":    len(snapshots),
+		"adjacency_list":    union,
+		"edge_observations": observations,
+		"source_count":      len(union),
+		"total_connections": topology.CountEdges(union),
+	})
+}
+
+// topologyCyclesHandler handles the topology/cycles endpoint, reporting circular
+// dependencies in the latest topology
+func (s *Server) topologyCyclesHandler(c *gin.Context) {
+	snapshot, ok := s.latestSnapshot(c)
+	if !ok {
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	cycles := topology.DetectCycles(adjacencyListOf(snapshot))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"snapshot_id": snapshot.ID.Hex(),
+		"cycles":      cycles,
+		"cycle_count": len(cycles),
+	})
 }
 
-// collectIstioMetricsHandler handles the collect_istio_metrics endpoint
-func (s *Server) collectIstioMetricsHandler(c *gin.Context) {
-	if len(s.ocsConfig.Workload) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  "error",
-			"message": "No source workloads configured in ocs_config.yaml",
-		})
+// topologySCCHandler handles the topology/scc endpoint, reporting tightly
+// coupled clusters of workloads in the latest topology
+func (s *Server) topologySCCHandler(c *gin.Context) {
+	snapshot, ok := s.latestSnapshot(c)
+	if !ok {
 		return
 	}
 
-	// Parse and validate timestamps
-	fromTimestamp, toTimestamp, err := parseTimestampParams(c, s.ocsConfig)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":  "error",
-			"message": err.Error(),
-		})
+	components := topology.StronglyConnectedComponents(adjacencyListOf(snapshot))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":          "success",
+		"snapshot_id":     snapshot.ID.Hex(),
+		"components":      components,
+		"component_count": len(components),
+	})
+}
+
+// topologyErrorsHandler handles the topology/errors endpoint, reporting edges in
+// the latest topology whose error rate exceeds a threshold, worst first
+func (s *Server) topologyErrorsHandler(c *gin.Context) {
+	threshold := s.config().errorRateThreshold()
+	if thresholdStr := c.Query("threshold"); thresholdStr != "" {
+		value, err := strconv.ParseFloat(thresholdStr, 64)
+		if err != nil || value < 0 || value > 1 {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "threshold must be a number between 0 and 1")
+			return
+		}
+		threshold = value
+	}
+
+	snapshot, ok := s.latestSnapshot(c)
+	if !ok {
 		return
 	}
 
-	// Query Prometheus via Istio connector
-	result, err := s.istioConnector.QueryMetrics(s.ocsConfig.Workload, fromTimestamp, toTimestamp)
+	edges := make([]ErrorEdge, 0)
+	for source, destinations := range snapshot.EdgeErrorRates {
+		for destination, rate := range destinations {
+			if rate > threshold {
+				edges = append(edges, ErrorEdge{
+					Source:      source,
+					Destination: destination,
+					ErrorRate:   rate,
+					Weight:      snapshot.EdgeWeights[source][destination],
+				})
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].ErrorRate != edges[j].ErrorRate {
+			return edges[i].ErrorRate > edges[j].ErrorRate
+		}
+		if edges[i].Source != edges[j].Source {
+			return edges[i].Source < edges[j].Source
+		}
+		return edges[i].Destination < edges[j].Destination
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":                "success",
+		"snapshot_id":           snapshot.ID.Hex(),
+		"threshold":             threshold,
+		"error_rates_collected": snapshot.EdgeErrorRates != nil,
+		"edges":                 edges,
+		"edge_count":            len(edges),
+	})
+}
+
+// topologyNeighborsHandler handles the topology/neighbors endpoint, reporting
+// the direct dependencies and dependents of one workload in the latest
+// topology, and with second_hop=true those one hop further out each way
+func (s *Server) topologyNeighborsHandler(c *gin.Context) {
+	// A catch-all parameter, since namespace-qualified workloads contain a slash
+	workload := strings.TrimPrefix(c.Param("workload"), "/")
+	if workload == "" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "workload is required")
+		return
+	}
+	secondHop, err := parseBoolParam(c, "second_hop")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": fmt.Sprintf("Failed to query Prometheus: %v", err),
-		})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "second_hop must be true or false")
+		return
+	}
+
+	snapshot, ok := s.latestSnapshot(c)
+	if !ok {
 		return
 	}
 
-	// Extract source and destination
-	adjacencyList := ExtractAdjacencyList(result)
+	adjacencyList := adjacencyListOf(snapshot)
+	dependents := topology.Reverse(adjacencyList)
+	_, hasDependencies := adjacencyList[workload]
+	_, hasDependents := dependents[workload]
+	if !hasDependencies && !hasDependents {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("workload %q is not in the latest topology", workload))
+		return
+	}
+
+	dependencies := append([]string{}, adjacencyList[workload]...)
+	sort.Strings(dependencies)
+
+	response := gin.H{
+		"status":       "success",
+		"snapshot_id":  snapshot.ID.Hex(),
+		"workload":     workload,
+		"dependencies": dependencies,
+		"dependents":   append([]string{}, dependents[workload]...),
+	}
+	if secondHop {
+		response["second_hop_dependencies"] = topology.WorkloadsAtHop(adjacencyList, workload, 2)
+		response["second_hop_dependents"] = topology.WorkloadsAtHop(dependents, workload, 2)
+	}
+	c.JSON(http.StatusOK, response)
+}
 
-	// Save to MongoDB
-	docID, err := s.mongoRepo.SaveAdjacencyList(adjacencyList)
+// topologyPathHandler handles the topology/path endpoint, finding how one
+// workload reaches another through the latest topology: the shortest path
+// and, on request, every simple path up to a length limit
+func (s *Server) topologyPathHandler(c *gin.Context) {
+	from, to := c.Query("from"), c.Query("to")
+	if from == "" || to == "" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "from and to are required")
+		return
+	}
+	all, err := parseBoolParam(c, "all")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":  "error",
-			"message": fmt.Sprintf("Failed to save to MongoDB: %v", err),
-		})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "all must be true or false")
 		return
 	}
+	maxHops, err := parseIntParam(c, "max_hops", defaultPathMaxHops)
+	if err != nil || maxHops < 1 || maxHops > maxPathMaxHops {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("max_hops must be between 1 and %d", maxPathMaxHops))
+		return
+	}
+	limit, err := parseIntParam(c, "limit", defaultPathLimit)
+	if err != nil || limit < 1 || limit > maxPathLimit {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxPathLimit))
+		return
+	}
+
+	snapshot, ok := s.latestSnapshot(c)
+	if !ok {
+		return
+	}
+
+	adjacencyList := adjacencyListOf(snapshot)
+	dependents := topology.Reverse(adjacencyList)
+	for _, workload := range []string{from, to} {
+		_, hasDependencies := adjacencyList[workload]
+		_, hasDependents := dependents[workload]
+		if !hasDependencies && !hasDependents {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("workload %q is not in the latest topology", workload))
+			return
+		}
+	}
 
+	path := topology.ShortestPath(adjacencyList, from, to)
 	response := gin.H{
+		"status":      "success",
+		"snapshot_id": snapshot.ID.Hex(),
+		"from":        from,
+		"to":          to,
+		"reachable":   path != nil,
+		"path":        append([]string{}, path...),
+		"hops":        max(len(path)-1, 0),
+	}
+	if all {
+		paths, truncated := topology.SimplePaths(adjacencyList, from, to, maxHops, limit)
+		response["paths"] = paths
+		response["path_count"] = len(paths)
+		response["truncated"] = truncated
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// topologyCentralityHandler handles the topology/centrality endpoint, ranking
+// the workloads of the latest topology by how connected they are
+func (s *Server) topologyCentralityHandler(c *gin.Context) {
+	withPageRank, err := parseBoolParam(c, "pagerank")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "pagerank must be true or false")
+		return
+	}
+	sortBy := c.DefaultQuery("sort", "degree")
+	switch sortBy {
+	case "degree", "in_degree", "out_degree":
+	case "pagerank":
+		withPageRank = true
+	default:
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("unsupported sort %q: must be degree, in_degree, out_degree or pagerank", sortBy))
+		return
+	}
+	limit, err := parseIntParam(c, "limit", 0)
+	if err != nil || limit < 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "limit must be a non-negative integer")
+		return
+	}
+
+	snapshot, ok := s.latestSnapshot(c)
+	if !ok {
+		return
+	}
+
+	adjacencyList := adjacencyListOf(snapshot)
+	in, out := topology.Degrees(adjacencyList)
+	var rank map[string]float64
+	if withPageRank {
+		rank = topology.PageRank(adjacencyList, pageRankDamping, pageRankIterations)
+	}
+	workloads := make([]WorkloadCentrality, 0, len(in))
+	for workload := range in {
+		centrality := WorkloadCentrality{
+			Workload:  workload,
+			InDegree:  in[workload],
+			OutDegree: out[workload],
+			Degree:    in[workload] + out[workload],
+		}
+		if rank != nil {
+			score := rank[workload]
+			centrality.PageRank = &score
+		}
+		workloads = append(workloads, centrality)
+	}
+
+	// Highest first by the requested measure, then by total degree and name so the order is stable
+	key := func(w WorkloadCentrality) float64 {
+		switch sortBy {
+		case "in_degree":
+			return float64(w.InDegree)
+		case "out_degree":
+			return float64(w.OutDegree)
+		case "pagerank":
+			return *w.PageRank
+		}
+		return float64(w.Degree)
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		if a, b := key(workloads[i]), key(workloads[j]); a != b {
+			return a > b
+		}
+		if workloads[i].Degree != workloads[j].Degree {
+			return workloads[i].Degree > workloads[j].Degree
+		}
+		return workloads[i].Workload < workloads[j].Workload
+	})
+	total := len(workloads)
+	if limit > 0 && limit < total {
+		workloads = workloads[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
 		"status":         "success",
-		"message":        "Metrics collected and saved to MongoDB",
-		"adjacency_list": adjacencyList,
-		"document_id":    docID.Hex(),
-		"timestamp":      time.Now().Format(time.RFC3339),
+		"snapshot_id":    snapshot.ID.Hex(),
+		"sort":           sortBy,
+		"workload_count": total,
+		"workloads":      workloads,
+	})
+}
+
+// topologyExportHandler handles the topology/export endpoint, serializing the
+// latest topology in the requested format
+func (s *Server) topologyExportHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", "dot")
+	if format != "dot" && format != "csv" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("unsupported export format %q: must be \"dot\" or \"csv\"", format))
+		return
 	}
 
-	if fromTimestamp != nil && toTimestamp != nil {
-		response["from_timestamp"] = fromTimestamp.Format(time.RFC3339)
-		response["to_timestamp"] = toTimestamp.Format(time.RFC3339)
+	snapshot, ok := s.latestSnapshot(c)
+	if !ok {
+		return
+	}
 
-		// If time window was used from config, include that info
-		fromStr := c.Query("from_timestamp")
-		toStr := c.Query("to_timestamp")
-		if s.ocsConfig.TimeWindowMinutes != nil && fromStr == "" && toStr == "" {
-			response["time_window_minutes"] = *s.ocsConfig.TimeWindowMinutes
+	if format == "csv" {
+		// Rows are streamed, so a write error can only be logged once they have started
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="topology-%s.csv"`, snapshot.ID.Hex()))
+		c.Status(http.StatusOK)
+		if err := topology.WriteCSV(c.Writer, adjacencyListOf(snapshot), snapshot.EdgeWeights); err != nil {
+			requestLogger(c).Warn("Failed to write CSV export", "error", err)
 		}
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	c.Data(http.StatusOK, "text/vnd.graphviz", []byte(topology.ToDOT(adjacencyListOf(snapshot), snapshot.EdgeWeights)))
+}
+
+// latestSnapshot loads the latest topology snapshot for a topology analysis
+// endpoint, writing an error response and returning false if there is none.
+// With stale_after, edges not observed within that window are left out.
+func (s *Server) latestSnapshot(c *gin.Context) (*store.AdjacencyListDocument, bool) {
+	staleAfter, err := parseStaleAfterParam(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return nil, false
+	}
+
+	snapshot, err := tenantOf(c).repo.GetLatestSnapshot()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+		return nil, false
+	}
+	if snapshot == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "No topology has been collected yet")
+		return nil, false
+	}
+	if staleAfter > 0 {
+		snapshot = dropStaleEdges(snapshot, time.Now().Add(-staleAfter))
+	}
+	return snapshot, true
 }
 
-// healthCheckHandler handles health check endpoint
+// respondSnapshotError maps an error from loading a snapshot by ID to an error response
+func respondSnapshotError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrInvalidSnapshotID):
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+	case errors.Is(err, store.ErrSnapshotNotFound):
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, err.Error())
+	default:
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+	}
+}
+
+// healthCheckHandler handles health check endpoint. It is a cheap liveness
+// check; see readinessHandler for dependency connectivity.
 func (s *Server) healthCheckHandler(c *gin.Context) {
 	response := gin.H{
 		"status":     "healthy",
-		"prometheus": s.istioConnector.prometheusURL != "",
-		"mongodb":    s.mongoRepo != nil,
+		"prometheus": s.istioConnector.InstanceCount() > 0,
+		"mongodb":    s.storeBackend != nil,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// readinessHandler handles the ready endpoint, checking that MongoDB and at
+// least one Prometheus instance are reachable. It reports not ready once the
+// server has begun shutting down.
+func (s *Server) readinessHandler(c *gin.Context) {
+	errs := make(map[string]string)
+
+	mongoReady := true
+	if err := s.storeBackend.Ping(); err != nil {
+		mongoReady = false
+		errs["mongodb"] = err.Error()
+	}
+
+	failures := s.istioConnector.Ping()
+	prometheusReady := len(failures) < s.istioConnector.InstanceCount()
+	for name, err := range failures {
+		errs["prometheus:"+name] = err.Error()
+	}
+
+	shuttingDown := false
+	select {
+	case <-s.shutdown:
+		shuttingDown = true
+	default:
+	}
+
+	response := gin.H{
+		"status":     "ready",
+		"prometheus": prometheusReady,
+		"mongodb":    mongoReady,
 		"timestamp":  time.Now().Format(time.RFC3339),
 	}
+	if len(errs) > 0 {
+		response["errors"] = errs
+	}
+
+	if !mongoReady || !prometheusReady || shuttingDown {
+		response["status"] = "not_ready"
+		response["shutting_down"] = shuttingDown
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
 	c.JSON(http.StatusOK, response)
 }
 
-// parseTimestampParams parses and validates timestamp query parameters
-func parseTimestampParams(c *gin.Context, config *OCSConfig) (*time.Time, *time.Time, error) {
+// parseTimestampParams parses and validates the from_timestamp, to_timestamp
+// and window query parameters with parseCollectionRange
+func parseTimestampParams(c *gin.Context, config *OCSConfig, mode string) (*time.Time, *time.Time, error) {
+	return parseCollectionRange(config, mode, c.Query("from_timestamp"), c.Query("to_timestamp"), c.Query("window"))
+}
+
+// parseCollectionRange parses and validates a collection's time range. A
+// window selects a range ending now. Without either, a range over the
+// collection window is used when mode is range, or when no mode is set and
+// time_window_minutes is configured.
+func parseCollectionRange(config *OCSConfig, mode, fromStr, toStr, windowStr string) (*time.Time, *time.Time, error) {
 	var fromTimestamp, toTimestamp *time.Time
 
-	// Check if timestamps are provided in query parameters
-	fromStr := c.Query("from_timestamp")
-	toStr := c.Query("to_timestamp")
+	if windowStr != "" {
+		if fromStr != "" || toStr != "" {
+			return nil, nil, fmt.Errorf("window cannot be combined with from_timestamp and to_timestamp")
+		}
+		if mode == CollectionModeInstant {
+			return nil, nil, fmt.Errorf("window cannot be used with mode %q", CollectionModeInstant)
+		}
+		window, err := time.ParseDuration(windowStr)
+		if err != nil || window <= 0 {
+			return nil, nil, fmt.Errorf("invalid window %q: use a positive duration such as 30m or 2h", windowStr)
+		}
+
+		now := time.Now()
+		fromTime := now.Add(-window)
+		return &fromTime, &now, nil
+	}
 
 	if fromStr != "" || toStr != "" {
 		// Parse provided timestamps
@@ -193,18 +1617,84 @@ func parseTimestampParams(c *gin.Context, config *OCSConfig) (*time.Time, *time.
 		} else if (fromTimestamp != nil && toTimestamp == nil) || (fromTimestamp == nil && toTimestamp != nil) {
 			return nil, nil, fmt.Errorf("both from_timestamp and to_timestamp must be provided together, or neither")
 		}
-	} else if config.TimeWindowMinutes != nil {
-		// No timestamps provided, but time window is configured - use it
-		now := time.Now()
-		windowDuration := time.Duration(*config.TimeWindowMinutes) * time.Minute
-		fromTime := now.Add(-windowDuration)
-		fromTimestamp = &fromTime
-		toTimestamp = &now
+
+		if mode == CollectionModeInstant {
+			return nil, nil, fmt.Errorf("from_timestamp and to_timestamp cannot be used with mode %q", CollectionModeInstant)
+		}
+	} else {
+		// No timestamps provided - use the time window if a range is wanted
+		fromTimestamp, toTimestamp = config.defaultCollectionRange(mode, time.Now())
 	}
 
 	return fromTimestamp, toTimestamp, nil
 }
 
+// splitList splits a comma-separated query parameter, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseIntParam parses an integer query parameter, returning def when it is absent
+func parseIntParam(c *gin.Context, name string, def int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseDepthParam parses the optional depth query parameter, defaulting to 1 (immediate dependencies only)
+func parseDepthParam(c *gin.Context) (int, error) {
+	depth, err := parseIntParam(c, "depth", 1)
+	if err != nil {
+		return 0, err
+	}
+	if depth < 1 {
+		return 0, fmt.Errorf("depth must be positive, got %d", depth)
+	}
+	return depth, nil
+}
+
+// parseFilterParams merges the repeatable filter=label=value query parameter
+// over the configured extra filters, validating the result
+func parseFilterParams(c *gin.Context, configured map[string]string) (map[string]string, error) {
+	params := c.QueryArray("filter")
+	if len(params) == 0 {
+		return configured, nil
+	}
+
+	filters := maps.Clone(configured)
+	if filters == nil {
+		filters = make(map[string]string, len(params))
+	}
+	for _, param := range params {
+		label, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid filter %q: use label=value", param)
+		}
+		filters[label] = value
+	}
+	if err := prometheus.ValidateExtraFilters(filters); err != nil {
+		return nil, err
+	}
+	return filters, nil
+}
+
+// parseBoolParam parses an optional boolean query parameter, defaulting to false
+func parseBoolParam(c *gin.Context, name string) (bool, error) {
+	value := c.Query(name)
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
 // parseTimestamp parses a timestamp string in RFC3339 or Unix format
 func parseTimestamp(timestampStr string) (*time.Time, error) {
 	// Try RFC3339 first
@@ -221,8 +1711,11 @@ func parseTimestamp(timestampStr string) (*time.Time, error) {
 	return nil, fmt.Errorf("unable to parse timestamp")
 }
 
-// buildContextDefinitions builds context definitions from adjacency list and config
-func buildContextDefinitions(adjacencyList map[string][]string, config *OCSConfig) []OCSContextDefinition {
+// buildContextDefinitions builds context definitions from adjacency list and config.
+// edgeWeights, lastSeen (when each workload's edges were last observed) and
+// workloadLabels (identity labels per workload) may be nil.
+// A depth above 1 adds transitive dependencies up to that many hops; see topology.ForWorkload.
+func buildContextDefinitions(adjacencyList map[string][]string, edgeWeights topology.EdgeWeights, lastSeen map[string]time.Time, workloadLabels topology.WorkloadLabels, workloadMetrics topology.WorkloadMetrics, config *OCSConfig, depth int) []OCSContextDefinition {
 	var contextDefinitions []OCSContextDefinition
 
 	// Create a context definition for each workload
@@ -236,28 +1729,57 @@ func buildContextDefinitions(adjacencyList map[string][]string, config *OCSConfi
 		}
 	}
 
+	// Workload names already present under a namespace-qualified key
+	qualifiedNames := make(map[string]bool)
+	for workload := range workloadSet {
+		if namespace, name := topology.SplitWorkloadKey(workload); namespace != "" {
+			qualifiedNames[name] = true
+		}
+	}
+
 	// Also include workloads from config that might not be in topology yet
 	for _, workload := range config.Workload {
-		workloadSet[workload] = true
+		if !qualifiedNames[workload] {
+			workloadSet[workload] = true
+		}
 	}
 
-	// Create context definition for each workload
+	// Index dependents once rather than rescanning the graph per workload
+	dependents := topology.Reverse(adjacencyList)
+
+	// Build in sorted order so responses are reproducible; since resource_id is
+	// derived from the workload key, this also orders them by resource_id
+	workloads := make([]string, 0, len(workloadSet))
 	for workload := range workloadSet {
-		contextDef := OCSContextDefinition{
-			ResourceID: fmt.Sprintf("workload-%s", workload),
-			Domain:     "compute.k8s",
-			Identity: map[string]interface{}{
-				"workload": workload,
-			},
-			Metrics: config.Metrics,
-			Policy:  config.Policy,
-		}
+		workloads = append(workloads, workload)
+	}
+	sort.Strings(workloads)
+
+	// Create context definition for each workload
+	for _, workload := range workloads {
+		contextDef := newContextDefinition(workload, config)
 
 		// Build topology from adjacency list
-		topology := buildTopology(adjacencyList, workload)
-		if len(topology) > 0 {
-			contextDef.Topology = topology
+		workloadTopology := topology.ForWorkload(adjacencyList, dependents, edgeWeights, workload, depth)
+		if len(workloadTopology) > 0 {
+			contextDef.Topology = workloadTopology
+		}
+
+		if t, ok := lastSeen[workload]; ok {
+			contextDef.LastSeen = t.Format(time.RFC3339)
+		}
+
+		// Only labels still configured are shown, so removing one takes effect without a new collection
+		for _, label := range config.IdentityLabels {
+			if value, ok := workloadLabels[workload][label]; ok {
+				contextDef.Identity[label] = value
+			}
 		}
+		if value, ok := workloadLabels[workload][prometheus.RawWorkloadLabel]; ok {
+			contextDef.Identity[prometheus.RawWorkloadLabel] = value
+		}
+
+		contextDef.Health = evaluateHealth(config.Metrics, workloadMetrics[workload])
 
 		contextDefinitions = append(contextDefinitions, contextDef)
 	}
@@ -265,27 +1787,57 @@ func buildContextDefinitions(adjacencyList map[string][]string, config *OCSConfi
 	return contextDefinitions
 }
 
-// buildTopology builds topology information for a specific workload
-func buildTopology(adjacencyList map[string][]string, workload string) map[string]interface{} {
-	topology := make(map[string]interface{})
+// sortContextDefinitions orders context definitions by resource_id, the order
+// buildContextDefinitions produces them in
+func sortContextDefinitions(definitions []OCSContextDefinition) {
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].ResourceID < definitions[j].ResourceID
+	})
+}
 
-	// Add dependencies (destinations this workload connects to)
-	if destinations, exists := adjacencyList[workload]; exists && len(destinations) > 0 {
-		topology["dependencies"] = destinations
+// newContextDefinition creates the context definition for a workload key, without topology
+func newContextDefinition(workload string, config *OCSConfig) OCSContextDefinition {
+	namespace, name := topology.SplitWorkloadKey(workload)
+	identity := map[string]interface{}{
+		"workload": name,
+	}
+	if namespace != "" {
+		identity["namespace"] = namespace
 	}
 
-	// Add reverse dependencies (workloads that connect to this one)
-	var reverseDeps []string
-	for source, destinations := range adjacencyList {
+	return OCSContextDefinition{
+		ResourceID: fmt.Sprintf("workload-%s", workload),
+		Domain:     config.domain(),
+		Identity:   identity,
+		Metrics:    presentedMetrics(config.Metrics),
+		Policy:     config.Policy,
+	}
+}
+
+// adjacencyListOf returns a snapshot's edges, deriving them from the edge
+// weights for documents stored without an adjacency list
+func adjacencyListOf(snapshot *store.AdjacencyListDocument) map[string][]string {
+	if snapshot.AdjacencyList == nil && snapshot.EdgeWeights != nil {
+		return snapshot.EdgeWeights.AdjacencyList()
+	}
+	return snapshot.AdjacencyList
+}
+
+// workloadLastSeen returns when each workload's edges were last observed in a snapshot
+func workloadLastSeen(snapshot *store.AdjacencyListDocument) map[string]time.Time {
+	lastSeen := make(map[string]time.Time)
+	for source, destinations := range adjacencyListOf(snapshot) {
+		if len(destinations) == 0 {
+			lastSeen[source] = snapshot.Timestamp
+		}
 		for _, dest := range destinations {
-			if dest == workload {
-				reverseDeps = append(reverseDeps, source)
+			seen := edgeLastSeen(snapshot, source, dest)
+			for _, workload := range []string{source, dest} {
+				if seen.After(lastSeen[workload]) {
+					lastSeen[workload] = seen
+				}
 			}
 		}
 	}
-	if len(reverseDeps) > 0 {
-		topology["dependents"] = reverseDeps
-	}
-
-	return topology
+	return lastSeen
 }
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/contexture/ocs/pkg/ocs/store"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// benchmarkGraph builds a layered graph of n workloads where each calls the
+// next fanout workloads, giving n·fanout edges
+func benchmarkGraph(n, fanout int) map[string][]string {
+	adj := make(map[string][]string, n)
+	for i := 0; i < n; i++ {
+		source := fmt.Sprintf("workload-%04d", i)
+		for j := 1; j <= fanout; j++ {
+			adj[source] = append(adj[source], fmt.Sprintf("workload-%04d", (i+j)%n))
+		}
+	}
+	return adj
+}
+
+// BenchmarkBuildContextDefinitions measures building the prompt for a graph
+// of 1000 workloads and 5000 edges
+func BenchmarkBuildContextDefinitions(b *testing.B) {
+	adj := benchmarkGraph(1000, 5)
+	config := &OCSConfig{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildContextDefinitions(adj, nil, nil, nil, nil, config, 1)
+	}
+}
+
+// benchmarkPrompt builds the prompt for a graph of 1000 workloads and 5000
+// weighted edges, with metrics and policies like a typical config
+func benchmarkPrompt() *OCSPromptResponse {
+	adj := benchmarkGraph(1000, 5)
+	weights := make(topology.EdgeWeights, len(adj))
+	for source, destinations := range adj {
+		weights[source] = make(map[string]float64, len(destinations))
+		for i, dest := range destinations {
+			weights[source][dest] = float64(100*i) + 0.5
+		}
+	}
+	config := &OCSConfig{
+		Metrics: []MetricConfig{{Name: "cpu_utilization", Type: "gauge", Unit: "percentage", Description: "Current CPU usage against pod limits", AggregationLogic: "average"}},
+		Policy:  []string{"sla violation if cpu utilization is greater than 90%"},
+	}
+	return &OCSPromptResponse{SpecVersion: "0.1", ContextDefinitions: buildContextDefinitions(adj, weights, nil, nil, nil, config, 1)}
+}
+
+// BenchmarkPromptEncoding compares encoding a large prompt as JSON and as
+// MessagePack, reporting the encoded size as bytes/response
+func BenchmarkPromptEncoding(b *testing.B) {
+	response := benchmarkPrompt()
+	encoders := map[string]func(io.Writer) error{
+		"json": func(w io.Writer) error { return json.NewEncoder(w).Encode(response) },
+		"msgpack": func(w io.Writer) error {
+			return codec.NewEncoder(w, &codec.MsgpackHandle{}).Encode(response)
+		},
+	}
+	for name, encode := range encoders {
+		b.Run(name, func(b *testing.B) {
+			var buf bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := encode(&buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(buf.Len()), "bytes/response")
+		})
+	}
+}
+
+func TestWritePromptResponseNegotiatesEncoding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := &OCSConfig{}
+	response := &OCSPromptResponse{SpecVersion: "0.1", ContextDefinitions: buildContextDefinitions(benchmarkGraph(3, 1), nil, nil, nil, nil, config, 1)}
+
+	tests := []struct {
+		accept      string
+		wantMsgPack bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/msgpack", true},
+		{"application/x-msgpack", true},
+		{"application/json, application/msgpack", false},
+		{"text/html", false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/get_ocs_prompt", nil)
+		if tt.accept != "" {
+			c.Request.Header.Set("Accept", tt.accept)
+		}
+		writePromptResponse(c, config, response)
+
+		var decoded OCSPromptResponse
+		var err error
+		contentType := w.Header().Get("Content-Type")
+		if tt.wantMsgPack {
+			if !strings.HasPrefix(contentType, "application/msgpack") {
+				t.Errorf("Accept %q: Content-Type = %q, want MessagePack", tt.accept, contentType)
+				continue
+			}
+			err = codec.NewDecoderBytes(w.Body.Bytes(), &codec.MsgpackHandle{}).Decode(&decoded)
+		} else {
+			if !strings.HasPrefix(contentType, "application/json") {
+				t.Errorf("Accept %q: Content-Type = %q, want JSON", tt.accept, contentType)
+				continue
+			}
+			err = json.Unmarshal(w.Body.Bytes(), &decoded)
+		}
+		if err != nil {
+			t.Errorf("Accept %q: decoding: %v", tt.accept, err)
+			continue
+		}
+		if decoded.SpecVersion != "0.1" || len(decoded.ContextDefinitions) != 3 || decoded.ContextDefinitions[0].ResourceID != "workload-workload-0000" {
+			t.Errorf("Accept %q: decoded %+v, want the written prompt", tt.accept, decoded)
+		}
+	}
+}
+
+func TestPaginatePrompt(t *testing.T) {
+	config := &OCSConfig{}
+	response := &OCSPromptResponse{ContextDefinitions: buildContextDefinitions(benchmarkGraph(25, 2), nil, nil, nil, nil, config, 1)}
+
+	seen := make(map[string]bool)
+	previous := ""
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+		page := paginatePrompt(response, cursor, 10)
+		for _, def := range page.ContextDefinitions {
+			if seen[def.ResourceID] {
+				t.Errorf("%s returned on more than one page", def.ResourceID)
+			}
+			if def.ResourceID <= previous {
+				t.Errorf("%s out of order after %s", def.ResourceID, previous)
+			}
+			seen[def.ResourceID] = true
+			previous = def.ResourceID
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(seen) != 25 {
+		t.Errorf("pages covered %d context definitions, want 25", len(seen))
+	}
+	if len(response.ContextDefinitions) != 25 {
+		t.Errorf("pagination modified the full prompt")
+	}
+}
+
+func TestGetOCSPromptCapsDefinitions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	maxDefinitions := 2
+	snapshot := &store.AdjacencyListDocument{
+		ID: primitive.NewObjectID(),
+		AdjacencyList: map[string][]string{
+			"frontend": {"checkout", "catalog"},
+			"checkout": {"payments", "catalog"},
+			"batch":    {"reports"},
+		},
+		Timestamp: time.Now(),
+	}
+	s := &Server{
+		ocsConfig:     &OCSConfig{Workload: []string{"frontend"}, MaxContextDefinitions: &maxDefinitions, PromptCacheTTLSeconds: new(int)},
+		defaultTenant: newTenant("", &fakeStore{latest: snapshot}),
+	}
+	router := gin.New()
+	router.GET("/get_ocs_prompt", s.resolveTenant, s.getOCSPromptHandler)
+
+	tests := []struct {
+		query         string
+		wantResources []string
+		wantTruncated bool
+	}{
+		// checkout has three neighbors; catalog and frontend two each, tied by resource_id
+		{"", []string{"workload-catalog", "workload-checkout"}, true},
+		{"?max_definitions=1", []string{"workload-checkout"}, true},
+		{"?max_definitions=0", []string{"workload-batch", "workload-catalog", "workload-checkout", "workload-frontend", "workload-payments", "workload-reports"}, false},
+		{"?max_definitions=3&limit=2", []string{"workload-catalog", "workload-checkout"}, true},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/get_ocs_prompt"+tt.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want 200: %s", tt.query, w.Code, w.Body)
+		}
+		var response OCSPromptResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		var resourceIDs []string
+		for _, definition := range response.ContextDefinitions {
+			resourceIDs = append(resourceIDs, definition.ResourceID)
+		}
+		if !reflect.DeepEqual(resourceIDs, tt.wantResources) {
+			t.Errorf("%q: resources = %v, want %v", tt.query, resourceIDs, tt.wantResources)
+		}
+		if response.Truncated != tt.wantTruncated || (tt.wantTruncated && response.TotalDefinitions != 6) {
+			t.Errorf("%q: truncated = %v, total_definitions = %d; want %v and 6", tt.query, response.Truncated, response.TotalDefinitions, tt.wantTruncated)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/get_ocs_prompt?max_definitions=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("max_definitions=-1: status = %d, want 400", w.Code)
+	}
+}
+
+func TestBuildContextDefinitionsDeterministic(t *testing.T) {
+	adj := benchmarkGraph(50, 3)
+	config := &OCSConfig{Workload: []string{"config-only", "workload-0007"}}
+
+	first := buildContextDefinitions(adj, nil, nil, nil, nil, config, 1)
+	for i := 1; i < len(first); i++ {
+		if first[i-1].ResourceID >= first[i].ResourceID {
+			t.Fatalf("context definitions not sorted by resource_id: %s before %s", first[i-1].ResourceID, first[i].ResourceID)
+		}
+	}
+
+	for run := 0; run < 20; run++ {
+		again := buildContextDefinitions(adj, nil, nil, nil, nil, config, 1)
+		if !reflect.DeepEqual(again, first) {
+			t.Fatalf("run %d produced a different order or content", run)
+		}
+	}
+}
+
+// fakeStore is a TopologyStore serving a fixed latest snapshot. Methods the
+// test doesn't use fall through to the nil embedded interface and panic.
+type fakeStore struct {
+	TopologyStore
+	latest *store.AdjacencyListDocument
+}
+
+func (f *fakeStore) GetLatestSnapshot() (*store.AdjacencyListDocument, error) {
+	return f.latest, nil
+}
+
+func TestGetOCSPromptHandlerWithFakeStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snapshot := &store.AdjacencyListDocument{
+		ID:            primitive.NewObjectID(),
+		AdjacencyList: map[string][]string{"frontend": {"checkout"}, "checkout": {"payments"}},
+		EdgeWeights:   topology.EdgeWeights{"frontend": {"checkout": 42}},
+		Timestamp:     time.Now(),
+	}
+	s := &Server{
+		ocsConfig:     &OCSConfig{Workload: []string{"frontend"}},
+		defaultTenant: newTenant("", &fakeStore{latest: snapshot}),
+	}
+	router := gin.New()
+	router.GET("/get_ocs_prompt", s.resolveTenant, s.getOCSPromptHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/get_ocs_prompt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var response OCSPromptResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.SpecVersion != "0.1" || response.SnapshotID != snapshot.ID.Hex() {
+		t.Errorf("spec_version = %q, snapshot_id = %q; want 0.1 and %s", response.SpecVersion, response.SnapshotID, snapshot.ID.Hex())
+	}
+	var resourceIDs []string
+	for _, definition := range response.ContextDefinitions {
+		resourceIDs = append(resourceIDs, definition.ResourceID)
+	}
+	if want := []string{"workload-checkout", "workload-frontend", "workload-payments"}; !reflect.DeepEqual(resourceIDs, want) {
+		t.Errorf("resource IDs = %v, want %v", resourceIDs, want)
+	}
+	frontend := response.ContextDefinitions[1].Topology
+	if !reflect.DeepEqual(frontend["dependencies"], []interface{}{"checkout"}) || !reflect.DeepEqual(frontend["dependency_weights"], map[string]interface{}{"checkout": 42.0}) {
+		t.Errorf("frontend topology = %v, want checkout with weight 42", frontend)
+	}
+}
+
+func TestGetOCSPromptLongPollWakesOnBucketMerge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// A bucket this long can't be crossed between the two saves
+	bucketSeconds := 100 * 365 * 24 * 60 * 60
+	config := &OCSConfig{Workload: []string{"frontend"}, SnapshotBucketSeconds: &bucketSeconds}
+	s := &Server{
+		ocsConfig:     config,
+		defaultTenant: newTenant("", store.NewInMemoryRepository()),
+		notifier:      NewSnapshotNotifier(),
+		shutdown:      make(chan struct{}),
+	}
+	router := gin.New()
+	router.GET("/get_ocs_prompt", s.resolveTenant, s.getOCSPromptHandler)
+
+	firstID, err := s.saveTopology(s.defaultTenant, config, topology.Graph{AdjacencyList: map[string][]string{"frontend": {"cart"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/get_ocs_prompt?wait=5s&since="+firstID.Hex(), nil))
+		done <- w
+	}()
+
+	secondID, err := s.saveTopology(s.defaultTenant, config, topology.Graph{AdjacencyList: map[string][]string{"frontend": {"checkout"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := <-done
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 once the bucket is merged into: %s", w.Code, w.Body)
+	}
+	var response OCSPromptResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.SnapshotID != secondID.Hex() {
+		t.Errorf("snapshot_id = %s, want the merged snapshot %s", response.SnapshotID, secondID.Hex())
+	}
+}
+
+func TestDropStaleEdges(t *testing.T) {
+	now := time.Now()
+	snapshot := &store.AdjacencyListDocument{
+		AdjacencyList: map[string][]string{"frontend": {"checkout", "legacy"}, "checkout": {"payments"}},
+		EdgeWeights:   topology.EdgeWeights{"frontend": {"checkout": 42, "legacy": 1}, "checkout": {"payments": 7}},
+		EdgeLastSeen: topology.EdgeLastSeen{
+			"frontend": {"checkout": now.Add(-time.Minute), "legacy": now.Add(-2 * time.Hour)},
+		},
+		Timestamp:        now.Add(-5 * time.Minute), // checkout -> payments predates edge timestamps
+		SourceCount:      2,
+		TotalConnections: 3,
+	}
+
+	filtered := dropStaleEdges(snapshot, now.Add(-30*time.Minute))
+
+	if want := map[string][]string{"frontend": {"checkout"}, "checkout": {"payments"}}; !reflect.DeepEqual(filtered.AdjacencyList, want) {
+		t.Errorf("adjacency list = %v, want %v", filtered.AdjacencyList, want)
+	}
+	if _, ok := filtered.EdgeWeights["frontend"]["legacy"]; ok {
+		t.Errorf("edge weights still include the stale edge: %v", filtered.EdgeWeights)
+	}
+	if filtered.TotalConnections != 2 || filtered.SourceCount != 2 {
+		t.Errorf("total_connections = %d, source_count = %d; want 2 and 2", filtered.TotalConnections, filtered.SourceCount)
+	}
+	if len(snapshot.AdjacencyList["frontend"]) != 2 {
+		t.Errorf("dropStaleEdges modified the original snapshot: %v", snapshot.AdjacencyList)
+	}
+}
+
+func TestTopologyPathHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snapshot := &store.AdjacencyListDocument{
+		ID:            primitive.NewObjectID(),
+		AdjacencyList: map[string][]string{"frontend": {"cart", "catalog"}, "cart": {"database", "frontend"}, "catalog": {"database"}},
+		Timestamp:     time.Now(),
+	}
+	s := &Server{
+		ocsConfig:     &OCSConfig{},
+		defaultTenant: newTenant("", &fakeStore{latest: snapshot}),
+	}
+	router := gin.New()
+	router.GET("/topology/path", s.resolveTenant, s.topologyPathHandler)
+
+	get := func(query string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/topology/path?"+query, nil))
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response := get("from=frontend&to=database&all=true")
+	if code != http.StatusOK || response["reachable"] != true || response["hops"] != 2.0 {
+		t.Fatalf("status = %d, response = %v; want a 2 hop path", code, response)
+	}
+	if got := fmt.Sprint(response["path"]); got != "[frontend cart database]" {
+		t.Errorf("path = %s, want the shortest path through cart", got)
+	}
+	if got := fmt.Sprint(response["paths"]); got != "[[frontend cart database] [frontend catalog database]]" {
+		t.Errorf("paths = %s, want both simple paths", got)
+	}
+
+	code, response = get("from=database&to=frontend")
+	if code != http.StatusOK || response["reachable"] != false || fmt.Sprint(response["path"]) != "[]" {
+		t.Errorf("unreachable: status = %d, response = %v; want reachable false and an empty path", code, response)
+	}
+	if _, ok := response["paths"]; ok {
+		t.Error("paths returned without all=true")
+	}
+
+	if code, _ := get("from=frontend&to=payments"); code != http.StatusNotFound {
+		t.Errorf("unknown workload: status = %d, want 404", code)
+	}
+	if code, _ := get("from=frontend&to=database&max_hops=11"); code != http.StatusBadRequest {
+		t.Errorf("max_hops over the cap: status = %d, want 400", code)
+	}
+}
+
+func TestTopologyCentralityHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snapshot := &store.AdjacencyListDocument{
+		ID:            primitive.NewObjectID(),
+		AdjacencyList: map[string][]string{"frontend": {"cart", "database"}, "cart": {"database"}, "orders": {"database"}},
+		Timestamp:     time.Now(),
+	}
+	s := &Server{
+		ocsConfig:     &OCSConfig{},
+		defaultTenant: newTenant("", &fakeStore{latest: snapshot}),
+	}
+	router := gin.New()
+	router.GET("/topology/centrality", s.resolveTenant, s.topologyCentralityHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/topology/centrality?sort=in_degree&limit=2", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var response struct {
+		WorkloadCount int                  `json:"workload_count"`
+		Workloads     []WorkloadCentrality `json:"workloads"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := []WorkloadCentrality{
+		{Workload: "database", InDegree: 3, Degree: 3},
+		{Workload: "cart", InDegree: 1, OutDegree: 1, Degree: 2},
+	}
+	if response.WorkloadCount != 4 || !reflect.DeepEqual(response.Workloads, want) {
+		t.Errorf("workloads = %+v of %d, want %+v of 4", response.Workloads, response.WorkloadCount, want)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/topology/centrality?sort=pagerank", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if top := response.Workloads[0]; top.Workload != "database" || top.PageRank == nil {
+		t.Errorf("top workload by pagerank = %+v, want database with a score", top)
+	}
+}
+
+func TestBulkPromptHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	snapshot := &store.AdjacencyListDocument{
+		ID:            primitive.NewObjectID(),
+		AdjacencyList: map[string][]string{"frontend": {"checkout"}, "checkout": {"payments"}},
+		Timestamp:     time.Now(),
+	}
+	s := &Server{
+		ocsConfig:     &OCSConfig{Workload: []string{"frontend"}},
+		defaultTenant: newTenant("", &fakeStore{latest: snapshot}),
+	}
+	router := gin.New()
+	router.POST("/get_ocs_prompt", s.resolveTenant, s.bulkPromptHandler)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/get_ocs_prompt", strings.NewReader(body)))
+		return w
+	}
+
+	w := post(`{"resource_ids": ["workload-payments", "workload-frontend"], "workloads": ["inventory", "payments"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var response OCSPromptResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	var resourceIDs []string
+	for _, definition := range response.ContextDefinitions {
+		resourceIDs = append(resourceIDs, definition.ResourceID)
+	}
+	if want := []string{"workload-payments", "workload-frontend", "workload-inventory"}; !reflect.DeepEqual(resourceIDs, want) {
+		t.Errorf("resource IDs = %v, want the requested order without duplicates %v", resourceIDs, want)
+	}
+	if deps := response.ContextDefinitions[2].Topology["dependencies"]; !reflect.DeepEqual(deps, []interface{}{}) {
+		t.Errorf("unknown workload topology = %v, want empty dependencies", response.ContextDefinitions[2].Topology)
+	}
+
+	for _, body := range []string{`{}`, `{"resource_ids": ["frontend"]}`, `{"resources": ["workload-frontend"]}`} {
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Errorf("body %s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
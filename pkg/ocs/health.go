@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+)
+
+// Health statuses computed for a workload from health_config thresholds
+const (
+	HealthOK   = "ok"
+	HealthWarn = "warn"
+	HealthCrit = "crit"
+)
+
+// Health polarities: whether values above or below the thresholds are unhealthy
+const (
+	PolarityHighIsBad = "high_is_bad"
+	PolarityLowIsBad  = "low_is_bad"
+)
+
+// defaultHealthWorkloadLabel is the label metric values are grouped by when health_config sets no workload_label
+const defaultHealthWorkloadLabel = "workload"
+
+// healthAggregations maps aggregation_logic values to the PromQL aggregation
+// combining a workload's series into one value; empty averages
+var healthAggregations = map[string]string{
+	"":        "avg",
+	"average": "avg",
+	"avg":     "avg",
+	"sum":     "sum",
+	"max":     "max",
+	"min":     "min",
+}
+
+// healthSeverity orders statuses so the worst across metrics can be picked
+var healthSeverity = map[string]int{HealthOK: 0, HealthWarn: 1, HealthCrit: 2}
+
+// healthThresholds is a metric's health_config: the thresholds a workload's
+// value is compared to, and how the value is queried
+type healthThresholds struct {
+	warn          *float64
+	crit          *float64
+	lowIsBad      bool
+	workloadLabel string
+	query         string // Optional PromQL returning one series per workload; overrides the generated query
+}
+
+// parseHealthThresholds reads thresholds from a metric's health_config:
+// warn (or warning_threshold) and crit (or critical_threshold), polarity
+// (high_is_bad by default or low_is_bad), workload_label and query. It returns
+// nil without an error when neither threshold is set, leaving health unscored.
+func parseHealthThresholds(config map[string]interface{}) (*healthThresholds, error) {
+	warn, err := healthNumber(config, "warn", "warning_threshold")
+	if err != nil {
+		return nil, err
+	}
+	crit, err := healthNumber(config, "crit", "critical_threshold")
+	if err != nil {
+		return nil, err
+	}
+	if warn == nil && crit == nil {
+		return nil, nil
+	}
+
+	thresholds := &healthThresholds{warn: warn, crit: crit, workloadLabel: defaultHealthWorkloadLabel}
+
+	polarity, err := healthString(config, "polarity")
+	if err != nil {
+		return nil, err
+	}
+	switch polarity {
+	case "", PolarityHighIsBad:
+	case PolarityLowIsBad:
+		thresholds.lowIsBad = true
+	default:
+		return nil, fmt.Errorf("polarity must be %q or %q, got %q", PolarityHighIsBad, PolarityLowIsBad, polarity)
+	}
+
+	if warn != nil && crit != nil {
+		if !thresholds.lowIsBad && *warn > *crit {
+			return nil, fmt.Errorf("warn (%g) must not exceed crit (%g) when high is bad", *warn, *crit)
+		}
+		if thresholds.lowIsBad && *warn < *crit {
+			return nil, fmt.Errorf("warn (%g) must not be below crit (%g) when low is bad", *warn, *crit)
+		}
+	}
+
+	label, err := healthString(config, "workload_label")
+	if err != nil {
+		return nil, err
+	}
+	if label != "" {
+		if !prometheus.IsValidLabelName(label) {
+			return nil, fmt.Errorf("workload_label %q is not a valid label name", label)
+		}
+		thresholds.workloadLabel = label
+	}
+
+	if thresholds.query, err = healthString(config, "query"); err != nil {
+		return nil, err
+	}
+	return thresholds, nil
+}
+
+// healthNumber returns the first of keys set in config as a number
+func healthNumber(config map[string]interface{}, keys ...string) (*float64, error) {
+	for _, key := range keys {
+		value, ok := config[key]
+		if !ok {
+			continue
+		}
+		var number float64
+		switch v := value.(type) {
+		case int:
+			number = float64(v)
+		case int64:
+			number = float64(v)
+		case uint64:
+			number = float64(v)
+		case float64:
+			number = v
+		default:
+			return nil, fmt.Errorf("%s must be a number, got %v", key, value)
+		}
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, fmt.Errorf("%s must be a finite number", key)
+		}
+		return &number, nil
+	}
+	return nil, nil
+}
+
+// healthString returns config[key] as a string, or empty when unset
+func healthString(config map[string]interface{}, key string) (string, error) {
+	value, ok := config[key]
+	if !ok {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, got %v", key, value)
+	}
+	return s, nil
+}
+
+// status returns the health status of value against the thresholds
+func (t *healthThresholds) status(value float64) string {
+	exceeds := func(threshold *float64) bool {
+		if threshold == nil {
+			return false
+		}
+		if t.lowIsBad {
+			return value <= *threshold
+		}
+		return value >= *threshold
+	}
+	switch {
+	case exceeds(t.crit):
+		return HealthCrit
+	case exceeds(t.warn):
+		return HealthWarn
+	default:
+		return HealthOK
+	}
+}
+
+// healthQuery returns the PromQL query for a metric's per-workload values:
+// the configured query, or the metric aggregated by the workload label (and
+// namespace when workloads are namespace-qualified)
+func healthQuery(metric MetricConfig, thresholds *healthThresholds, qualify bool) string {
+	if thresholds.query != "" {
+		return thresholds.query
+	}
+	by := thresholds.workloadLabel
+	if qualify {
+		by += ", namespace"
+	}
+	aggregation := healthAggregations[strings.ToLower(metric.AggregationLogic)]
+	return fmt.Sprintf("%s by (%s) (%s)", aggregation, by, metric.Name)
+}
+
+// workloadMetricQueries returns the queries collecting the per-workload
+// values of every metric with health thresholds
+func workloadMetricQueries(metrics []MetricConfig, qualify bool) []prometheus.WorkloadMetricQuery {
+	var queries []prometheus.WorkloadMetricQuery
+	for _, metric := range metrics {
+		thresholds, err := parseHealthThresholds(metric.HealthConfig)
+		if err != nil || thresholds == nil {
+			// Invalid health configs are rejected by Validate
+			continue
+		}
+		queries = append(queries, prometheus.WorkloadMetricQuery{
+			Name:          metric.Name,
+			Query:         healthQuery(metric, thresholds, qualify),
+			WorkloadLabel: thresholds.workloadLabel,
+			LowIsBad:      thresholds.lowIsBad,
+		})
+	}
+	return queries
+}
+
+// evaluateHealth scores a workload's collected metric values against the
+// thresholds configured now, so threshold changes apply without a new
+// collection. Values are reported in each metric's presentation unit. It
+// returns nil when no thresholded metric has a value.
+func evaluateHealth(metrics []MetricConfig, values map[string]float64) *WorkloadHealth {
+	var health *WorkloadHealth
+	for _, metric := range metrics {
+		value, ok := values[metric.Name]
+		if !ok {
+			continue
+		}
+		thresholds, err := parseHealthThresholds(metric.HealthConfig)
+		if err != nil || thresholds == nil {
+			continue
+		}
+
+		if health == nil {
+			health = &WorkloadHealth{Status: HealthOK}
+		}
+		status := thresholds.status(value)
+		presented, _ := presentValue(value, metric.Unit)
+		health.Metrics = append(health.Metrics, MetricHealth{Name: metric.Name, Value: presented, Status: status})
+		if healthSeverity[status] > healthSeverity[health.Status] {
+			health.Status = status
+		}
+	}
+	return health
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+)
+
+func TestParseHealthThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantNil bool
+		wantErr bool
+	}{
+		{"no thresholds", map[string]interface{}{"polarity": "high_is_bad"}, true, false},
+		{"short names", map[string]interface{}{"warn": 100, "crit": 500}, false, false},
+		{"long names", map[string]interface{}{"critical_threshold": 90.5, "polarity": "high_is_bad"}, false, false},
+		{"low is bad", map[string]interface{}{"warn": 10, "crit": 5, "polarity": "low_is_bad"}, false, false},
+		{"warn above crit", map[string]interface{}{"warn": 500, "crit": 100}, false, true},
+		{"warn below crit when low is bad", map[string]interface{}{"warn": 5, "crit": 10, "polarity": "low_is_bad"}, false, true},
+		{"unknown polarity", map[string]interface{}{"crit": 1, "polarity": "sideways"}, false, true},
+		{"non-numeric threshold", map[string]interface{}{"crit": "high"}, false, true},
+		{"invalid workload label", map[string]interface{}{"crit": 1, "workload_label": "not-a-label"}, false, true},
+	}
+	for _, tt := range tests {
+		thresholds, err := parseHealthThresholds(tt.config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (thresholds == nil) != tt.wantNil {
+			t.Errorf("%s: thresholds = %+v, want nil %v", tt.name, thresholds, tt.wantNil)
+		}
+	}
+}
+
+func TestEvaluateHealth(t *testing.T) {
+	metrics := []MetricConfig{
+		{Name: "latency_ms", HealthConfig: map[string]interface{}{"warn": 100, "crit": 500}},
+		{Name: "availability", HealthConfig: map[string]interface{}{"warn": 0.99, "crit": 0.95, "polarity": "low_is_bad"}},
+		{Name: "unscored", HealthConfig: map[string]interface{}{"polarity": "high_is_bad"}},
+	}
+
+	health := evaluateHealth(metrics, map[string]float64{"latency_ms": 120, "availability": 0.999, "unscored": 1})
+	want := &WorkloadHealth{Status: HealthWarn, Metrics: []MetricHealth{
+		{Name: "latency_ms", Value: 120, Status: HealthWarn},
+		{Name: "availability", Value: 0.999, Status: HealthOK},
+	}}
+	if !reflect.DeepEqual(health, want) {
+		t.Errorf("health = %+v, want %+v", health, want)
+	}
+
+	if health := evaluateHealth(metrics, map[string]float64{"availability": 0.9}); health.Status != HealthCrit {
+		t.Errorf("status = %q, want crit for availability below the critical threshold", health.Status)
+	}
+	if health := evaluateHealth(metrics, nil); health != nil {
+		t.Errorf("health = %+v, want nil without values", health)
+	}
+}
+
+func TestWorkloadMetricQueries(t *testing.T) {
+	metrics := []MetricConfig{
+		{Name: "latency_ms", AggregationLogic: "max", HealthConfig: map[string]interface{}{"crit": 200, "workload_label": "app"}},
+		{Name: "availability", HealthConfig: map[string]interface{}{"warn": 0.99, "polarity": "low_is_bad", "query": "avg by (workload) (up)"}},
+		{Name: "no_thresholds"},
+	}
+	want := []prometheus.WorkloadMetricQuery{
+		{Name: "latency_ms", Query: "max by (app, namespace) (latency_ms)", WorkloadLabel: "app"},
+		{Name: "availability", Query: "avg by (workload) (up)", WorkloadLabel: "workload", LowIsBad: true},
+	}
+	if got := workloadMetricQueries(metrics, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("queries = %+v, want %+v", got, want)
+	}
+}
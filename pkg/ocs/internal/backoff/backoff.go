@@ -0,0 +1,18 @@
+// Package backoff computes exponential retry delays with jitter.
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Delay returns the delay before the given retry: base·2^(retry−1),
+// with the upper half randomized so concurrent clients spread out
+func Delay(base time.Duration, retry int) time.Duration {
+	delay := base << (retry - 1)
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
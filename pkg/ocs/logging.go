@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Log output formats selectable with log_format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logLevel is the minimum level written by the default logger
+var logLevel = new(slog.LevelVar)
+
+// setupLogging installs the default structured logger for the configured
+// level ("info" or "debug") and format ("text" or "json")
+func setupLogging(level, format string) {
+	if strings.EqualFold(level, "debug") {
+		logLevel.Set(slog.LevelDebug)
+	} else {
+		logLevel.Set(slog.LevelInfo)
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if strings.EqualFold(format, LogFormatJSON) {
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied request IDs; longer ones are replaced
+const maxRequestIDLength = 128
+
+// Gin context keys set by requestIDMiddleware
+const (
+	requestIDKey     = "request_id"
+	requestLoggerKey = "request_logger"
+)
+
+// requestIDMiddleware accepts the client's X-Request-ID or generates one,
+// echoes it in the response header and stores it, along with a logger
+// tagged with it, in the Gin context for handlers
+func requestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		id = newRequestID()
+	}
+
+	c.Set(requestIDKey, id)
+	c.Set(requestLoggerKey, slog.With("request_id", id))
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
+// newRequestID generates a random 16 character hex request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID of the request being handled
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestLogger returns the logger tagged with the request's ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	if logger, ok := c.Value(requestLoggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
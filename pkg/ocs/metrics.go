@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics describing the OCS server itself, served on /metrics
+var (
+	collectRequestsTotal = promauto.NewCounterVec(promclient.CounterOpts{
+		Name: "ocs_collect_requests_total",
+		Help: "Number of collect_istio_metrics requests, by HTTP response code.",
+	}, []string{"code"})
+
+	prometheusQueryDuration = promauto.NewHistogramVec(promclient.HistogramOpts{
+		Name:    "ocs_prometheus_query_duration_seconds",
+		Help:    "Duration of Prometheus queries including retries, by instance, query type and outcome.",
+		Buckets: promclient.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"prometheus_instance", "query_type", "status"})
+
+	prometheusQueryCacheTotal = promauto.NewCounterVec(promclient.CounterOpts{
+		Name: "ocs_prometheus_query_cache_requests_total",
+		Help: "Number of Prometheus queries looked up in the query result cache, by result (hit or miss).",
+	}, []string{"result"})
+
+	mongodbWriteErrorsTotal = promauto.NewCounter(promclient.CounterOpts{
+		Name: "ocs_mongodb_write_errors_total",
+		Help: "Number of failed MongoDB writes.",
+	})
+
+	topologyEdges = promauto.NewGauge(promclient.GaugeOpts{
+		Name: "ocs_topology_edges",
+		Help: "Number of edges in the most recently collected topology.",
+	})
+
+	autoCollectRunsTotal = promauto.NewCounterVec(promclient.CounterOpts{
+		Name: "ocs_auto_collect_runs_total",
+		Help: "Number of scheduled collections, by status.",
+	}, []string{"status"})
+
+	webhookDeliveriesTotal = promauto.NewCounterVec(promclient.CounterOpts{
+		Name: "ocs_webhook_deliveries_total",
+		Help: "Number of topology change webhook deliveries, by final status.",
+	}, []string{"status"})
+)
+
+// countCollectRequest records a finished collect_istio_metrics request by its response code
+func countCollectRequest(c *gin.Context) {
+	collectRequestsTotal.WithLabelValues(strconv.Itoa(c.Writer.Status())).Inc()
+}
+
+// observeQueryCache records a query result cache lookup
+func observeQueryCache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	prometheusQueryCacheTotal.WithLabelValues(result).Inc()
+}
+
+// observePrometheusQuery records how long a query against an instance took
+func observePrometheusQuery(instance, queryType string, started time.Time, err error) {
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	prometheusQueryDuration.WithLabelValues(instance, queryType, status).Observe(time.Since(started).Seconds())
+}
@@ -0,0 +1,31 @@
+package main
+
+import "sync"
+
+// SnapshotNotifier broadcasts newly saved topology snapshots to waiting clients
+type SnapshotNotifier struct {
+	mu      sync.Mutex
+	waiters chan struct{}
+}
+
+// NewSnapshotNotifier creates a new snapshot notifier
+func NewSnapshotNotifier() *SnapshotNotifier {
+	return &SnapshotNotifier{
+		waiters: make(chan struct{}),
+	}
+}
+
+// Subscribe returns a channel that is closed when the next snapshot is published
+func (n *SnapshotNotifier) Subscribe() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.waiters
+}
+
+// Publish wakes all subscribers waiting for a new snapshot
+func (n *SnapshotNotifier) Publish() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.waiters)
+	n.waiters = make(chan struct{})
+}
@@ -0,0 +1,6 @@
+// Package ocspb holds the gRPC API of the OCS server, generated from ocs.proto.
+// Regenerate with go generate after editing ocs.proto; it needs buf,
+// protoc-gen-go and protoc-gen-go-grpc on the PATH.
+package ocspb
+
+//go:generate buf generate
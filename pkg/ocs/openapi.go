@@ -0,0 +1,47 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec describes every HTTP endpoint. TestOpenAPISpecMatchesRoutes and
+// TestOpenAPISchemasMatchTypes keep it in step with the routes and response types.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// swaggerUIVersion is the swagger-ui-dist release /docs loads from the CDN
+const swaggerUIVersion = "5.17.14"
+
+// apiDocsPage renders openapi.json with Swagger UI
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Contexture OCS API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+// openAPISpecHandler serves the OpenAPI 3 description of the HTTP API
+func openAPISpecHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpec)
+}
+
+// apiDocsHandler serves Swagger UI for the OpenAPI description
+func apiDocsHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(apiDocsPage))
+}
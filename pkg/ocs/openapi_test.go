@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+	"github.com/contexture/ocs/pkg/ocs/store"
+)
+
+// openAPIDocument is the part of the OpenAPI spec the tests compare
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPISpec(t *testing.T) openAPIDocument {
+	t.Helper()
+	var spec openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return spec
+}
+
+// ginParamPattern matches :name and *name gin path parameters
+var ginParamPattern = regexp.MustCompile(`[:*]([a-zA-Z_]+)`)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth, err := loadAPIKeyAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := loadEndpointLimits(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	registerRoutes(router, &Server{}, auth, limits)
+
+	var routes []string
+	for _, route := range router.Routes() {
+		path := ginParamPattern.ReplaceAllString(route.Path, "{$1}")
+		routes = append(routes, route.Method+" "+path)
+	}
+
+	var documented []string
+	for path, item := range loadOpenAPISpec(t).Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+
+	sort.Strings(routes)
+	sort.Strings(documented)
+	if !reflect.DeepEqual(routes, documented) {
+		t.Errorf("openapi.json paths = %v, want the registered routes %v", documented, routes)
+	}
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	types := map[string]interface{}{
+		"ErrorResponse":           ErrorResponse{},
+		"MetricConfig":            MetricConfig{},
+		"MetricHealth":            MetricHealth{},
+		"WorkloadHealth":          WorkloadHealth{},
+		"OCSContextDefinition":    OCSContextDefinition{},
+		"OCSPromptResponse":       OCSPromptResponse{},
+		"TopologySummary":         TopologySummary{},
+		"TopologyUpdate":          TopologyUpdate{},
+		"AdjacencyListDocument":   store.AdjacencyListDocument{},
+		"ErrorClassification":     prometheus.ErrorClassification{},
+		"WorkloadCentrality":      WorkloadCentrality{},
+		"ErrorEdge":               ErrorEdge{},
+		"AutoCollectStatus":       AutoCollectStatus{},
+		"BackfillFailure":         BackfillFailure{},
+		"BackfillProgress":        BackfillProgress{},
+		"WorkloadMetricsResponse": WorkloadMetricsResponse{},
+		"WorkloadMetricValue":     WorkloadMetricValue{},
+	}
+
+	schemas := loadOpenAPISpec(t).Components.Schemas
+	for name, value := range types {
+		schema, ok := schemas[name]
+		if !ok {
+			t.Errorf("openapi.json has no %s schema", name)
+			continue
+		}
+		var documented []string
+		for property := range schema.Properties {
+			documented = append(documented, property)
+		}
+		sort.Strings(documented)
+		if want := jsonFieldNames(reflect.TypeOf(value)); !reflect.DeepEqual(documented, want) {
+			t.Errorf("%s schema properties = %v, want the JSON fields %v", name, documented, want)
+		}
+	}
+}
+
+// jsonFieldNames returns the sorted names encoding/json gives a struct's fields
+func jsonFieldNames(typ reflect.Type) []string {
+	var names []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
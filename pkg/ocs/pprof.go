@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http/pprof"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pprofEnabledFromEnv reads ENABLE_PPROF, the default of the -enable-pprof
+// flag. Profiling is off unless explicitly enabled.
+func pprofEnabledFromEnv() (bool, error) {
+	value := os.Getenv("ENABLE_PPROF")
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid ENABLE_PPROF %q: %w", value, err)
+	}
+	return enabled, nil
+}
+
+// registerPprofRoutes mounts the net/http/pprof handlers under /debug/pprof.
+// Profiles expose the whole process, including every tenant's data in
+// memory, so they require a key not bound to a tenant when keys are
+// configured.
+func registerPprofRoutes(router *gin.Engine, auth *apiKeyAuth) {
+	debug := router.Group("/debug/pprof", auth.requireKey, auth.requireUnscopedKey)
+	debug.GET("/", gin.WrapF(pprof.Index))
+	debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/profile", gin.WrapF(pprof.Profile))
+	debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/trace", gin.WrapF(pprof.Trace))
+	// Index serves the named profiles such as heap, goroutine and allocs
+	debug.GET("/:profile", gin.WrapF(pprof.Index))
+}
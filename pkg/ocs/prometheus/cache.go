@@ -0,0 +1,138 @@
+package prometheus
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultQueryCacheSize is how many query results are cached when query_cache_ttl is set without query_cache_size
+const defaultQueryCacheSize = 256
+
+// queryCacheKey identifies a query result: the PromQL query, the range it
+// was run over in Unix seconds (zero for instant queries), and the decay
+// applied when turning the range into edge weights
+type queryCacheKey struct {
+	query    string
+	from, to int64
+	decay    time.Duration
+}
+
+// queryCacheEntry is a cached query result and when it expires
+type queryCacheEntry struct {
+	key     queryCacheKey
+	result  *QueryResult
+	expires time.Time
+}
+
+// queryCache is a least-recently-used cache of query results whose entries
+// expire after a TTL. Results are copied in and out, since callers such as
+// WorkloadNormalizer.Apply rewrite the series they are given.
+type queryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[queryCacheKey]*list.Element
+	order   *list.List // Most recently used first
+}
+
+// newQueryCache creates a cache holding at most size results for ttl each
+func newQueryCache(size int, ttl time.Duration) *queryCache {
+	return &queryCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[queryCacheKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns a copy of the unexpired result cached under key
+func (qc *queryCache) get(key queryCacheKey) (*QueryResult, bool) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	element, ok := qc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*queryCacheEntry)
+	if time.Now().After(entry.expires) {
+		qc.order.Remove(element)
+		delete(qc.entries, key)
+		return nil, false
+	}
+	qc.order.MoveToFront(element)
+	return cloneQueryResult(entry.result), true
+}
+
+// set caches a copy of result under key, evicting the least recently used
+// result when the cache is full
+func (qc *queryCache) set(key queryCacheKey, result *QueryResult) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+
+	entry := &queryCacheEntry{key: key, result: cloneQueryResult(result), expires: time.Now().Add(qc.ttl)}
+	if element, ok := qc.entries[key]; ok {
+		element.Value = entry
+		qc.order.MoveToFront(element)
+		return
+	}
+	qc.entries[key] = qc.order.PushFront(entry)
+	for qc.order.Len() > qc.size {
+		oldest := qc.order.Back()
+		qc.order.Remove(oldest)
+		delete(qc.entries, oldest.Value.(*queryCacheEntry).key)
+	}
+}
+
+// cloneQueryResult copies result deeply enough that rewriting or dropping
+// the copy's series and labels leaves result untouched
+func cloneQueryResult(result *QueryResult) *QueryResult {
+	clone := *result
+	clone.Data.Result = append(clone.Data.Result[:0:0], result.Data.Result...)
+	for i, series := range clone.Data.Result {
+		metric := make(map[string]string, len(series.Metric))
+		for k, v := range series.Metric {
+			metric[k] = v
+		}
+		clone.Data.Result[i].Metric = metric
+	}
+	clone.Warnings = append([]string(nil), result.Warnings...)
+	return &clone
+}
+
+// cachedQuery runs query through the result cache. Range queries have their
+// start and end rounded down to the cache bucket first, so windows a few
+// seconds apart share one result.
+func (ic *IstioConnector) cachedQuery(ctx context.Context, query string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	key := queryCacheKey{query: query}
+	if fromTimestamp != nil && toTimestamp != nil {
+		// A window shorter than the bucket would round to nothing, so it is queried as is
+		if from, to := fromTimestamp.Truncate(ic.cacheBucket), toTimestamp.Truncate(ic.cacheBucket); to.After(from) {
+			fromTimestamp, toTimestamp = &from, &to
+		}
+		key.from, key.to, key.decay = fromTimestamp.Unix(), toTimestamp.Unix(), opts.DecayHalfLife
+	}
+
+	if result, ok := ic.cache.get(key); ok {
+		ic.observeCache(true)
+		opts.Logger.Query("Served Prometheus query from cache", "query", query)
+		return result, nil
+	}
+	ic.observeCache(false)
+
+	result, err := ic.dispatch(ctx, query, fromTimestamp, toTimestamp, opts)
+	if err != nil {
+		return nil, err
+	}
+	ic.cache.set(key, result)
+	return result, nil
+}
+
+// observeCache reports a cache lookup to the ObserveCache hook, if any
+func (ic *IstioConnector) observeCache(hit bool) {
+	if ic.ObserveCache != nil {
+		ic.ObserveCache(hit)
+	}
+}
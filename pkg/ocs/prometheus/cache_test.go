@@ -0,0 +1,95 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newQueryCache(2, time.Minute)
+	result := func(status string) *QueryResult { return &QueryResult{Status: status} }
+
+	cache.set(queryCacheKey{query: "a"}, result("a"))
+	cache.set(queryCacheKey{query: "b"}, result("b"))
+	cache.get(queryCacheKey{query: "a"}) // a is now more recently used than b
+	cache.set(queryCacheKey{query: "c"}, result("c"))
+
+	if _, ok := cache.get(queryCacheKey{query: "b"}); ok {
+		t.Error("b was not evicted")
+	}
+	for _, query := range []string{"a", "c"} {
+		if got, ok := cache.get(queryCacheKey{query: query}); !ok || got.Status != query {
+			t.Errorf("get(%s) = %+v, %v; want the cached result", query, got, ok)
+		}
+	}
+
+	expired := newQueryCache(2, -time.Second)
+	expired.set(queryCacheKey{query: "a"}, result("a"))
+	if _, ok := expired.get(queryCacheKey{query: "a"}); ok {
+		t.Error("expired result served")
+	}
+}
+
+func TestQueryMetricsCached(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data": map[string]interface{}{
+				"resultType": "matrix",
+				"result": []interface{}{map[string]interface{}{
+					"metric": map[string]string{"source_workload": "app", "destination_workload": "db"},
+					"values": [][]interface{}{{float64(time.Now().Unix()), "1"}},
+				}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	connector := NewIstioConnector(&Config{
+		PrometheusInstances: []Instance{{Name: "test", BaseURL: server.URL, Step: "1m"}},
+		QueryCacheTTL:       "1m",
+		QueryCacheBucket:    "1m",
+	})
+	var hits, misses int
+	connector.ObserveCache = func(hit bool) {
+		if hit {
+			hits++
+		} else {
+			misses++
+		}
+	}
+
+	query := func(from, to time.Time) *QueryResult {
+		t.Helper()
+		result, err := connector.QueryMetrics(context.Background(), []string{"app"}, &from, &to, quietQueryOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	end := time.Now().Truncate(time.Minute).Add(10 * time.Second)
+	first := query(end.Add(-30*time.Minute), end)
+	// Rewriting a result, as normalization does, must not reach the cache
+	first.Data.Result[0].Metric["source_workload"] = "rewritten"
+
+	second := query(end.Add(-30*time.Minute+20*time.Second), end.Add(20*time.Second))
+	if requests.Load() != 1 || hits != 1 || misses != 1 {
+		t.Errorf("requests = %d, hits = %d, misses = %d after a window in the same bucket; want 1, 1, 1", requests.Load(), hits, misses)
+	}
+	if got := second.Data.Result[0].Metric["source_workload"]; got != "app" {
+		t.Errorf("cached source_workload = %q, want app", got)
+	}
+
+	query(end.Add(-29*time.Minute), end.Add(time.Minute))
+	if requests.Load() != 2 || misses != 2 {
+		t.Errorf("requests = %d, misses = %d after a window in the next bucket; want 2, 2", requests.Load(), misses)
+	}
+}
@@ -0,0 +1,66 @@
+package prometheus
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultErrorResponseCodes are the response codes counted as errors when
+// error_classification doesn't list any: every 5xx
+var DefaultErrorResponseCodes = []string{"5xx"}
+
+var (
+	responseCodePattern = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+	responseFlagPattern = regexp.MustCompile(`^[A-Z]+$`)
+)
+
+// ErrorClassification lists which requests count as errors when computing
+// edge error rates: those answered with one of ResponseCodes, or carrying
+// any of ResponseFlags. Codes are exact, e.g. 429, or a class such as 5xx.
+// Flags are Envoy response flags, e.g. UH for no healthy upstream, matched
+// against Istio's response_flags label.
+type ErrorClassification struct {
+	ResponseCodes []string `yaml:"response_codes" json:"response_codes"`
+	ResponseFlags []string `yaml:"response_flags" json:"response_flags"`
+}
+
+// Validate reports the first malformed response code or flag
+func (ec ErrorClassification) Validate() error {
+	for _, code := range ec.ResponseCodes {
+		if !responseCodePattern.MatchString(code) {
+			return fmt.Errorf("response code %q must be a status code such as 429 or a class such as 5xx", code)
+		}
+	}
+	for _, flag := range ec.ResponseFlags {
+		if !responseFlagPattern.MatchString(flag) {
+			return fmt.Errorf("response flag %q must be an Envoy response flag such as UH", flag)
+		}
+	}
+	return nil
+}
+
+// isError reports whether a request with the given response_code and
+// response_flags labels counts as an error. Istio joins several flags with
+// commas and reports "-" when there are none.
+func (ec ErrorClassification) isError(code, flags string) bool {
+	for _, pattern := range ec.ResponseCodes {
+		if class, ok := strings.CutSuffix(pattern, "xx"); ok {
+			if len(code) == 3 && strings.HasPrefix(code, class) {
+				return true
+			}
+		} else if code == pattern {
+			return true
+		}
+	}
+	if len(ec.ResponseFlags) == 0 || flags == "" || flags == "-" {
+		return false
+	}
+	for _, flag := range strings.Split(flags, ",") {
+		if slices.Contains(ec.ResponseFlags, strings.TrimSpace(flag)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+func TestExtractEdgeErrorRates(t *testing.T) {
+	var result QueryResult
+	err := json.Unmarshal([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [
+		{"metric": {"source_workload": "app", "destination_workload": "db", "response_code": "200", "response_flags": "-"}, "value": [0, "6"]},
+		{"metric": {"source_workload": "app", "destination_workload": "db", "response_code": "503", "response_flags": "UF,URX"}, "value": [0, "1"]},
+		{"metric": {"source_workload": "app", "destination_workload": "db", "response_code": "200", "response_flags": "UH"}, "value": [0, "2"]},
+		{"metric": {"source_workload": "app", "destination_workload": "db", "response_code": "429", "response_flags": "-"}, "value": [0, "1"]}
+	]}}`), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		classification ErrorClassification
+		want           float64
+	}{
+		{"5xx", ErrorClassification{ResponseCodes: DefaultErrorResponseCodes}, 0.1},
+		{"5xx and 429", ErrorClassification{ResponseCodes: []string{"5xx", "429"}}, 0.2},
+		{"5xx and UH", ErrorClassification{ResponseCodes: DefaultErrorResponseCodes, ResponseFlags: []string{"UH"}}, 0.3},
+		{"flags only", ErrorClassification{ResponseFlags: []string{"UH", "UF"}}, 0.3},
+	}
+	for _, tt := range tests {
+		got := ExtractEdgeErrorRates(&result, false, tt.classification)
+		if want := (topology.EdgeErrorRates{"app": {"db": tt.want}}); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: error rates = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestErrorClassificationValidate(t *testing.T) {
+	valid := ErrorClassification{ResponseCodes: []string{"5xx", "429"}, ResponseFlags: []string{"UH", "NR"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid classification rejected: %v", err)
+	}
+	for _, invalid := range []ErrorClassification{
+		{ResponseCodes: []string{"5XX"}},
+		{ResponseCodes: []string{"600"}},
+		{ResponseFlags: []string{"uh"}},
+	} {
+		if invalid.Validate() == nil {
+			t.Errorf("invalid classification %+v accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package prometheus
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultRangeStep is the Prometheus range query step used when none is configured
+	defaultRangeStep = "15s"
+	// defaultQueryTimeout is the Prometheus query timeout used when none is configured
+	defaultQueryTimeout = 30 * time.Second
+	// defaultRetryMaxAttempts and defaultRetryBaseDelay control Prometheus retries when not configured
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = "500ms"
+	// defaultQueryConcurrency bounds concurrent batched Prometheus queries when not configured
+	defaultQueryConcurrency = 4
+	// defaultUserAgentProduct names the server in the default User-Agent
+	defaultUserAgentProduct = "ocs-collector"
+	// maxGetURLLength is the longest query URL sent as a GET with query_method
+	// auto. Proxies commonly reject request lines over 8KiB with 414, and
+	// headers share that budget on some, so longer queries are POSTed.
+	maxGetURLLength = 4096
+)
+
+// HTTP methods used to send queries, set with query_method
+const (
+	// QueryMethodAuto sends a query as a GET unless its URL would be longer
+	// than maxGetURLLength, and as a POST form otherwise
+	QueryMethodAuto = "auto"
+	// QueryMethodGet always sends the query in the URL
+	QueryMethodGet = "get"
+	// QueryMethodPost always sends the query as an application/x-www-form-urlencoded body
+	QueryMethodPost = "post"
+)
+
+// Prometheus query modes for multiple configured instances
+const (
+	// ModeFailover queries instances in order until one succeeds
+	ModeFailover = "failover"
+	// ModeFanout queries all instances and merges their results
+	ModeFanout = "fanout"
+)
+
+// Instance represents a single Prometheus instance
+type Instance struct {
+	Name       string            `yaml:"name"`
+	BaseURL    string            `yaml:"base_url"`
+	Headers    map[string]string `yaml:"headers"`
+	DisableSSL bool              `yaml:"disable_ssl"`
+	Step       string            `yaml:"step"` // Optional: range query step, overrides the global step
+}
+
+// Config represents Prometheus configuration
+type Config struct {
+	PrometheusInstances []Instance        `yaml:"prometheus_instances"`
+	Mode                string            `yaml:"mode"`                  // Optional: "failover" (default) or "fanout"
+	Step                string            `yaml:"step"`                  // Optional: default range query step (default: 15s)
+	QueryTimeoutSeconds *int              `yaml:"query_timeout_seconds"` // Optional: HTTP timeout for Prometheus queries (default: 30)
+	RetryMaxAttempts    *int              `yaml:"retry_max_attempts"`    // Optional: attempts per query including the first (default: 3)
+	RetryBaseDelay      string            `yaml:"retry_base_delay"`      // Optional: backoff before the first retry (default: 500ms)
+	QueryBatchSize      *int              `yaml:"query_batch_size"`      // Optional: split workloads into queries of this many (default: one query for all)
+	QueryConcurrency    *int              `yaml:"query_concurrency"`     // Optional: batched queries run at once (default: 4)
+	FailOnWarnings      bool              `yaml:"fail_on_warnings"`      // Optional: treat a response with warnings as a failed query (default: false)
+	QueryCacheTTL       string            `yaml:"query_cache_ttl"`       // Optional: serve identical queries from a result cache this long, 0 disables (default: disabled)
+	QueryCacheSize      *int              `yaml:"query_cache_size"`      // Optional: most query results cached (default: 256)
+	QueryCacheBucket    string            `yaml:"query_cache_bucket"`    // Optional: round cached range queries' start and end down to a multiple of this (default: no rounding)
+	QueryMethod         string            `yaml:"query_method"`          // Optional: "auto" (default) POSTs queries too long for a URL, "get" or "post" always use that method
+	UserAgent           string            `yaml:"user_agent"`            // Optional: User-Agent sent with every request (default: ocs-collector/<server version>)
+	Headers             map[string]string `yaml:"headers"`               // Optional: headers sent to every instance, e.g. to attribute traffic; an instance's headers take precedence
+
+	// Version is the server version named in the default User-Agent. The
+	// server sets it; it isn't read from the YAML file.
+	Version string `yaml:"-"`
+
+	// Credentials sent to every instance, overriding any Authorization
+	// header. LoadConfig reads them from the environment so they stay out
+	// of the YAML file; they are never logged.
+	Username    string `yaml:"-"` // PROMETHEUS_USERNAME, with Password for basic auth
+	Password    string `yaml:"-"` // PROMETHEUS_PASSWORD
+	BearerToken string `yaml:"-"` // PROMETHEUS_BEARER_TOKEN
+}
+
+// Authentication methods reported by Config.AuthMethod
+const (
+	AuthNone   = "none"
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+)
+
+// AuthMethod reports which credentials are sent to Prometheus, for logging
+func (c *Config) AuthMethod() string {
+	switch {
+	case c.BearerToken != "":
+		return AuthBearer
+	case c.Username != "":
+		return AuthBasic
+	default:
+		return AuthNone
+	}
+}
+
+// LogValue logs the config with its credentials and Authorization headers redacted
+func (c *Config) LogValue() slog.Value {
+	type plain Config // Drops this method so logging the copy doesn't recurse
+	redacted := plain(*c)
+	if redacted.Password != "" {
+		redacted.Password = redactedValue
+	}
+	if redacted.BearerToken != "" {
+		redacted.BearerToken = redactedValue
+	}
+	redacted.Headers = redactHeaders(c.Headers)
+	redacted.PrometheusInstances = make([]Instance, len(c.PrometheusInstances))
+	for i, instance := range c.PrometheusInstances {
+		instance.Headers = redactHeaders(instance.Headers)
+		redacted.PrometheusInstances[i] = instance
+	}
+	return slog.AnyValue(redacted)
+}
+
+// redactHeaders returns a copy of headers with any Authorization header redacted
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(headers))
+	for key, value := range headers {
+		if http.CanonicalHeaderKey(key) == "Authorization" {
+			value = redactedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// redactedValue replaces secrets in logged values
+const redactedValue = "[REDACTED]"
+
+// loadCredentials reads the Prometheus credentials from the environment
+func (c *Config) loadCredentials() error {
+	c.Username = os.Getenv("PROMETHEUS_USERNAME")
+	c.Password = os.Getenv("PROMETHEUS_PASSWORD")
+	c.BearerToken = os.Getenv("PROMETHEUS_BEARER_TOKEN")
+
+	switch {
+	case c.Password != "" && c.Username == "":
+		return fmt.Errorf("PROMETHEUS_PASSWORD is set without PROMETHEUS_USERNAME")
+	case c.Username != "" && c.BearerToken != "":
+		return fmt.Errorf("set either PROMETHEUS_USERNAME/PROMETHEUS_PASSWORD or PROMETHEUS_BEARER_TOKEN, not both")
+	}
+	return nil
+}
+
+// LoadConfig loads Prometheus configuration from the YAML file at configPath
+func LoadConfig(configPath string) (*Config, error) {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("prometheus config not found: %s", configPath)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Prometheus config: %w", err)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse Prometheus config: %w", err)
+	}
+
+	if err := config.loadCredentials(); err != nil {
+		return nil, err
+	}
+
+	if len(config.PrometheusInstances) == 0 {
+		return nil, fmt.Errorf("no Prometheus instances configured")
+	}
+
+	switch config.Mode {
+	case "":
+		config.Mode = ModeFailover
+	case ModeFailover, ModeFanout:
+	default:
+		return nil, fmt.Errorf("invalid Prometheus mode %q: must be %q or %q", config.Mode, ModeFailover, ModeFanout)
+	}
+
+	if config.QueryTimeoutSeconds != nil && *config.QueryTimeoutSeconds <= 0 {
+		return nil, fmt.Errorf("query_timeout_seconds must be positive, got %d", *config.QueryTimeoutSeconds)
+	}
+
+	if config.RetryMaxAttempts != nil && *config.RetryMaxAttempts < 1 {
+		return nil, fmt.Errorf("retry_max_attempts must be at least 1, got %d", *config.RetryMaxAttempts)
+	}
+
+	if config.QueryBatchSize != nil && *config.QueryBatchSize < 0 {
+		return nil, fmt.Errorf("query_batch_size must not be negative, got %d", *config.QueryBatchSize)
+	}
+
+	if config.QueryConcurrency != nil && *config.QueryConcurrency < 1 {
+		return nil, fmt.Errorf("query_concurrency must be at least 1, got %d", *config.QueryConcurrency)
+	}
+
+	if config.RetryBaseDelay == "" {
+		config.RetryBaseDelay = defaultRetryBaseDelay
+	}
+	if d, err := time.ParseDuration(config.RetryBaseDelay); err != nil || d < 0 {
+		return nil, fmt.Errorf("invalid retry_base_delay %q: must be a non-negative duration", config.RetryBaseDelay)
+	}
+
+	if config.QueryCacheTTL != "" {
+		if d, err := time.ParseDuration(config.QueryCacheTTL); err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid query_cache_ttl %q: must be a non-negative duration", config.QueryCacheTTL)
+		}
+	}
+	if config.QueryCacheSize != nil && *config.QueryCacheSize < 1 {
+		return nil, fmt.Errorf("query_cache_size must be at least 1, got %d", *config.QueryCacheSize)
+	}
+	if config.QueryCacheBucket != "" {
+		if d, err := time.ParseDuration(config.QueryCacheBucket); err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid query_cache_bucket %q: must be a non-negative duration", config.QueryCacheBucket)
+		}
+	}
+
+	switch config.QueryMethod {
+	case "":
+		config.QueryMethod = QueryMethodAuto
+	case QueryMethodAuto, QueryMethodGet, QueryMethodPost:
+	default:
+		return nil, fmt.Errorf("invalid query_method %q: must be %q, %q or %q", config.QueryMethod, QueryMethodAuto, QueryMethodGet, QueryMethodPost)
+	}
+
+	if config.Step == "" {
+		config.Step = defaultRangeStep
+	}
+	if err := validateStep(config.Step); err != nil {
+		return nil, fmt.Errorf("invalid Prometheus step: %w", err)
+	}
+
+	for i := range config.PrometheusInstances {
+		instance := &config.PrometheusInstances[i]
+		if instance.Step == "" {
+			instance.Step = config.Step
+			continue
+		}
+		if err := validateStep(instance.Step); err != nil {
+			return nil, fmt.Errorf("invalid step for Prometheus instance %s: %w", instance.Name, err)
+		}
+	}
+
+	return &config, nil
+}
+
+// userAgent returns the User-Agent sent with every Prometheus request
+func (c *Config) userAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	if c.Version == "" {
+		return defaultUserAgentProduct
+	}
+	return defaultUserAgentProduct + "/" + c.Version
+}
+
+// queryTimeout returns the configured Prometheus query timeout
+func (c *Config) queryTimeout() time.Duration {
+	if c.QueryTimeoutSeconds == nil {
+		return defaultQueryTimeout
+	}
+	return time.Duration(*c.QueryTimeoutSeconds) * time.Second
+}
+
+// queryBatchSize returns how many workloads each Prometheus query covers, or zero for a single query
+func (c *Config) queryBatchSize() int {
+	if c.QueryBatchSize == nil {
+		return 0
+	}
+	return *c.QueryBatchSize
+}
+
+// queryConcurrency returns how many batched Prometheus queries may run at once
+func (c *Config) queryConcurrency() int {
+	if c.QueryConcurrency == nil {
+		return defaultQueryConcurrency
+	}
+	return *c.QueryConcurrency
+}
+
+// retryMaxAttempts returns the configured number of attempts per Prometheus query
+func (c *Config) retryMaxAttempts() int {
+	if c.RetryMaxAttempts == nil {
+		return defaultRetryMaxAttempts
+	}
+	return *c.RetryMaxAttempts
+}
+
+// retryBaseDelay returns the configured backoff before the first Prometheus retry
+func (c *Config) retryBaseDelay() time.Duration {
+	d, err := time.ParseDuration(c.RetryBaseDelay)
+	if err != nil {
+		d, _ = time.ParseDuration(defaultRetryBaseDelay)
+	}
+	return d
+}
+
+// queryCacheTTL returns how long query results are cached, or zero when caching is disabled
+func (c *Config) queryCacheTTL() time.Duration {
+	d, err := time.ParseDuration(c.QueryCacheTTL)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// queryCacheSize returns how many query results are cached at most
+func (c *Config) queryCacheSize() int {
+	if c.QueryCacheSize == nil {
+		return defaultQueryCacheSize
+	}
+	return *c.QueryCacheSize
+}
+
+// queryCacheBucket returns the multiple cached range queries' start and end are rounded down to
+func (c *Config) queryCacheBucket() time.Duration {
+	d, err := time.ParseDuration(c.QueryCacheBucket)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// validateStep checks that a range query step is a positive duration
+func validateStep(step string) error {
+	d, err := time.ParseDuration(step)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return fmt.Errorf("step must be positive, got %s", step)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigCredentials(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "prometheus_config.yaml")
+	yaml := "prometheus_instances:\n  - name: test\n    base_url: " + server.URL + "\n    headers:\n      Authorization: Bearer static-token\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"static header without env", nil, "Bearer static-token", false},
+		{"bearer token overrides header", map[string]string{"PROMETHEUS_BEARER_TOKEN": "env-token"}, "Bearer env-token", false},
+		{"basic auth overrides header", map[string]string{"PROMETHEUS_USERNAME": "ocs", "PROMETHEUS_PASSWORD": "s3cret"}, "Basic b2NzOnMzY3JldA==", false},
+		{"password without username", map[string]string{"PROMETHEUS_PASSWORD": "s3cret"}, "", true},
+		{"basic and bearer", map[string]string{"PROMETHEUS_USERNAME": "ocs", "PROMETHEUS_BEARER_TOKEN": "env-token"}, "", true},
+	}
+	for _, tt := range tests {
+		for _, key := range []string{"PROMETHEUS_USERNAME", "PROMETHEUS_PASSWORD", "PROMETHEUS_BEARER_TOKEN"} {
+			t.Setenv(key, tt.env[key])
+		}
+
+		config, err := LoadConfig(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if _, err := NewIstioConnector(config).QueryMetrics(context.Background(), []string{"app"}, nil, nil, quietQueryOptions()); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if authorization != tt.want {
+			t.Errorf("%s: Authorization = %q, want %q", tt.name, authorization, tt.want)
+		}
+
+		var logs bytes.Buffer
+		slog.New(slog.NewTextHandler(&logs, nil)).Info("config", "prometheus", config)
+		for _, secret := range []string{"static-token", "env-token", "s3cret"} {
+			if strings.Contains(logs.String(), secret) {
+				t.Errorf("%s: logged config contains %q: %s", tt.name, secret, logs.String())
+			}
+		}
+	}
+}
+
+func TestRequestIdentification(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer server.Close()
+
+	config := &Config{
+		PrometheusInstances: []Instance{{Name: "test", BaseURL: server.URL, Headers: map[string]string{"X-Team": "platform"}}},
+		Headers:             map[string]string{"X-Client": "ocs-prod", "X-Team": "shared"},
+		Version:             "v1.2.3",
+	}
+	query := func() {
+		t.Helper()
+		if _, err := NewIstioConnector(config).QueryMetrics(context.Background(), []string{"app"}, nil, nil, quietQueryOptions()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query()
+	if got := headers.Get("User-Agent"); got != "ocs-collector/v1.2.3" {
+		t.Errorf("User-Agent = %q, want ocs-collector/v1.2.3", got)
+	}
+	if headers.Get("X-Client") != "ocs-prod" || headers.Get("X-Team") != "platform" {
+		t.Errorf("X-Client = %q, X-Team = %q; want ocs-prod and the instance's platform", headers.Get("X-Client"), headers.Get("X-Team"))
+	}
+
+	config.UserAgent = "checkout-ocs"
+	query()
+	if got := headers.Get("User-Agent"); got != "checkout-ocs" {
+		t.Errorf("configured User-Agent = %q, want checkout-ocs", got)
+	}
+}
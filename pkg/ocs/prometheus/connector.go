@@ -0,0 +1,1139 @@
+// Package prometheus queries Istio request metrics from one or more
+// Prometheus instances and extracts workload topology from the results.
+package prometheus
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/contexture/ocs/pkg/ocs/internal/backoff"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// ErrWarnings is returned for a query whose response carried warnings when
+// fail_on_warnings is set
+var ErrWarnings = errors.New("Prometheus returned warnings")
+
+// instanceLabel is the label added to query results naming the Prometheus instance that returned them
+const instanceLabel = "prometheus_instance"
+
+// defaultMetricName is the Istio metric queried to build the topology when none is configured
+const defaultMetricName = "istio_requests_total"
+
+// Values of Istio's reporter label, which says which proxy recorded a
+// request. Each request through the mesh is recorded by both the source and
+// the destination sidecar, so matching every reporter counts edges twice.
+const (
+	ReporterSource      = "source"
+	ReporterDestination = "destination"
+	ReporterWaypoint    = "waypoint"
+	// ReporterAll adds no reporter matcher, for metrics without the label
+	ReporterAll = "all"
+)
+
+// DefaultReporter is the reporter the topology is matched on when none is configured
+const DefaultReporter = ReporterSource
+
+// maxRangePoints is the number of samples per series above which range queries use a wider step
+const maxRangePoints = 1000
+
+// IstioConnector handles Istio metrics queries via Prometheus
+type IstioConnector struct {
+	clients        []*prometheusClient
+	mode           string
+	batchSize      int           // Workloads per query; zero queries all workloads at once
+	concurrency    int           // Batched queries run at once
+	failOnWarnings bool          // Treat responses with warnings as failed queries
+	cache          *queryCache   // Nil unless query_cache_ttl is set
+	cacheBucket    time.Duration // Range query start and end are rounded down to this when caching
+
+	// ObserveQuery, when set, is called after every query against an
+	// instance with its type ("instant" or "range"), start time and error
+	ObserveQuery func(instance, queryType string, started time.Time, err error)
+	// ObserveCache, when set, is called for every query looked up in the
+	// result cache with whether it was served from there
+	ObserveCache func(hit bool)
+}
+
+// prometheusClient pairs a Prometheus instance with the HTTP client used to query it
+type prometheusClient struct {
+	instance    Instance
+	username    string // Basic auth credentials; empty sends none
+	password    string
+	bearerToken string // Sent instead of basic auth when set
+	httpClient  *http.Client
+	maxAttempts int           // Attempts per request, including the first
+	baseDelay   time.Duration // Backoff before the first retry, doubled on each retry
+	queryMethod string        // QueryMethodAuto, QueryMethodGet or QueryMethodPost
+	userAgent   string
+	headers     map[string]string // Sent to every instance, before the instance's own headers
+}
+
+// QueryOptions holds per-call options for QueryMetrics
+type QueryOptions struct {
+	// Logger controls per-query logging for the current run; nil logs every query at info level
+	Logger *QueryLogger
+	// DecayHalfLife applies exponential time decay to range query samples; zero disables decay
+	DecayHalfLife time.Duration
+	// MetricName is the metric the topology is built from; empty uses istio_requests_total
+	MetricName string
+	// Namespaces restricts the query to source workloads in these namespaces; empty queries all
+	Namespaces []string
+	// ExtraFilters adds a label="value" equality matcher to the query for each entry
+	ExtraFilters map[string]string
+	// Reporter is the reporter label value the query matches; empty uses
+	// DefaultReporter and ReporterAll matches every reporter. A reporter
+	// entry in ExtraFilters takes precedence.
+	Reporter string
+}
+
+// NewIstioConnector creates a new Istio connector for the configured Prometheus instances
+func NewIstioConnector(promConfig *Config) *IstioConnector {
+	clients := make([]*prometheusClient, 0, len(promConfig.PrometheusInstances))
+	for _, instance := range promConfig.PrometheusInstances {
+		clients = append(clients, newPrometheusClient(instance, promConfig))
+	}
+
+	ic := &IstioConnector{
+		clients:        clients,
+		mode:           promConfig.Mode,
+		batchSize:      promConfig.queryBatchSize(),
+		concurrency:    promConfig.queryConcurrency(),
+		failOnWarnings: promConfig.FailOnWarnings,
+	}
+	if ttl := promConfig.queryCacheTTL(); ttl > 0 {
+		ic.cache = newQueryCache(promConfig.queryCacheSize(), ttl)
+		ic.cacheBucket = promConfig.queryCacheBucket()
+	}
+	return ic
+}
+
+// newPrometheusClient creates the HTTP client for a Prometheus instance,
+// skipping TLS certificate verification when DisableSSL is set
+func newPrometheusClient(instance Instance, promConfig *Config) *prometheusClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if instance.DisableSSL {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &prometheusClient{
+		instance: instance,
+		httpClient: &http.Client{
+			Timeout:   promConfig.queryTimeout(),
+			Transport: transport,
+		},
+		username:    promConfig.Username,
+		password:    promConfig.Password,
+		bearerToken: promConfig.BearerToken,
+		maxAttempts: promConfig.retryMaxAttempts(),
+		baseDelay:   promConfig.retryBaseDelay(),
+		queryMethod: promConfig.QueryMethod,
+		userAgent:   promConfig.userAgent(),
+		headers:     promConfig.Headers,
+	}
+}
+
+// do executes a request, distinguishing a client-side timeout from other transport failures
+func (pc *prometheusClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := pc.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && urlErr.Timeout() {
+			return nil, fmt.Errorf("client-side timeout after %s waiting for Prometheus (raise query_timeout_seconds if the query is expected to be slow): %w", pc.httpClient.Timeout, err)
+		}
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	return resp, nil
+}
+
+// usePost reports whether a query whose GET URL would be urlLength bytes
+// long is sent as a POST form instead
+func (pc *prometheusClient) usePost(urlLength int) bool {
+	switch pc.queryMethod {
+	case QueryMethodPost:
+		return true
+	case QueryMethodGet:
+		return false
+	default:
+		return urlLength > maxGetURLLength
+	}
+}
+
+// send issues a request to the API endpoint at path with params, retrying
+// network errors and 5xx responses with exponential backoff and jitter.
+// Other responses, including 4xx which indicate a bad query, are returned to
+// the caller without retrying, as are 5xx responses whose QueryError is not
+// retryable. Retries stop early when ctx is cancelled. The params go in the
+// URL of a GET, or in an application/x-www-form-urlencoded POST body when
+// the query method calls for it, which Prometheus accepts on its query
+// endpoints for queries too long for a URL.
+func (pc *prometheusClient) send(ctx context.Context, path string, params url.Values, logger *QueryLogger) (*http.Response, error) {
+	endpoint := pc.instance.BaseURL + path
+	encoded := params.Encode()
+	method, target, form := http.MethodGet, endpoint+"?"+encoded, ""
+	if pc.usePost(len(target)) {
+		method, target, form = http.MethodPost, endpoint, encoded
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= pc.maxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := backoff.Delay(pc.baseDelay, attempt-1)
+			logger.Warn("Retrying Prometheus request",
+				"prometheus_instance", pc.instance.Name,
+				"prometheus_url", pc.instance.BaseURL,
+				"attempt", attempt,
+				"max_attempts", pc.maxAttempts,
+				"delay_ms", delay.Milliseconds(),
+				"error", lastErr)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, fmt.Errorf("retries cancelled: %w (last error: %v)", ctx.Err(), lastErr)
+			}
+		}
+
+		req, err := pc.newRequest(ctx, method, target, form)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := pc.do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			lastErr = statusError(resp.StatusCode, body)
+			var queryErr *QueryError
+			if errors.As(lastErr, &queryErr) && !queryErr.Retryable() {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// newRequest creates a request bound to ctx identified by the configured
+// User-Agent, with the headers configured for every instance applied, then
+// this instance's headers, then the configured credentials, which take
+// precedence over a static Authorization header. A non-empty form is sent as
+// an application/x-www-form-urlencoded body.
+func (pc *prometheusClient) newRequest(ctx context.Context, method, target, form string) (*http.Request, error) {
+	var body io.Reader
+	if form != "" {
+		body = strings.NewReader(form)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("User-Agent", pc.userAgent)
+	for key, value := range pc.headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range pc.instance.Headers {
+		req.Header.Set(key, value)
+	}
+	switch {
+	case pc.bearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+pc.bearerToken)
+	case pc.username != "":
+		req.SetBasicAuth(pc.username, pc.password)
+	}
+	return req, nil
+}
+
+// QueryMetrics queries Prometheus for the topology metric (istio_requests_total by default) filtered by source workload
+// If fromTimestamp and toTimestamp are provided, uses range query, otherwise uses instant query.
+// With a batch size configured, workloads are split into batches queried concurrently.
+// Cancelling ctx, as happens when the requesting client disconnects, aborts
+// in-flight Prometheus requests and pending retries.
+func (ic *IstioConnector) QueryMetrics(ctx context.Context, sourceWorkloads []string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	queries, err := ic.Queries(sourceWorkloads, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(queries) > 1 {
+		return ic.queryBatches(ctx, queries, fromTimestamp, toTimestamp, opts)
+	}
+	return ic.query(ctx, queries[0], fromTimestamp, toTimestamp, opts)
+}
+
+// Queries returns the PromQL queries QueryMetrics runs for the source
+// workloads: one per batch when batching applies, otherwise a single query
+func (ic *IstioConnector) Queries(sourceWorkloads []string, opts QueryOptions) ([]string, error) {
+	if len(sourceWorkloads) == 0 {
+		return nil, fmt.Errorf("no source workloads provided")
+	}
+
+	metricName := opts.MetricName
+	if metricName == "" {
+		metricName = defaultMetricName
+	}
+	if !IsValidMetricName(metricName) {
+		return nil, fmt.Errorf("invalid metric name %q", metricName)
+	}
+	if err := ValidateExtraFilters(opts.ExtraFilters); err != nil {
+		return nil, err
+	}
+	if err := ValidateReporter(opts.Reporter); err != nil {
+		return nil, err
+	}
+	if err := ValidateNames("workload", sourceWorkloads); err != nil {
+		return nil, err
+	}
+	if err := ValidateNames("namespace", opts.Namespaces); err != nil {
+		return nil, err
+	}
+
+	filters := reporterFilters(opts.ExtraFilters, opts.Reporter)
+	if ic.batchSize <= 0 || len(sourceWorkloads) <= ic.batchSize {
+		return []string{workloadQuery(metricName, sourceWorkloads, opts.Namespaces, filters)}, nil
+	}
+
+	var queries []string
+	for start := 0; start < len(sourceWorkloads); start += ic.batchSize {
+		batch := sourceWorkloads[start:min(start+ic.batchSize, len(sourceWorkloads))]
+		queries = append(queries, workloadQuery(metricName, batch, opts.Namespaces, filters))
+	}
+	return queries, nil
+}
+
+// reporterFilters returns extraFilters with a reporter matcher for reporter
+// added, unless extraFilters already matches on reporter or reporter is
+// ReporterAll
+func reporterFilters(extraFilters map[string]string, reporter string) map[string]string {
+	if reporter == "" {
+		reporter = DefaultReporter
+	}
+	if _, ok := extraFilters["reporter"]; ok || reporter == ReporterAll {
+		return extraFilters
+	}
+	filters := make(map[string]string, len(extraFilters)+1)
+	for label, value := range extraFilters {
+		filters[label] = value
+	}
+	filters["reporter"] = reporter
+	return filters
+}
+
+// ValidateReporter reports a reporter that is neither empty, a reporter label value Istio uses, nor ReporterAll
+func ValidateReporter(reporter string) error {
+	switch reporter {
+	case "", ReporterSource, ReporterDestination, ReporterWaypoint, ReporterAll:
+		return nil
+	}
+	return fmt.Errorf("reporter %q must be %q, %q, %q or %q", reporter, ReporterSource, ReporterDestination, ReporterWaypoint, ReporterAll)
+}
+
+// workloadQuery builds the PromQL query for the given source workloads, scoped
+// to namespaces if given and narrowed by the extra filters in label order.
+// Filter values are quoted as PromQL strings, so they can't end the matcher early.
+func workloadQuery(metricName string, sourceWorkloads, namespaces []string, extraFilters map[string]string) string {
+	matchers := "source_workload=~" + exactMatchRegex(sourceWorkloads)
+	if len(namespaces) > 0 {
+		matchers += ",source_workload_namespace=~" + exactMatchRegex(namespaces)
+	}
+	labels := make([]string, 0, len(extraFilters))
+	for label := range extraFilters {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		matchers += fmt.Sprintf(`,%s=%s`, label, strconv.Quote(extraFilters[label]))
+	}
+	return fmt.Sprintf(`%s{%s}`, metricName, matchers)
+}
+
+// exactMatchRegex returns a quoted PromQL regex matching exactly the given
+// names. Each name is escaped so regex metacharacters such as . or ( match
+// themselves, and the alternation is anchored. Prometheus anchors regex
+// matchers anyway, but being explicit keeps the pattern correct wherever it
+// ends up. The pattern is then quoted as a PromQL string, so quotes and
+// backslashes in names can't end the matcher early.
+func exactMatchRegex(names []string) string {
+	escaped := make([]string, len(names))
+	for i, name := range names {
+		escaped[i] = regexp.QuoteMeta(name)
+	}
+	return strconv.Quote("^(?:" + strings.Join(escaped, "|") + ")$")
+}
+
+// ValidateNames checks workload or namespace names before they are put into
+// a query: each must be non-empty valid UTF-8 without control characters
+func ValidateNames(kind string, names []string) error {
+	for _, name := range names {
+		switch {
+		case name == "":
+			return fmt.Errorf("%s name must not be empty", kind)
+		case !utf8.ValidString(name):
+			return fmt.Errorf("%s name %q is not valid UTF-8", kind, name)
+		case strings.ContainsFunc(name, unicode.IsControl):
+			return fmt.Errorf("%s name %q contains control characters", kind, name)
+		}
+	}
+	return nil
+}
+
+// queryBatches runs the per-batch queries through a pool of ic.concurrency
+// workers, merging the results in batch order. The first failing batch
+// cancels the rest and fails the whole query, since a partial topology would
+// silently drop edges.
+func (ic *IstioConnector) queryBatches(ctx context.Context, batches []string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	workers := min(ic.concurrency, len(batches))
+	opts.Logger.Info("Querying Prometheus in batches", "batch_count", len(batches), "batch_size", ic.batchSize, "concurrency", workers)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*QueryResult, len(batches))
+	jobs := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		firstErr error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result, err := ic.query(ctx, batches[i], fromTimestamp, toTimestamp, opts)
+				if err != nil {
+					failOnce.Do(func() {
+						firstErr = fmt.Errorf("batch %d of %d: %w", i+1, len(batches), err)
+						cancel()
+					})
+					continue
+				}
+				results[i] = result
+			}
+		}()
+	}
+
+feed:
+	for i := range batches {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		// Cancelled by the caller before every batch was dispatched
+		return nil, err
+	}
+
+	merged := &QueryResult{Status: "success"}
+	for _, result := range results {
+		merged.Data.ResultType = result.Data.ResultType
+		merged.Data.Result = append(merged.Data.Result, result.Data.Result...)
+		merged.Warnings = append(merged.Warnings, result.Warnings...)
+	}
+	return merged, nil
+}
+
+// query runs a single PromQL query, from the result cache when enabled
+func (ic *IstioConnector) query(ctx context.Context, query string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	if ic.cache != nil {
+		return ic.cachedQuery(ctx, query, fromTimestamp, toTimestamp, opts)
+	}
+	return ic.dispatch(ctx, query, fromTimestamp, toTimestamp, opts)
+}
+
+// dispatch runs a single PromQL query against the configured instances according to the mode
+func (ic *IstioConnector) dispatch(ctx context.Context, query string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	if ic.mode == ModeFanout {
+		return ic.queryFanout(ctx, query, fromTimestamp, toTimestamp, opts)
+	}
+	return ic.queryFailover(ctx, query, fromTimestamp, toTimestamp, opts)
+}
+
+// InstanceCount returns the number of configured Prometheus instances
+func (ic *IstioConnector) InstanceCount() int {
+	return len(ic.clients)
+}
+
+// Ping checks each Prometheus instance's health endpoint and returns the
+// error for every instance that is unreachable or unhealthy
+func (ic *IstioConnector) Ping() map[string]error {
+	failures := make(map[string]error)
+	for _, pc := range ic.clients {
+		if err := pc.ping(); err != nil {
+			failures[pc.instance.Name] = err
+		}
+	}
+	return failures
+}
+
+// ping requests the instance's /-/healthy endpoint with a short timeout
+func (pc *prometheusClient) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	req, err := pc.newRequest(ctx, http.MethodGet, pc.instance.BaseURL+"/-/healthy", "")
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := pc.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Prometheus returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// queryInstance runs the query against a single Prometheus instance and
+// labels each result with the instance name under instanceLabel. Warnings
+// are logged and prefixed with the instance name, or fail the query when
+// fail_on_warnings is set.
+func (ic *IstioConnector) queryInstance(ctx context.Context, pc *prometheusClient, query string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	var result *QueryResult
+	var err error
+	started := time.Now()
+	if fromTimestamp != nil && toTimestamp != nil {
+		result, err = ic.queryRange(ctx, pc, query, fromTimestamp, toTimestamp, opts)
+		ic.observe(pc.instance.Name, "range", started, err)
+	} else {
+		result, err = ic.queryInstant(ctx, pc, query, opts)
+		ic.observe(pc.instance.Name, "instant", started, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, warning := range result.Warnings {
+		opts.Logger.Warn("Prometheus returned a warning", "prometheus_instance", pc.instance.Name, "query", query, "warning", warning)
+		result.Warnings[i] = pc.instance.Name + ": " + warning
+	}
+	if len(result.Warnings) > 0 && ic.failOnWarnings {
+		return nil, fmt.Errorf("%w: %s", ErrWarnings, strings.Join(result.Warnings, "; "))
+	}
+
+	for i, r := range result.Data.Result {
+		metric := make(map[string]string, len(r.Metric)+1)
+		for k, v := range r.Metric {
+			metric[k] = v
+		}
+		metric[instanceLabel] = pc.instance.Name
+		result.Data.Result[i].Metric = metric
+	}
+	return result, nil
+}
+
+// observe reports a finished query to the ObserveQuery hook, if any
+func (ic *IstioConnector) observe(instance, queryType string, started time.Time, err error) {
+	if ic.ObserveQuery != nil {
+		ic.ObserveQuery(instance, queryType, started, err)
+	}
+}
+
+// queryFailover tries each instance in order and returns the first successful result
+func (ic *IstioConnector) queryFailover(ctx context.Context, query string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	var lastErr error
+	for _, pc := range ic.clients {
+		result, err := ic.queryInstance(ctx, pc, query, fromTimestamp, toTimestamp, opts)
+		if err != nil {
+			opts.Logger.Warn("Prometheus instance failed", "prometheus_instance", pc.instance.Name, "prometheus_url", pc.instance.BaseURL, "error", err)
+			lastErr = err
+			continue
+		}
+		opts.Logger.Info("Query served by Prometheus instance", "prometheus_instance", pc.instance.Name, "prometheus_url", pc.instance.BaseURL)
+		return result, nil
+	}
+	return nil, fmt.Errorf("all Prometheus instances failed: %w", lastErr)
+}
+
+// queryFanout queries every instance and merges the results.
+// It only fails if no instance returned a result.
+func (ic *IstioConnector) queryFanout(ctx context.Context, query string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	merged := &QueryResult{Status: "success"}
+	var lastErr error
+	succeeded := 0
+	for _, pc := range ic.clients {
+		result, err := ic.queryInstance(ctx, pc, query, fromTimestamp, toTimestamp, opts)
+		if err != nil {
+			opts.Logger.Warn("Prometheus instance failed", "prometheus_instance", pc.instance.Name, "prometheus_url", pc.instance.BaseURL, "error", err)
+			lastErr = err
+			continue
+		}
+		opts.Logger.Info("Query served by Prometheus instance", "prometheus_instance", pc.instance.Name, "prometheus_url", pc.instance.BaseURL)
+		merged.Data.ResultType = result.Data.ResultType
+		merged.Data.Result = append(merged.Data.Result, result.Data.Result...)
+		merged.Warnings = append(merged.Warnings, result.Warnings...)
+		succeeded++
+	}
+	if succeeded == 0 {
+		return nil, fmt.Errorf("all Prometheus instances failed: %w", lastErr)
+	}
+	return merged, nil
+}
+
+// queryRange executes a Prometheus range query
+func (ic *IstioConnector) queryRange(ctx context.Context, pc *prometheusClient, query string, fromTimestamp, toTimestamp *time.Time, opts QueryOptions) (*QueryResult, error) {
+	start := fromTimestamp.Unix()
+	end := toTimestamp.Unix()
+
+	step, err := time.ParseDuration(pc.instance.Step)
+	if err != nil {
+		return nil, fmt.Errorf("invalid step %q: %w", pc.instance.Step, err)
+	}
+	step = rangeStep(toTimestamp.Sub(*fromTimestamp), step)
+
+	params := url.Values{
+		"query": {query},
+		"start": {strconv.FormatInt(start, 10)},
+		"end":   {strconv.FormatInt(end, 10)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	}
+	opts.Logger.Query("Querying Prometheus",
+		"query_type", "range",
+		"prometheus_url", pc.instance.BaseURL,
+		"query", query,
+		"from", fromTimestamp.Format(time.RFC3339),
+		"to", toTimestamp.Format(time.RFC3339),
+		"step", step.String())
+
+	started := time.Now()
+	resp, err := pc.send(ctx, "/api/v1/query_range", params, opts.Logger)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, statusError(resp.StatusCode, body)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var rangeResult QueryRangeResult
+	if err := decodeResult(body, "matrix", &rangeResult); err != nil {
+		return nil, err
+	}
+
+	if rangeResult.Status != "success" {
+		if rangeResult.ErrorType != "" {
+			return nil, &QueryError{StatusCode: resp.StatusCode, ErrorType: rangeResult.ErrorType, Message: rangeResult.Error}
+		}
+		return nil, fmt.Errorf("Prometheus query failed with status: %s", rangeResult.Status)
+	}
+
+	opts.Logger.Info("Retrieved range results from Prometheus",
+		"prometheus_url", pc.instance.BaseURL,
+		"query", query,
+		"series_count", len(rangeResult.Data.Result),
+		"duration_ms", time.Since(started).Milliseconds())
+
+	// Convert range result to instant query result format
+	return ic.convertRangeToInstantResult(&rangeResult, *toTimestamp, opts), nil
+}
+
+// rangeStep widens the configured step when a window would otherwise return
+// more than maxRangePoints samples per series, rounding up to whole seconds
+func rangeStep(window, step time.Duration) time.Duration {
+	if window/step <= maxRangePoints {
+		return step
+	}
+	autoStep := (window + maxRangePoints - 1) / maxRangePoints
+	return ((autoStep + time.Second - 1) / time.Second) * time.Second
+}
+
+// queryInstant executes a Prometheus instant query
+func (ic *IstioConnector) queryInstant(ctx context.Context, pc *prometheusClient, query string, opts QueryOptions) (*QueryResult, error) {
+	params := url.Values{"query": {query}}
+	opts.Logger.Query("Querying Prometheus",
+		"query_type", "instant",
+		"prometheus_url", pc.instance.BaseURL,
+		"query", query)
+
+	started := time.Now()
+	resp, err := pc.send(ctx, "/api/v1/query", params, opts.Logger)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, statusError(resp.StatusCode, body)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var result QueryResult
+	if err := decodeResult(body, "vector", &result); err != nil {
+		return nil, err
+	}
+
+	if result.Status != "success" {
+		if result.ErrorType != "" {
+			return nil, &QueryError{StatusCode: resp.StatusCode, ErrorType: result.ErrorType, Message: result.Error}
+		}
+		return nil, fmt.Errorf("Prometheus query failed with status: %s", result.Status)
+	}
+
+	opts.Logger.Info("Retrieved results from Prometheus",
+		"prometheus_url", pc.instance.BaseURL,
+		"query", query,
+		"result_count", len(result.Data.Result),
+		"duration_ms", time.Since(started).Milliseconds())
+	return &result, nil
+}
+
+// convertRangeToInstantResult converts a range query result to instant query format
+// by extracting unique source-destination pairs from all time series values.
+// Each result's value is the series' edge weight as computed by rangeSeriesWeight,
+// timestamped with when the series was last observed (see rangeSeriesLastSeen).
+func (ic *IstioConnector) convertRangeToInstantResult(rangeResult *QueryRangeResult, end time.Time, opts QueryOptions) *QueryResult {
+	instantResult := &QueryResult{
+		Status:   rangeResult.Status,
+		Warnings: rangeResult.Warnings,
+	}
+	instantResult.Data.ResultType = "vector"
+
+	// Use a map to track unique metric combinations and their summed weights
+	var metricKeys []string
+	uniqueMetrics := make(map[string]map[string]string)
+	weights := make(map[string]float64)
+	lastSeen := make(map[string]time.Time)
+
+	for _, r := range rangeResult.Data.Result {
+		// Create a key from the metric labels (excluding timestamp values)
+		metricKey := fmt.Sprintf("%v", r.Metric)
+		if _, exists := uniqueMetrics[metricKey]; !exists {
+			uniqueMetrics[metricKey] = r.Metric
+			metricKeys = append(metricKeys, metricKey)
+		}
+		weights[metricKey] += rangeSeriesWeight(r.Values, end, opts.DecayHalfLife)
+		if seen, ok := rangeSeriesLastSeen(r.Values); ok && seen.After(lastSeen[metricKey]) {
+			lastSeen[metricKey] = seen
+		}
+	}
+
+	// Convert to result format
+	for _, metricKey := range metricKeys {
+		instantResult.Data.Result = append(instantResult.Data.Result, struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		}{
+			Metric: uniqueMetrics[metricKey],
+			Value:  []interface{}{sampleTime(lastSeen[metricKey], end), strconv.FormatFloat(weights[metricKey], 'f', -1, 64)},
+		})
+	}
+
+	opts.Logger.Info("Merged range series into unique metrics", "result_count", len(instantResult.Data.Result))
+	return instantResult
+}
+
+// rangeSeriesWeight computes the weight of a counter series from its range samples.
+//
+// The weight is the sum of the counter increments between consecutive samples,
+// each decayed by its age relative to end:
+//
+//	weight = Σ Δi · 0.5^((end − ti) / halfLife)
+//
+// where Δi is the increase between samples i−1 and i (a counter reset counts
+// the new value as the increase) and ti is the time of sample i. A zero
+// halfLife disables decay, so the weight is the plain increase over the range.
+//
+// A series with fewer than two usable samples has no measurable increase within
+// the range and weighs 0. Its counter value is a lifetime total rather than
+// traffic in the range, so using it would let one sample of a long-lived edge
+// outweigh busy edges. The edge itself is still reported.
+func rangeSeriesWeight(values [][]interface{}, end time.Time, halfLife time.Duration) float64 {
+	var weight, prev float64
+	havePrev := false
+
+	for _, v := range values {
+		ts, val, ok := parseSample(v)
+		if !ok {
+			continue
+		}
+
+		if havePrev {
+			delta := val - prev
+			if delta < 0 {
+				delta = val // Counter reset
+			}
+			if halfLife > 0 {
+				age := end.Sub(ts)
+				delta *= math.Pow(0.5, age.Seconds()/halfLife.Seconds())
+			}
+			weight += delta
+		}
+
+		prev = val
+		havePrev = true
+	}
+
+	return weight
+}
+
+// rangeSeriesLastSeen returns when a counter series last saw traffic: the time
+// of the last sample whose value increased over the previous one, or of the
+// first sample when the counter never moved within the range.
+func rangeSeriesLastSeen(values [][]interface{}) (time.Time, bool) {
+	var first, last time.Time
+	var prev float64
+	havePrev := false
+
+	for _, v := range values {
+		ts, val, ok := parseSample(v)
+		if !ok {
+			continue
+		}
+		if !havePrev {
+			first = ts
+		} else if val != prev {
+			last = ts // An increase, or a counter reset that recorded new requests
+		}
+		prev = val
+		havePrev = true
+	}
+
+	if !havePrev {
+		return time.Time{}, false
+	}
+	if last.IsZero() {
+		return first, true
+	}
+	return last, true
+}
+
+// sampleTime encodes t as a Prometheus sample timestamp, or fallback when t is zero
+func sampleTime(t, fallback time.Time) float64 {
+	if t.IsZero() {
+		t = fallback
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// parseSample parses a Prometheus [timestamp, "value"] sample pair
+func parseSample(sample []interface{}) (time.Time, float64, bool) {
+	if len(sample) != 2 {
+		return time.Time{}, 0, false
+	}
+
+	ts, ok := sample[0].(float64)
+	if !ok {
+		return time.Time{}, 0, false
+	}
+
+	valStr, ok := sample[1].(string)
+	if !ok {
+		return time.Time{}, 0, false
+	}
+
+	// Prometheus encodes missing data as "NaN"; skip it and infinities so they don't poison sums
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+		return time.Time{}, 0, false
+	}
+
+	sec, frac := math.Modf(ts)
+	return time.Unix(int64(sec), int64(frac*1e9)), val, true
+}
+
+// ExtractAdjacencyList extracts source and destination workloads from Prometheus results.
+// When qualify is true, workloads are keyed as namespace/workload.
+func ExtractAdjacencyList(result *QueryResult, qualify bool) map[string][]string {
+	adjacencyList := make(map[string][]string)
+
+	for _, r := range result.Data.Result {
+		source, destination := edgeEndpoints(r.Metric, qualify)
+
+		if source != "" && destination != "" {
+			if adjacencyList[source] == nil {
+				adjacencyList[source] = make([]string, 0)
+			}
+
+			// Check if destination already exists
+			exists := false
+			for _, dest := range adjacencyList[source] {
+				if dest == destination {
+					exists = true
+					break
+				}
+			}
+
+			if !exists {
+				adjacencyList[source] = append(adjacencyList[source], destination)
+			}
+		}
+	}
+
+	return adjacencyList
+}
+
+// ExtractEdgeWeights sums the sample values of Prometheus results per source-destination edge
+func ExtractEdgeWeights(result *QueryResult, qualify bool) topology.EdgeWeights {
+	edgeWeights := make(topology.EdgeWeights)
+
+	for _, r := range result.Data.Result {
+		source, destination := edgeEndpoints(r.Metric, qualify)
+		if source == "" || destination == "" {
+			continue
+		}
+
+		_, value, ok := parseSample(r.Value)
+		if !ok {
+			continue
+		}
+
+		if edgeWeights[source] == nil {
+			edgeWeights[source] = make(map[string]float64)
+		}
+		edgeWeights[source][destination] += value
+	}
+
+	return edgeWeights
+}
+
+// ExtractEdgeLastSeen records the latest sample timestamp of each
+// source-destination edge. For range collections the timestamp is when the edge
+// last saw traffic within the range; for instant queries it is the evaluation time.
+func ExtractEdgeLastSeen(result *QueryResult, qualify bool) topology.EdgeLastSeen {
+	lastSeen := make(topology.EdgeLastSeen)
+
+	for _, r := range result.Data.Result {
+		source, destination := edgeEndpoints(r.Metric, qualify)
+		if source == "" || destination == "" {
+			continue
+		}
+
+		ts, _, ok := parseSample(r.Value)
+		if !ok {
+			continue
+		}
+
+		if lastSeen[source] == nil {
+			lastSeen[source] = make(map[string]time.Time)
+		}
+		if ts.After(lastSeen[source][destination]) {
+			lastSeen[source][destination] = ts.UTC()
+		}
+	}
+
+	return lastSeen
+}
+
+// ExtractEdgeErrorRates computes the fraction of each edge's requests that
+// classification counts as errors. Results without a response_code label,
+// such as TCP metrics, are ignored.
+func ExtractEdgeErrorRates(result *QueryResult, qualify bool, classification ErrorClassification) topology.EdgeErrorRates {
+	totals := make(topology.EdgeWeights)
+	failures := make(topology.EdgeWeights)
+
+	for _, r := range result.Data.Result {
+		source, destination := edgeEndpoints(r.Metric, qualify)
+		code, hasCode := r.Metric["response_code"]
+		if source == "" || destination == "" || !hasCode {
+			continue
+		}
+
+		_, value, ok := parseSample(r.Value)
+		if !ok {
+			continue
+		}
+
+		if totals[source] == nil {
+			totals[source] = make(map[string]float64)
+			failures[source] = make(map[string]float64)
+		}
+		totals[source][destination] += value
+		if classification.isError(code, r.Metric["response_flags"]) {
+			failures[source][destination] += value
+		}
+	}
+
+	errorRates := make(topology.EdgeErrorRates)
+	for source, destinations := range totals {
+		for destination, total := range destinations {
+			if total <= 0 {
+				continue
+			}
+			if errorRates[source] == nil {
+				errorRates[source] = make(map[string]float64)
+			}
+			errorRates[source][destination] = failures[source][destination] / total
+		}
+	}
+
+	return errorRates
+}
+
+// ExtractEdgeInstances records which Prometheus instances reported each source-destination edge
+func ExtractEdgeInstances(result *QueryResult, qualify bool) map[string]map[string][]string {
+	edgeInstances := make(map[string]map[string][]string)
+
+	for _, r := range result.Data.Result {
+		source, destination := edgeEndpoints(r.Metric, qualify)
+		instance := r.Metric[instanceLabel]
+		if source == "" || destination == "" || instance == "" {
+			continue
+		}
+
+		if edgeInstances[source] == nil {
+			edgeInstances[source] = make(map[string][]string)
+		}
+
+		exists := false
+		for _, name := range edgeInstances[source][destination] {
+			if name == instance {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			edgeInstances[source][destination] = append(edgeInstances[source][destination], instance)
+		}
+	}
+
+	return edgeInstances
+}
+
+// ExtractWorkloadLabels records the given identity labels for each workload,
+// reading source_<label> for a series' source and destination_<label> for its
+// destination. Empty and "unknown" values are skipped. A workload reporting
+// several values for a label, such as two versions, gets them sorted and
+// comma-separated.
+func ExtractWorkloadLabels(result *QueryResult, qualify bool, labels []string) topology.WorkloadLabels {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	values := make(map[string]map[string]map[string]bool)
+	record := func(workload, label, value string) {
+		if workload == "" || value == "" || value == "unknown" {
+			return
+		}
+		if values[workload] == nil {
+			values[workload] = make(map[string]map[string]bool)
+		}
+		if values[workload][label] == nil {
+			values[workload][label] = make(map[string]bool)
+		}
+		values[workload][label][value] = true
+	}
+
+	for _, r := range result.Data.Result {
+		source, destination := edgeEndpoints(r.Metric, qualify)
+		for _, label := range labels {
+			record(source, label, r.Metric["source_"+label])
+			record(destination, label, r.Metric["destination_"+label])
+		}
+	}
+
+	workloadLabels := make(topology.WorkloadLabels, len(values))
+	for workload, byLabel := range values {
+		workloadLabels[workload] = make(map[string]string, len(byLabel))
+		for label, set := range byLabel {
+			distinct := make([]string, 0, len(set))
+			for value := range set {
+				distinct = append(distinct, value)
+			}
+			sort.Strings(distinct)
+			workloadLabels[workload][label] = strings.Join(distinct, ",")
+		}
+	}
+	return workloadLabels
+}
+
+// edgeEndpoints returns the source and destination workload keys of a result's
+// labels, qualified as namespace/workload when qualify is true and the namespace is known
+func edgeEndpoints(metric map[string]string, qualify bool) (string, string) {
+	source := metric["source_workload"]
+	destination := metric["destination_workload"]
+	if qualify {
+		source = topology.QualifyWorkload(metric["source_workload_namespace"], source)
+		destination = topology.QualifyWorkload(metric["destination_workload_namespace"], destination)
+	}
+	return source, destination
+}
+
+// labelNamePattern matches legal Prometheus label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// IsValidLabelName reports whether name is a legal Prometheus label name
+func IsValidLabelName(name string) bool {
+	return labelNamePattern.MatchString(name)
+}
+
+// reservedFilterLabels are set by the query itself and can't be extra filters
+var reservedFilterLabels = map[string]bool{
+	"__name__":                  true,
+	"source_workload":           true,
+	"source_workload_namespace": true,
+}
+
+// ValidateExtraFilters checks that every extra filter names a legal label the
+// query doesn't already match on, and has a valid UTF-8 value
+func ValidateExtraFilters(filters map[string]string) error {
+	for label, value := range filters {
+		switch {
+		case !IsValidLabelName(label):
+			return fmt.Errorf("extra filter label %q is not a valid label name", label)
+		case reservedFilterLabels[label]:
+			return fmt.Errorf("extra filter label %q is already matched by the query", label)
+		case !utf8.ValidString(value):
+			return fmt.Errorf("extra filter %q has a value that is not valid UTF-8", label)
+		}
+	}
+	return nil
+}
+
+// metricNamePattern matches legal PromQL metric names
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// IsValidMetricName reports whether name is a legal PromQL metric name
+func IsValidMetricName(name string) bool {
+	return metricNamePattern.MatchString(name)
+}
@@ -0,0 +1,469 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+var sourceWorkloadMatcher = regexp.MustCompile(`source_workload=~"\^\(\?:([^"]*)\)\$"`)
+
+// fakePrometheus serves instant queries with one series per queried source
+// workload. Each request costs a fixed latency plus a per-workload cost,
+// approximating how Prometheus slows down on large regex alternations.
+// Like Prometheus, it takes the query from the URL or a POST form.
+func fakePrometheus(t testing.TB, latency, perWorkload time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := sourceWorkloadMatcher.FindStringSubmatch(r.FormValue("query"))
+		if match == nil {
+			http.Error(w, "missing source_workload matcher", http.StatusBadRequest)
+			return
+		}
+		workloads := strings.Split(match[1], "|")
+		select {
+		case <-time.After(latency + time.Duration(len(workloads))*perWorkload):
+		case <-r.Context().Done():
+			return
+		}
+
+		var result QueryResult
+		result.Status = "success"
+		result.Data.ResultType = "vector"
+		for _, workload := range workloads {
+			result.Data.Result = append(result.Data.Result, struct {
+				Metric map[string]string `json:"metric"`
+				Value  []interface{}     `json:"value"`
+			}{
+				Metric: map[string]string{"source_workload": workload, "destination_workload": workload + "-db"},
+				Value:  []interface{}{float64(time.Now().Unix()), "1"},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestConnector(baseURL string, batchSize int) *IstioConnector {
+	concurrency := 4
+	return NewIstioConnector(&Config{
+		PrometheusInstances: []Instance{{Name: "test", BaseURL: baseURL}},
+		QueryBatchSize:      &batchSize,
+		QueryConcurrency:    &concurrency,
+	})
+}
+
+func testWorkloads(n int) []string {
+	workloads := make([]string, n)
+	for i := range workloads {
+		workloads[i] = fmt.Sprintf("workload-%03d", i)
+	}
+	return workloads
+}
+
+func quietQueryOptions() QueryOptions {
+	return QueryOptions{Logger: NewQueryLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), false)}
+}
+
+func TestQueryMetricsBatchedMatchesSingle(t *testing.T) {
+	server := fakePrometheus(t, 0, 0)
+	workloads := testWorkloads(23)
+
+	single, err := newTestConnector(server.URL, 0).QueryMetrics(context.Background(), workloads, nil, nil, quietQueryOptions())
+	if err != nil {
+		t.Fatalf("single query: %v", err)
+	}
+	batched, err := newTestConnector(server.URL, 5).QueryMetrics(context.Background(), workloads, nil, nil, quietQueryOptions())
+	if err != nil {
+		t.Fatalf("batched query: %v", err)
+	}
+
+	want := ExtractAdjacencyList(single, false)
+	got := ExtractAdjacencyList(batched, false)
+	if len(got) != len(workloads) || !reflect.DeepEqual(got, want) {
+		t.Errorf("batched adjacency list = %v, want %v", got, want)
+	}
+}
+
+// TestQueryMethod checks that queries too long for a URL are POSTed as a
+// form in auto mode, and that get and post force their method
+func TestQueryMethod(t *testing.T) {
+	var methods []string
+	fake := fakePrometheus(t, 0, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	many := testWorkloads(500) // Well over maxGetURLLength once escaped
+	for _, tt := range []struct {
+		method    string
+		workloads []string
+		want      string
+	}{
+		{"", []string{"app"}, http.MethodGet},
+		{QueryMethodAuto, many, http.MethodPost},
+		{QueryMethodGet, many, http.MethodGet},
+		{QueryMethodPost, []string{"app"}, http.MethodPost},
+	} {
+		methods = nil
+		ic := NewIstioConnector(&Config{PrometheusInstances: []Instance{{Name: "test", BaseURL: server.URL}}, QueryMethod: tt.method})
+		result, err := ic.QueryMetrics(context.Background(), tt.workloads, nil, nil, quietQueryOptions())
+		if err != nil {
+			t.Fatalf("query_method %q: %v", tt.method, err)
+		}
+		if len(methods) != 1 || methods[0] != tt.want {
+			t.Errorf("query_method %q with %d workloads sent %v, want %s", tt.method, len(tt.workloads), methods, tt.want)
+		}
+		if len(result.Data.Result) != len(tt.workloads) {
+			t.Errorf("query_method %q: %d series, want %d", tt.method, len(result.Data.Result), len(tt.workloads))
+		}
+	}
+}
+
+func TestQueryMetricsBatchFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad query", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := newTestConnector(server.URL, 5).QueryMetrics(context.Background(), testWorkloads(23), nil, nil, quietQueryOptions())
+	if err == nil || !strings.Contains(err.Error(), "batch") {
+		t.Errorf("expected a batch error, got %v", err)
+	}
+}
+
+func TestExtractWorkloadLabels(t *testing.T) {
+	var result QueryResult
+	err := json.Unmarshal([]byte(`{"status": "success", "data": {"resultType": "vector", "result": [
+		{"metric": {"source_workload": "app", "source_version": "v1", "source_cluster": "east",
+			"destination_workload": "db", "destination_version": "unknown", "destination_cluster": "east"}, "value": [0, "1"]},
+		{"metric": {"source_workload": "app", "source_version": "v2", "source_cluster": "east",
+			"destination_workload": "cache", "destination_version": "v7"}, "value": [0, "1"]}
+	]}}`), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := ExtractWorkloadLabels(&result, false, []string{"version", "cluster"})
+	want := topology.WorkloadLabels{
+		"app":   {"version": "v1,v2", "cluster": "east"},
+		"db":    {"cluster": "east"},
+		"cache": {"version": "v7"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractWorkloadLabels = %v, want %v", got, want)
+	}
+
+	if got := ExtractWorkloadLabels(&result, false, nil); got != nil {
+		t.Errorf("expected no labels when none are configured, got %v", got)
+	}
+}
+
+func TestQueryMetricsCancelled(t *testing.T) {
+	server := fakePrometheus(t, 5*time.Second, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	started := time.Now()
+	_, err := newTestConnector(server.URL, 5).QueryMetrics(ctx, testWorkloads(23), nil, nil, quietQueryOptions())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("query returned %v after cancellation, want promptly", elapsed)
+	}
+}
+
+// BenchmarkQueryMetrics compares one large regex query against batched
+// concurrent queries for a fleet of 200 workloads.
+func BenchmarkQueryMetrics(b *testing.B) {
+	server := fakePrometheus(b, 2*time.Millisecond, 50*time.Microsecond)
+	workloads := testWorkloads(200)
+
+	for _, bc := range []struct {
+		name      string
+		batchSize int
+	}{
+		{"single", 0},
+		{"batch=10", 10},
+		{"batch=50", 50},
+		{"batch=100", 100},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			connector := newTestConnector(server.URL, bc.batchSize)
+			for i := 0; i < b.N; i++ {
+				if _, err := connector.QueryMetrics(context.Background(), workloads, nil, nil, quietQueryOptions()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryWarnings(t *testing.T) {
+	serve := func(warnings ...string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":   "success",
+				"data":     map[string]interface{}{"resultType": "vector", "result": []interface{}{}},
+				"warnings": warnings,
+			})
+		}))
+	}
+	partial := serve("partial response: store unavailable")
+	defer partial.Close()
+	clean := serve()
+	defer clean.Close()
+
+	config := &Config{
+		PrometheusInstances: []Instance{{Name: "partial", BaseURL: partial.URL}, {Name: "clean", BaseURL: clean.URL}},
+		Mode:                ModeFanout,
+	}
+	result, err := NewIstioConnector(config).QueryMetrics(context.Background(), []string{"app"}, nil, nil, quietQueryOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"partial: partial response: store unavailable"}; !reflect.DeepEqual(result.Warnings, want) {
+		t.Errorf("warnings = %q, want %q", result.Warnings, want)
+	}
+
+	// With fail_on_warnings, failover skips the instance with warnings
+	config.Mode = ModeFailover
+	config.FailOnWarnings = true
+	result, err = NewIstioConnector(config).QueryMetrics(context.Background(), []string{"app"}, nil, nil, quietQueryOptions())
+	if err != nil || len(result.Warnings) != 0 {
+		t.Errorf("failover: got %+v, %v; want the clean instance's result", result, err)
+	}
+
+	config.PrometheusInstances = config.PrometheusInstances[:1]
+	if _, err := NewIstioConnector(config).QueryMetrics(context.Background(), []string{"app"}, nil, nil, quietQueryOptions()); !errors.Is(err, ErrWarnings) {
+		t.Errorf("only instance has warnings: got %v, want ErrWarnings", err)
+	}
+}
+
+func TestRangeSeriesWeight(t *testing.T) {
+	end := time.Unix(1700000000, 0)
+	sample := func(age time.Duration, value string) []interface{} {
+		return []interface{}{float64(end.Add(-age).Unix()), value}
+	}
+
+	tests := []struct {
+		name     string
+		values   [][]interface{}
+		halfLife time.Duration
+		want     float64
+	}{
+		{"no samples", nil, 0, 0},
+		{"one sample has no increase", [][]interface{}{sample(0, "1000000")}, 0, 0},
+		{"one usable sample", [][]interface{}{sample(time.Minute, "NaN"), sample(0, "1000000")}, 0, 0},
+		{"increase over the range", [][]interface{}{sample(2*time.Minute, "10"), sample(time.Minute, "15"), sample(0, "22")}, 0, 12},
+		{"counter reset counts the new value", [][]interface{}{sample(2*time.Minute, "10"), sample(time.Minute, "15"), sample(0, "4")}, 0, 9},
+		{"older increases decay", [][]interface{}{sample(2*time.Minute, "0"), sample(time.Minute, "8"), sample(0, "12")}, time.Minute, 8*0.5 + 4},
+		{"one sample stays 0 with decay", [][]interface{}{sample(time.Hour, "1000000")}, time.Minute, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rangeSeriesWeight(tt.values, end, tt.halfLife); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("rangeSeriesWeight() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertRangeRecordsLastSeen(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	sample := func(offset time.Duration, value string) []interface{} {
+		return []interface{}{float64(start.Add(offset).Unix()), value}
+	}
+
+	var rangeResult QueryRangeResult
+	rangeResult.Status = "success"
+	for _, series := range []struct {
+		dest   string
+		values [][]interface{}
+	}{
+		{"active", [][]interface{}{sample(0, "1"), sample(time.Minute, "3"), sample(2*time.Minute, "5")}},
+		{"idle", [][]interface{}{sample(0, "4"), sample(time.Minute, "6"), sample(2*time.Minute, "6")}},
+		{"flat", [][]interface{}{sample(0, "2"), sample(time.Minute, "2")}},
+	} {
+		rangeResult.Data.Result = append(rangeResult.Data.Result, struct {
+			Metric map[string]string `json:"metric"`
+			Values [][]interface{}   `json:"values"`
+		}{
+			Metric: map[string]string{"source_workload": "frontend", "destination_workload": series.dest},
+			Values: series.values,
+		})
+	}
+
+	ic := newTestConnector("http://unused", 0)
+	result := ic.convertRangeToInstantResult(&rangeResult, start.Add(2*time.Minute), quietQueryOptions())
+	lastSeen := ExtractEdgeLastSeen(result, false)
+
+	want := map[string]time.Time{
+		"active": start.Add(2 * time.Minute),
+		"idle":   start.Add(time.Minute),
+		"flat":   start,
+	}
+	for dest, seen := range want {
+		if got := lastSeen["frontend"][dest]; !got.Equal(seen) {
+			t.Errorf("last seen frontend -> %s = %v, want %v", dest, got, seen)
+		}
+	}
+}
+
+func TestQueriesExtraFilters(t *testing.T) {
+	ic := newTestConnector("http://unused", 0)
+	opts := QueryOptions{ExtraFilters: map[string]string{
+		"reporter":                   "destination",
+		"connection_security_policy": `mutual_tls"} or up{x="`,
+	}}
+
+	queries, err := ic.Queries([]string{"app"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `istio_requests_total{source_workload=~"^(?:app)$",connection_security_policy="mutual_tls\"} or up{x=\"",reporter="destination"}`
+	if len(queries) != 1 || queries[0] != want {
+		t.Errorf("queries = %v, want [%s]", queries, want)
+	}
+
+	for _, filters := range []map[string]string{
+		{"source_workload": "other"},
+		{"bad-label": "x"},
+		{"reporter": "\xff"},
+	} {
+		if _, err := ic.Queries([]string{"app"}, QueryOptions{ExtraFilters: filters}); err == nil {
+			t.Errorf("Queries with filters %v: want error", filters)
+		}
+	}
+}
+
+func TestQueriesReporter(t *testing.T) {
+	ic := newTestConnector("http://unused", 0)
+	tests := []struct {
+		opts QueryOptions
+		want string
+	}{
+		{QueryOptions{}, `istio_requests_total{source_workload=~"^(?:app)$",reporter="source"}`},
+		{QueryOptions{Reporter: ReporterDestination}, `istio_requests_total{source_workload=~"^(?:app)$",reporter="destination"}`},
+		{QueryOptions{Reporter: ReporterAll}, `istio_requests_total{source_workload=~"^(?:app)$"}`},
+		// A reporter extra filter, such as a collect request's filter parameter, wins
+		{QueryOptions{Reporter: ReporterSource, ExtraFilters: map[string]string{"reporter": "waypoint"}}, `istio_requests_total{source_workload=~"^(?:app)$",reporter="waypoint"}`},
+	}
+	for _, tt := range tests {
+		queries, err := ic.Queries([]string{"app"}, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(queries) != 1 || queries[0] != tt.want {
+			t.Errorf("Queries with %+v = %v, want [%s]", tt.opts, queries, tt.want)
+		}
+	}
+	if _, err := ic.Queries([]string{"app"}, QueryOptions{Reporter: "sidecar"}); err == nil {
+		t.Error("unknown reporter: want error")
+	}
+}
+
+// TestReporterCountsEdgesOnce checks that with the default reporter filter a
+// request recorded by both sidecars adds to its edge's weight once
+func TestReporterCountsEdgesOnce(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		var result QueryResult
+		result.Status = "success"
+		result.Data.ResultType = "vector"
+		// Istio reports each edge from both ends; honour the reporter matcher like Prometheus would
+		for _, reporter := range []string{ReporterSource, ReporterDestination} {
+			if strings.Contains(query, "reporter=") && !strings.Contains(query, `reporter="`+reporter+`"`) {
+				continue
+			}
+			result.Data.Result = append(result.Data.Result, struct {
+				Metric map[string]string `json:"metric"`
+				Value  []interface{}     `json:"value"`
+			}{
+				Metric: map[string]string{"source_workload": "frontend", "destination_workload": "checkout", "reporter": reporter},
+				Value:  []interface{}{float64(time.Now().Unix()), "100"},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+	ic := newTestConnector(server.URL, 0)
+
+	for _, tt := range []struct {
+		reporter string
+		want     float64
+	}{
+		{"", 100},
+		{ReporterAll, 200},
+	} {
+		result, err := ic.QueryMetrics(context.Background(), []string{"frontend"}, nil, nil, QueryOptions{Logger: quietQueryOptions().Logger, Reporter: tt.reporter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ExtractEdgeWeights(result, false)["frontend"]["checkout"]; got != tt.want {
+			t.Errorf("reporter %q: frontend -> checkout weight = %v, want %v", tt.reporter, got, tt.want)
+		}
+		if adj := ExtractAdjacencyList(result, false); len(adj["frontend"]) != 1 {
+			t.Errorf("reporter %q: frontend edges = %v, want one", tt.reporter, adj["frontend"])
+		}
+	}
+}
+
+func TestQueriesEscapeWorkloadNames(t *testing.T) {
+	ic := newTestConnector("http://unused", 0)
+	workloads := []string{"api.v1", "cart+(beta)", `quote"} or up{x="`, `back\slash`, "a|b"}
+
+	queries, err := ic.Queries(workloads, QueryOptions{Namespaces: []string{"shop.eu"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `istio_requests_total{source_workload=~"^(?:api\\.v1|cart\\+\\(beta\\)|quote\"\\} or up\\{x=\"|back\\\\slash|a\\|b)$",source_workload_namespace=~"^(?:shop\\.eu)$",reporter="source"}`
+	if len(queries) != 1 || queries[0] != want {
+		t.Fatalf("queries = %v, want [%s]", queries, want)
+	}
+
+	// Unquoted as PromQL does, the regex matches exactly the listed names
+	quoted := regexp.MustCompile(`source_workload=~("(?:[^"\\]|\\.)*")`).FindStringSubmatch(queries[0])[1]
+	pattern, err := strconv.Unquote(quoted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(pattern)
+	for _, workload := range workloads {
+		if !re.MatchString(workload) {
+			t.Errorf("regex %s does not match %q", pattern, workload)
+		}
+	}
+	for _, other := range []string{"apixv1", "cartt(beta)", "a", "b", "api.v1-canary"} {
+		if re.MatchString(other) {
+			t.Errorf("regex %s matches unlisted workload %q", pattern, other)
+		}
+	}
+
+	for _, invalid := range [][]string{{""}, {"line\nbreak"}, {"\xff"}} {
+		if _, err := ic.Queries(invalid, QueryOptions{}); err == nil {
+			t.Errorf("Queries(%q): want error", invalid)
+		}
+	}
+	if _, err := ic.Queries([]string{"app"}, QueryOptions{Namespaces: []string{""}}); err == nil {
+		t.Error("empty namespace: want error")
+	}
+}
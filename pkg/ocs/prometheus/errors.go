@@ -0,0 +1,93 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Prometheus API error types that no retry or other instance can fix: the
+// query itself is malformed or cannot be evaluated
+const (
+	ErrorTypeBadData   = "bad_data"
+	ErrorTypeExecution = "execution"
+)
+
+// QueryError is an error reported by the Prometheus HTTP API in its
+// errorType and error fields, such as a PromQL syntax error
+type QueryError struct {
+	StatusCode int    // HTTP status of the response, or 200 for an error in a successful response
+	ErrorType  string // Prometheus errorType, e.g. bad_data, execution, timeout or unavailable
+	Message    string // Prometheus error message
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("PromQL error (%s): %s", e.ErrorType, e.Message)
+}
+
+// Retryable reports whether repeating the query might succeed. Malformed
+// queries and evaluation failures fail the same way every time.
+func (e *QueryError) Retryable() bool {
+	switch e.ErrorType {
+	case ErrorTypeBadData, ErrorTypeExecution:
+		return false
+	}
+	return e.StatusCode >= 500
+}
+
+// ResultTypeError is returned when Prometheus answers a query with a
+// different resultType than the endpoint returns, e.g. a matrix from an
+// instant query of a range selector. Decoding such a result would silently
+// produce no series, which is indistinguishable from no traffic.
+type ResultTypeError struct {
+	Expected string // vector for instant queries, matrix for range queries
+	Got      string // resultType of the response, e.g. matrix, scalar or string
+}
+
+func (e *ResultTypeError) Error() string {
+	return fmt.Sprintf("Prometheus returned resultType %q, expected %q", e.Got, e.Expected)
+}
+
+// decodeResult decodes a query response body into result after checking
+// that a successful response carries the expected resultType. The check
+// comes first since a scalar or string result doesn't decode as series.
+func decodeResult(body []byte, expected string, result interface{}) error {
+	var envelope struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string `json:"resultType"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if envelope.Status == "success" && envelope.Data.ResultType != expected {
+		return &ResultTypeError{Expected: expected, Got: envelope.Data.ResultType}
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// parseQueryError decodes the Prometheus API error in a response body, or
+// returns nil when the body isn't one
+func parseQueryError(statusCode int, body []byte) *QueryError {
+	var apiErr struct {
+		Status    string `json:"status"`
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+	}
+	if json.Unmarshal(body, &apiErr) != nil || apiErr.Status != "error" || apiErr.ErrorType == "" {
+		return nil
+	}
+	return &QueryError{StatusCode: statusCode, ErrorType: apiErr.ErrorType, Message: apiErr.Error}
+}
+
+// statusError returns the error for a non-200 Prometheus response: the
+// decoded QueryError when the body carries one, or the raw status and body
+func statusError(statusCode int, body []byte) error {
+	if queryErr := parseQueryError(statusCode, body); queryErr != nil {
+		return queryErr
+	}
+	return fmt.Errorf("Prometheus returned status %d: %s", statusCode, string(body))
+}
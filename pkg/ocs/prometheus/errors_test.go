@@ -0,0 +1,81 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantType     string // Empty expects an error that isn't a QueryError
+		wantRequests int
+	}{
+		{"syntax error", http.StatusBadRequest, `{"status":"error","errorType":"bad_data","error":"parse error at char 5"}`, ErrorTypeBadData, 1},
+		{"execution error is not retried", http.StatusUnprocessableEntity, `{"status":"error","errorType":"execution","error":"query processing would load too many samples"}`, ErrorTypeExecution, 1},
+		{"unavailable is retried", http.StatusServiceUnavailable, `{"status":"error","errorType":"unavailable","error":"TSDB not ready"}`, "unavailable", 3},
+		{"plain text body", http.StatusBadGateway, "bad gateway", "", 3},
+	}
+	for _, tt := range tests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}))
+		connector := NewIstioConnector(&Config{
+			PrometheusInstances: []Instance{{Name: "test", BaseURL: server.URL}},
+			RetryBaseDelay:      "1ms",
+		})
+
+		_, err := connector.QueryMetrics(context.Background(), []string{"app"}, nil, nil, quietQueryOptions())
+		server.Close()
+
+		var queryErr *QueryError
+		switch {
+		case tt.wantType == "" && errors.As(err, &queryErr):
+			t.Errorf("%s: got QueryError %v, want a plain error", tt.name, err)
+		case tt.wantType != "" && (!errors.As(err, &queryErr) || queryErr.ErrorType != tt.wantType || queryErr.StatusCode != tt.status):
+			t.Errorf("%s: got %v, want a %s QueryError with status %d", tt.name, err, tt.wantType, tt.status)
+		}
+		if requests != tt.wantRequests {
+			t.Errorf("%s: %d requests, want %d", tt.name, requests, tt.wantRequests)
+		}
+	}
+}
+
+func TestResultTypeMismatch(t *testing.T) {
+	from, to := time.Now().Add(-time.Hour), time.Now()
+	tests := []struct {
+		name     string
+		body     string
+		from, to *time.Time
+		want     ResultTypeError
+	}{
+		{"matrix for instant query", `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[0,"1"]]}]}}`, nil, nil, ResultTypeError{Expected: "vector", Got: "matrix"}},
+		{"scalar for instant query", `{"status":"success","data":{"resultType":"scalar","result":[0,"1"]}}`, nil, nil, ResultTypeError{Expected: "vector", Got: "scalar"}},
+		{"vector for range query", `{"status":"success","data":{"resultType":"vector","result":[]}}`, &from, &to, ResultTypeError{Expected: "matrix", Got: "vector"}},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(tt.body))
+		}))
+		connector := NewIstioConnector(&Config{
+			PrometheusInstances: []Instance{{Name: "test", BaseURL: server.URL, Step: "1m"}},
+		})
+
+		_, err := connector.QueryMetrics(context.Background(), []string{"app"}, tt.from, tt.to, quietQueryOptions())
+		server.Close()
+
+		var resultTypeErr *ResultTypeError
+		if !errors.As(err, &resultTypeErr) || *resultTypeErr != tt.want {
+			t.Errorf("%s: got %v, want %+v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package prometheus
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// QueryLogger logs Prometheus queries for a single collection run.
+// When quiet, only the first query of the run is logged at info level and
+// the rest are downgraded to debug.
+type QueryLogger struct {
+	logger  *slog.Logger
+	quiet   bool
+	mu      sync.Mutex // Guards queries; batched collection runs log from several goroutines
+	queries int
+}
+
+// NewQueryLogger creates a query logger for one collection run
+func NewQueryLogger(logger *slog.Logger, quiet bool) *QueryLogger {
+	return &QueryLogger{logger: logger, quiet: quiet}
+}
+
+// Query logs the start of a new query
+func (l *QueryLogger) Query(msg string, args ...any) {
+	if l != nil {
+		l.mu.Lock()
+		l.queries++
+		l.mu.Unlock()
+	}
+	l.Info(msg, args...)
+}
+
+// Info logs an event about the current query, honoring the quiet setting
+func (l *QueryLogger) Info(msg string, args ...any) {
+	level := slog.LevelInfo
+	if l != nil && l.quiet {
+		l.mu.Lock()
+		if l.queries > 1 {
+			level = slog.LevelDebug
+		}
+		l.mu.Unlock()
+	}
+	l.Logger().Log(context.Background(), level, msg, args...)
+}
+
+// Warn logs a failure during the run; warnings are never quieted
+func (l *QueryLogger) Warn(msg string, args ...any) {
+	l.Logger().Warn(msg, args...)
+}
+
+// Logger returns the underlying logger, or the default logger when l is nil
+func (l *QueryLogger) Logger() *slog.Logger {
+	if l == nil || l.logger == nil {
+		return slog.Default()
+	}
+	return l.logger
+}
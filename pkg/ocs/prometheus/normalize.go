@@ -0,0 +1,138 @@
+package prometheus
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RawWorkloadLabel names the label, prefixed with source_ or destination_,
+// under which WorkloadNormalizer.Apply keeps a workload's name as reported by Istio
+const RawWorkloadLabel = "raw_workload"
+
+// NormalizationRule rewrites every match of Pattern in a workload name with
+// Replacement, which may refer to capture groups as $1 or ${name}
+type NormalizationRule struct {
+	Pattern     string `yaml:"pattern" json:"pattern"`
+	Replacement string `yaml:"replacement" json:"replacement"`
+}
+
+// WorkloadNormalizer maps the workload names Istio reports, which may carry
+// deployment hashes or version suffixes, onto canonical service names. A nil
+// *WorkloadNormalizer leaves names unchanged.
+type WorkloadNormalizer struct {
+	patterns     []*regexp.Regexp
+	replacements []string
+	aliases      map[string]string
+}
+
+// NewWorkloadNormalizer compiles normalization rules and a static alias map.
+// It returns nil when there is nothing to normalize.
+func NewWorkloadNormalizer(rules []NormalizationRule, aliases map[string]string) (*WorkloadNormalizer, error) {
+	if len(rules) == 0 && len(aliases) == 0 {
+		return nil, nil
+	}
+
+	n := &WorkloadNormalizer{aliases: aliases}
+	for i, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %d: pattern is required", i)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid pattern: %w", i, err)
+		}
+		n.patterns = append(n.patterns, pattern)
+		n.replacements = append(n.replacements, rule.Replacement)
+	}
+	for raw, canonical := range aliases {
+		if raw == "" || canonical == "" {
+			return nil, fmt.Errorf("alias %q -> %q: names must not be empty", raw, canonical)
+		}
+	}
+	return n, nil
+}
+
+// Normalize returns the canonical name of a workload. An alias for the raw
+// name wins; otherwise the rules are applied in order, and the result is
+// looked up in the aliases once more.
+func (n *WorkloadNormalizer) Normalize(workload string) string {
+	if n == nil || workload == "" {
+		return workload
+	}
+	if canonical, ok := n.aliases[workload]; ok {
+		return canonical
+	}
+
+	name := workload
+	for i, pattern := range n.patterns {
+		name = pattern.ReplaceAllString(name, n.replacements[i])
+	}
+	if canonical, ok := n.aliases[name]; ok {
+		return canonical
+	}
+	if name == "" {
+		// A rule that erases the whole name would merge unrelated workloads
+		return workload
+	}
+	return name
+}
+
+// Apply rewrites the source_workload and destination_workload labels of every
+// series in result to their canonical names, so that the Extract functions
+// collapse edges onto them. Each rewritten name is kept under the
+// source_raw_workload or destination_raw_workload label.
+func (n *WorkloadNormalizer) Apply(result *QueryResult) {
+	if n == nil {
+		return
+	}
+	for _, r := range result.Data.Result {
+		for _, side := range []string{"source_", "destination_"} {
+			raw := r.Metric[side+"workload"]
+			if canonical := n.Normalize(raw); canonical != raw {
+				r.Metric[side+"workload"] = canonical
+				r.Metric[side+RawWorkloadLabel] = raw
+			}
+		}
+	}
+}
+
+// DropWorkloads removes every series whose source or destination workload is
+// one of workloads, such as the "unknown" Istio reports for traffic it can't
+// attribute. It returns the number of distinct edges removed.
+func DropWorkloads(result *QueryResult, workloads []string, qualify bool) int {
+	drop := make(map[string]bool, len(workloads))
+	for _, workload := range workloads {
+		drop[workload] = true
+	}
+
+	dropped := make(map[[2]string]bool)
+	kept := result.Data.Result[:0]
+	for _, r := range result.Data.Result {
+		if drop[r.Metric["source_workload"]] || drop[r.Metric["destination_workload"]] {
+			source, destination := edgeEndpoints(r.Metric, qualify)
+			dropped[[2]string{source, destination}] = true
+			continue
+		}
+		kept = append(kept, r)
+	}
+	result.Data.Result = kept
+	return len(dropped)
+}
+
+// DropSelfEdges removes every series whose source and destination are the
+// same workload, such as sidecar-to-sidecar traffic or retries Istio reports
+// as a workload calling itself. It returns the number of distinct edges removed.
+func DropSelfEdges(result *QueryResult, qualify bool) int {
+	dropped := make(map[string]bool)
+	kept := result.Data.Result[:0]
+	for _, r := range result.Data.Result {
+		source, destination := edgeEndpoints(r.Metric, qualify)
+		if source != "" && source == destination {
+			dropped[source] = true
+			continue
+		}
+		kept = append(kept, r)
+	}
+	result.Data.Result = kept
+	return len(dropped)
+}
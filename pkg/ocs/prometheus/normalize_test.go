@@ -0,0 +1,130 @@
+package prometheus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWorkloadNormalizer(t *testing.T) {
+	normalizer, err := NewWorkloadNormalizer(
+		[]NormalizationRule{
+			{Pattern: `-[0-9a-f]{8,10}$`},
+			{Pattern: `-v[0-9]+$`},
+			{Pattern: `\.svc(\..*)?$`},
+		},
+		map[string]string{"ratings-legacy": "ratings", "reviews": "reviews-service"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for raw, want := range map[string]string{
+		"checkout-5d8f9c7b6d": "checkout",
+		"reviews-v2":          "reviews-service",
+		"details.svc.cluster": "details",
+		"ratings-legacy":      "ratings",
+		"productpage":         "productpage",
+		"-v1":                 "-v1",
+	} {
+		if got := normalizer.Normalize(raw); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", raw, got, want)
+		}
+	}
+
+	var result QueryResult
+	for _, dest := range []string{"reviews-v1", "reviews-v2"} {
+		result.Data.Result = append(result.Data.Result, struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		}{
+			Metric: map[string]string{"source_workload": "productpage", "destination_workload": dest},
+			Value:  []interface{}{float64(0), "1"},
+		})
+	}
+	normalizer.Apply(&result)
+
+	if got, want := ExtractAdjacencyList(&result, false), map[string][]string{"productpage": {"reviews-service"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("adjacency list = %v, want %v", got, want)
+	}
+	labels := ExtractWorkloadLabels(&result, false, []string{RawWorkloadLabel})
+	if got := labels["reviews-service"][RawWorkloadLabel]; got != "reviews-v1,reviews-v2" {
+		t.Errorf("raw workload = %q, want both raw names", got)
+	}
+	if _, ok := labels["productpage"]; ok {
+		t.Errorf("unchanged workload has raw labels: %v", labels["productpage"])
+	}
+}
+
+func TestNewWorkloadNormalizerErrors(t *testing.T) {
+	if n, err := NewWorkloadNormalizer(nil, nil); n != nil || err != nil {
+		t.Errorf("empty config = %v, %v; want nil normalizer", n, err)
+	}
+	if _, err := NewWorkloadNormalizer([]NormalizationRule{{Pattern: "("}}, nil); err == nil {
+		t.Error("invalid pattern: want error")
+	}
+	if _, err := NewWorkloadNormalizer(nil, map[string]string{"reviews-v1": ""}); err == nil {
+		t.Error("empty alias target: want error")
+	}
+}
+
+func TestDropWorkloads(t *testing.T) {
+	var result QueryResult
+	for _, edge := range [][2]string{
+		{"productpage", "reviews"},
+		{"unknown", "reviews"},
+		{"unknown", "reviews"},
+		{"productpage", "PassthroughCluster"},
+	} {
+		result.Data.Result = append(result.Data.Result, struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		}{
+			Metric: map[string]string{"source_workload": edge[0], "destination_workload": edge[1]},
+			Value:  []interface{}{float64(0), "1"},
+		})
+	}
+
+	if dropped := DropWorkloads(&result, []string{"unknown", "PassthroughCluster"}, false); dropped != 2 {
+		t.Errorf("dropped %d edges, want 2", dropped)
+	}
+	if got, want := ExtractAdjacencyList(&result, false), map[string][]string{"productpage": {"reviews"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("adjacency list = %v, want %v", got, want)
+	}
+}
+
+func TestDropSelfEdges(t *testing.T) {
+	var result QueryResult
+	for _, edge := range [][4]string{
+		{"default", "reviews", "default", "reviews"},
+		{"default", "reviews", "default", "reviews"},
+		{"default", "reviews", "staging", "reviews"},
+		{"default", "productpage", "default", "reviews"},
+	} {
+		result.Data.Result = append(result.Data.Result, struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		}{
+			Metric: map[string]string{
+				"source_workload_namespace": edge[0], "source_workload": edge[1],
+				"destination_workload_namespace": edge[2], "destination_workload": edge[3],
+			},
+			Value: []interface{}{float64(0), "1"},
+		})
+	}
+
+	// Qualified, reviews in staging is a different workload from reviews in default
+	if dropped := DropSelfEdges(&result, true); dropped != 1 {
+		t.Errorf("dropped %d edges, want 1", dropped)
+	}
+	want := map[string][]string{"default/productpage": {"default/reviews"}, "default/reviews": {"staging/reviews"}}
+	if got := ExtractAdjacencyList(&result, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("adjacency list = %v, want %v", got, want)
+	}
+
+	if dropped := DropSelfEdges(&result, false); dropped != 1 {
+		t.Errorf("unqualified: dropped %d edges, want 1", dropped)
+	}
+	if got := ExtractAdjacencyList(&result, false); !reflect.DeepEqual(got, map[string][]string{"productpage": {"reviews"}}) {
+		t.Errorf("unqualified adjacency list = %v", got)
+	}
+}
@@ -0,0 +1,31 @@
+package prometheus
+
+// QueryResult represents a Prometheus instant query result
+type QueryResult struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+	Warnings  []string `json:"warnings,omitempty"`  // Problems Prometheus reported alongside the data, such as partial results
+	ErrorType string   `json:"errorType,omitempty"` // Set with Error when the status is "error"
+	Error     string   `json:"error,omitempty"`
+}
+
+// QueryRangeResult represents a Prometheus range query result
+type QueryRangeResult struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][]interface{}   `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+	Warnings  []string `json:"warnings,omitempty"`  // Problems Prometheus reported alongside the data, such as partial results
+	ErrorType string   `json:"errorType,omitempty"` // Set with Error when the status is "error"
+	Error     string   `json:"error,omitempty"`
+}
@@ -0,0 +1,71 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// WorkloadMetricQuery is a PromQL query returning one series per workload
+// for a named metric
+type WorkloadMetricQuery struct {
+	Name          string // Metric name the values are stored under
+	Query         string
+	WorkloadLabel string // Label naming the workload in each series
+	LowIsBad      bool   // Keep the lowest value when several instances report a workload, rather than the highest
+}
+
+// CollectWorkloadMetrics runs each query and returns the current value of
+// its metric per workload. Workloads are keyed like the topology, as
+// namespace/workload when qualify is set. A metric whose query fails is
+// skipped and reported in the returned errors, so health scoring problems
+// never fail a topology collection.
+func (ic *IstioConnector) CollectWorkloadMetrics(ctx context.Context, queries []WorkloadMetricQuery, qualify bool, opts QueryOptions) (topology.WorkloadMetrics, []error) {
+	var values topology.WorkloadMetrics
+	var errs []error
+	for _, metric := range queries {
+		result, err := ic.query(ctx, metric.Query, nil, nil, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("metric %s: %w", metric.Name, err))
+			continue
+		}
+
+		for _, series := range result.Data.Result {
+			workload := series.Metric[metric.WorkloadLabel]
+			if workload == "" || len(series.Value) < 2 {
+				continue
+			}
+			if qualify {
+				workload = topology.QualifyWorkload(series.Metric["namespace"], workload)
+			}
+			valueStr, ok := series.Value[1].(string)
+			if !ok {
+				continue
+			}
+			value, err := strconv.ParseFloat(valueStr, 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+
+			if values == nil {
+				values = make(topology.WorkloadMetrics)
+			}
+			if values[workload] == nil {
+				values[workload] = make(map[string]float64)
+			}
+			// In fanout mode several instances may report a workload; keep the worst value
+			if previous, ok := values[workload][metric.Name]; ok {
+				if metric.LowIsBad {
+					value = math.Min(previous, value)
+				} else {
+					value = math.Max(previous, value)
+				}
+			}
+			values[workload][metric.Name] = value
+		}
+	}
+	return values, errs
+}
@@ -0,0 +1,44 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+func TestCollectWorkloadMetrics(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data": map[string]interface{}{
+				"resultType": "vector",
+				"result": []map[string]interface{}{
+					{"metric": map[string]string{"app": "checkout", "namespace": "shop"}, "value": []interface{}{0, "250"}},
+					{"metric": map[string]string{"app": "cart", "namespace": "shop"}, "value": []interface{}{0, "NaN"}},
+				},
+			},
+		})
+	}))
+	defer server.Close()
+
+	metricQueries := []WorkloadMetricQuery{
+		{Name: "latency_ms", Query: "max by (app, namespace) (latency_ms)", WorkloadLabel: "app"},
+	}
+	values, errs := newTestConnector(server.URL, 0).CollectWorkloadMetrics(context.Background(), metricQueries, true, QueryOptions{})
+	if len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if want := []string{"max by (app, namespace) (latency_ms)"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("queries = %q, want %q", queries, want)
+	}
+	if want := (topology.WorkloadMetrics{"shop/checkout": {"latency_ms": 250}}); !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+}
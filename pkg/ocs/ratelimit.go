@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// rateLimitSweepInterval is how often buckets that have refilled are dropped
+	rateLimitSweepInterval = time.Minute
+	// defaultCollectRateLimit applies to collection unless RATE_LIMIT_COLLECT overrides it
+	defaultCollectRateLimit = "30/m"
+	// defaultBackfillRateLimit applies to starting backfills unless RATE_LIMIT_BACKFILL overrides it
+	defaultBackfillRateLimit = "10/h"
+)
+
+// ratePeriods are the units accepted in a rate limit such as 30/m
+var ratePeriods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// rateLimiter is a per-client token bucket limiter for one endpoint. Each
+// client's bucket holds up to burst tokens and refills at limit per period;
+// a request takes one token. Clients are identified by API key when keys are
+// configured, otherwise by IP address.
+type rateLimiter struct {
+	name   string
+	spec   string // The configured limit, e.g. 30/m
+	limit  int
+	period time.Duration
+	burst  int
+	auth   *apiKeyAuth
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is one client's remaining tokens as of updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// loadRateLimiter reads the rate limit for an endpoint from RATE_LIMIT_<NAME>
+// as requests per period (e.g. 30/m; "off" disables limiting) and the burst
+// from RATE_LIMIT_<NAME>_BURST, defaulting to the per-period limit. It returns
+// nil when limiting is disabled.
+func loadRateLimiter(name, defaultLimit string, auth *apiKeyAuth) (*rateLimiter, error) {
+	envName := "RATE_LIMIT_" + strings.ToUpper(name)
+	spec := envOrDefault(envName, defaultLimit)
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+
+	countStr, unit, ok := strings.Cut(spec, "/")
+	limit, err := strconv.Atoi(countStr)
+	period, known := ratePeriods[unit]
+	if !ok || err != nil || limit <= 0 || !known {
+		return nil, fmt.Errorf("invalid %s %q: use requests per period such as 30/m (s, m or h), or off", envName, spec)
+	}
+
+	burst := limit
+	if value := os.Getenv(envName + "_BURST"); value != "" {
+		burst, err = strconv.Atoi(value)
+		if err != nil || burst <= 0 {
+			return nil, fmt.Errorf("invalid %s_BURST %q: must be a positive integer", envName, value)
+		}
+	}
+
+	return &rateLimiter{
+		name:    name,
+		spec:    spec,
+		limit:   limit,
+		period:  period,
+		burst:   burst,
+		auth:    auth,
+		buckets: make(map[string]*tokenBucket),
+	}, nil
+}
+
+// endpointLimits holds the rate limiters of the endpoints that can be limited;
+// nil limiters allow everything
+type endpointLimits struct {
+	collect  *rateLimiter // RATE_LIMIT_COLLECT, limited by default since every request queries Prometheus
+	reload   *rateLimiter // RATE_LIMIT_RELOAD
+	prune    *rateLimiter // RATE_LIMIT_PRUNE
+	backfill *rateLimiter // RATE_LIMIT_BACKFILL, limited by default since each backfill queries Prometheus once per window
+}
+
+// loadEndpointLimits reads the rate limit of every limitable endpoint
+func loadEndpointLimits(auth *apiKeyAuth) (endpointLimits, error) {
+	var limits endpointLimits
+	var err error
+	if limits.collect, err = loadRateLimiter("collect", defaultCollectRateLimit, auth); err != nil {
+		return limits, err
+	}
+	if limits.reload, err = loadRateLimiter("reload", "", auth); err != nil {
+		return limits, err
+	}
+	if limits.prune, err = loadRateLimiter("prune", "", auth); err != nil {
+		return limits, err
+	}
+	if limits.backfill, err = loadRateLimiter("backfill", defaultBackfillRateLimit, auth); err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+// take removes a token from the client's bucket if one is available. It
+// returns the tokens left and, when refused, how long until a token is available.
+func (rl *rateLimiter) take(client string, now time.Time) (bool, int, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.sweep(now)
+
+	refill := float64(rl.limit) / rl.period.Seconds() // Tokens per second
+	bucket, ok := rl.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(rl.burst), updated: now}
+		rl.buckets[client] = bucket
+	} else {
+		elapsed := now.Sub(bucket.updated).Seconds()
+		bucket.tokens = math.Min(float64(rl.burst), bucket.tokens+elapsed*refill)
+		bucket.updated = now
+	}
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / refill * float64(time.Second))
+		return false, 0, wait
+	}
+	bucket.tokens--
+	return true, int(bucket.tokens), 0
+}
+
+// sweep drops buckets idle long enough to have refilled completely, since
+// they are indistinguishable from new ones. Callers hold rl.mu.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+
+	full := time.Duration(float64(rl.burst) / float64(rl.limit) * float64(rl.period))
+	for client, bucket := range rl.buckets {
+		if now.Sub(bucket.updated) >= full {
+			delete(rl.buckets, client)
+		}
+	}
+}
+
+// clientKey identifies the caller: by API key when keys are configured and
+// requireKey has already validated it, otherwise by IP address. Without
+// configured keys the header is ignored, as callers could vary it freely.
+func (rl *rateLimiter) clientKey(c *gin.Context) string {
+	return rl.clientKeyFor(c.GetHeader(apiKeyHeader), c.ClientIP())
+}
+
+// clientKeyFor identifies a caller from the API key it sent and its IP address, as clientKey does
+func (rl *rateLimiter) clientKeyFor(apiKey, ip string) string {
+	if rl.auth != nil && rl.auth.enabled() && apiKey != "" {
+		hash := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(hash[:8])
+	}
+	return "ip:" + ip
+}
+
+// middleware limits requests to the endpoint, answering 429 with Retry-After
+// when the caller's bucket is empty. Every response carries the limit so
+// clients can pace themselves. A nil limiter allows everything.
+func (rl *rateLimiter) middleware(c *gin.Context) {
+	if rl == nil {
+		return
+	}
+
+	allowed, remaining, wait := rl.take(rl.clientKey(c), time.Now())
+	c.Header("X-RateLimit-Limit", strconv.Itoa(rl.burst))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", rl.limit, int(rl.period.Seconds()), rl.burst))
+	if allowed {
+		return
+	}
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	requestLogger(c).Warn("Rate limit exceeded", "endpoint", rl.name, "client_ip", c.ClientIP(), "retry_after_seconds", retryAfter)
+	respondError(c, http.StatusTooManyRequests, ErrCodeRateLimited, fmt.Sprintf("Rate limit of %s exceeded, retry in %ds", rl.spec, retryAfter))
+	c.Abort()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	t.Setenv("RATE_LIMIT_COLLECT", "60/m")
+	t.Setenv("RATE_LIMIT_COLLECT_BURST", "2")
+	rl, err := loadRateLimiter("collect", defaultCollectRateLimit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i, wantRemaining := range []int{1, 0} {
+		if ok, remaining, _ := rl.take("a", now); !ok || remaining != wantRemaining {
+			t.Fatalf("request %d: allowed=%v remaining=%d, want allowed with %d left", i+1, ok, remaining, wantRemaining)
+		}
+	}
+	ok, _, wait := rl.take("a", now)
+	if ok || wait != time.Second {
+		t.Fatalf("burst exceeded: allowed=%v wait=%v, want refused for 1s", ok, wait)
+	}
+	if ok, _, _ := rl.take("b", now); !ok {
+		t.Error("another client should have its own bucket")
+	}
+	if ok, _, _ := rl.take("a", now.Add(time.Second)); !ok {
+		t.Error("a token should have refilled after 1s")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	t.Setenv("RATE_LIMIT_COLLECT", "1/h")
+	rl, err := loadRateLimiter("collect", defaultCollectRateLimit, &apiKeyAuth{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(requestIDMiddleware)
+	router.POST("/collect_istio_metrics", rl.middleware, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	collect := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/collect_istio_metrics", nil)
+		req.Header.Set(apiKeyHeader, apiKey)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := collect("one"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request: status %d, remaining %q", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+	// Without configured keys the header is ignored, so a new key doesn't reset the limit
+	w := collect("two")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("second request: status %d, Retry-After %q; want 429 after 3600s", w.Code, w.Header().Get("Retry-After"))
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != "1;w=3600;burst=1" {
+		t.Errorf("RateLimit-Policy = %q", got)
+	}
+}
+
+func TestLoadRateLimiterDisabled(t *testing.T) {
+	t.Setenv("RATE_LIMIT_COLLECT", "off")
+	if rl, err := loadRateLimiter("collect", defaultCollectRateLimit, nil); rl != nil || err != nil {
+		t.Errorf("expected limiting disabled, got %v, %v", rl, err)
+	}
+	t.Setenv("RATE_LIMIT_COLLECT", "30 per minute")
+	if _, err := loadRateLimiter("collect", defaultCollectRateLimit, nil); err == nil {
+		t.Error("expected an error for a malformed limit")
+	}
+}
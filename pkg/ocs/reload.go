@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+)
+
+// configReloadDelay coalesces the bursts of events editors produce when saving a file
+const configReloadDelay = 250 * time.Millisecond
+
+// config returns the current OCS config. The returned config must not be
+// modified; reloads replace it rather than updating it in place.
+func (s *Server) config() *OCSConfig {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.ocsConfig
+}
+
+// ReloadConfig re-reads and validates the OCS config file and swaps it in.
+// If the file cannot be loaded or is invalid, the current config is kept.
+func (s *Server) ReloadConfig() (*OCSConfig, error) {
+	config, err := loadOCSConfig(s.ocsConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.configMu.Lock()
+	previous := s.ocsConfig
+	s.ocsConfig = config
+	s.configMu.Unlock()
+
+	setupLogging(config.LogLevel, config.LogFormat)
+	if retention := config.retention(); retention > 0 && retention != previous.retention() {
+		for _, t := range s.allTenants() {
+			if err := t.repo.EnsureRetentionIndex(retention); err != nil {
+				slog.Error("Failed to update retention index after config reload", "database", t.repo.DatabaseName(), "error", err)
+			}
+		}
+	}
+
+	slog.Info("Reloaded OCS config", "workload_count", len(config.Workload), "metric_count", len(config.Metrics))
+	return config, nil
+}
+
+// WatchConfig reloads the OCS config whenever its file changes, until the
+// server begins shutting down. The file's directory is watched rather than
+// the file itself so that editors replacing the file are still noticed.
+func (s *Server) WatchConfig() error {
+	configPath, err := filepath.Abs(s.ocsConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve OCS config path: %w", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", filepath.Dir(configPath), err)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		// Stopped and drained until the first relevant event arrives
+		reload := time.NewTimer(configReloadDelay)
+		if !reload.Stop() {
+			<-reload.C
+		}
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != configPath || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+				reload.Reset(configReloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("OCS config watcher error", "error", err)
+			case <-reload.C:
+				if _, err := s.ReloadConfig(); err != nil {
+					slog.Error("Keeping current OCS config, reload failed", "path", configPath, "error", err)
+				}
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+
+	slog.Info("Watching OCS config for changes", "path", configPath)
+	return nil
+}
+
+// reloadConfigHandler handles the reload endpoint, re-reading the OCS config file on demand
+func (s *Server) reloadConfigHandler(c *gin.Context) {
+	config, err := s.ReloadConfig()
+	if err != nil {
+		requestLogger(c).Error("Keeping current OCS config, reload failed", "error", err)
+		respondConfigError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"message":        "OCS config reloaded",
+		"workload_count": len(config.Workload),
+		"metric_count":   len(config.Metrics),
+	})
+}
+
+// validateConfigHandler handles the config/validate endpoint. It checks the
+// OCS config in the request body with the same rules as at startup, without
+// applying it, and lists each problem with the path of the field at fault.
+func validateConfigHandler(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
+
+	config, err := parseConfigStrict(body)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidConfig, fmt.Sprintf("Failed to parse OCS config: %v", err))
+		return
+	}
+
+	if err := config.Validate(); err != nil {
+		var validationErr *ConfigValidationError
+		if !errors.As(err, &validationErr) {
+			respondConfigError(c, err)
+			return
+		}
+		problems := make([]gin.H, len(validationErr.Problems))
+		for i, problem := range validationErr.Problems {
+			problems[i] = gin.H{"field": validationErr.Fields[i], "message": problem}
+		}
+		respondErrorDetails(c, http.StatusBadRequest, ErrCodeInvalidConfig, "Invalid OCS config", problems)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"message":        "OCS config is valid",
+		"workload_count": len(config.Workload),
+		"metric_count":   len(config.Metrics),
+	})
+}
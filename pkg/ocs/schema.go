@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonSchemaDialect is the JSON Schema draft the prompt schema is written in
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// promptSchemaID identifies the prompt response schema of an OCS spec version
+func promptSchemaID(specVersion string) string {
+	return "urn:contexture:ocs:prompt-response:" + specVersion
+}
+
+// promptSchema returns the JSON Schema of OCSPromptResponse for specVersion.
+// It is generated from the Go types by reflection, so it can't drift from
+// what get_ocs_prompt actually encodes.
+func promptSchema(specVersion string) map[string]interface{} {
+	builder := &jsonSchemaBuilder{defs: make(map[string]interface{})}
+	schema := builder.structSchema(reflect.TypeOf(OCSPromptResponse{}))
+	schema["$schema"] = jsonSchemaDialect
+	schema["$id"] = promptSchemaID(specVersion)
+	schema["title"] = "OCS prompt response"
+	schema["description"] = "Response of GET /get_ocs_prompt for OCS spec version " + specVersion
+	schema["properties"].(map[string]interface{})["spec_version"] = map[string]interface{}{"type": "string", "const": specVersion}
+	schema["$defs"] = builder.defs
+	return schema
+}
+
+// jsonSchemaBuilder derives JSON Schemas from Go types, following the
+// encoding/json rules for field names and omitempty. Nested structs are
+// described once under $defs and referenced by name.
+type jsonSchemaBuilder struct {
+	defs map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the schema of a value of typ, or a $ref for named structs
+func (b *jsonSchemaBuilder) schema(typ reflect.Type) map[string]interface{} {
+	switch typ.Kind() {
+	case reflect.Pointer:
+		return b.schema(typ.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(typ.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(typ.Elem())}
+	case reflect.Struct:
+		if typ == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if _, ok := b.defs[typ.Name()]; !ok {
+			b.defs[typ.Name()] = nil // Reserve the name so recursive types terminate
+			b.defs[typ.Name()] = b.structSchema(typ)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + typ.Name()}
+	default:
+		// interface{} holds any JSON value
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema returns the object schema of a struct's exported fields.
+// Fields without omitempty are always encoded, so they are required.
+func (b *jsonSchemaBuilder) structSchema(typ reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// promptSchemaHandler serves the JSON Schema of the get_ocs_prompt response
+// for the configured spec version
+func (s *Server) promptSchemaHandler(c *gin.Context) {
+	c.Header("Content-Type", "application/schema+json")
+	c.JSON(http.StatusOK, promptSchema(s.config().specVersion()))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPromptSchemaHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{ocsConfig: &OCSConfig{}}
+	router := gin.New()
+	router.GET("/schema/ocs_prompt.json", s.promptSchemaHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/schema/ocs_prompt.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/schema+json" {
+		t.Fatalf("status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var schema struct {
+		ID         string                     `json:"$id"`
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+		Defs       map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.ID != promptSchemaID(defaultSpecVersion) {
+		t.Errorf("$id = %q, want %q", schema.ID, promptSchemaID(defaultSpecVersion))
+	}
+	if want := []string{"spec_version", "context_definitions"}; !reflect.DeepEqual(schema.Required, want) {
+		t.Errorf("required = %v, want %v", schema.Required, want)
+	}
+
+	for name, value := range map[string]interface{}{
+		"OCSContextDefinition": OCSContextDefinition{},
+		"MetricConfig":         MetricConfig{},
+		"WorkloadHealth":       WorkloadHealth{},
+		"MetricHealth":         MetricHealth{},
+	} {
+		var documented []string
+		for property := range schema.Defs[name].Properties {
+			documented = append(documented, property)
+		}
+		sort.Strings(documented)
+		if want := jsonFieldNames(reflect.TypeOf(value)); !reflect.DeepEqual(documented, want) {
+			t.Errorf("%s properties = %v, want %v", name, documented, want)
+		}
+	}
+}
+
+// A prompt built by the server must validate against the schema's shape: every
+// required field present and no field the schema doesn't know about
+func TestPromptSchemaDescribesPrompt(t *testing.T) {
+	config := &OCSConfig{Workload: []string{"frontend"}, Metrics: []MetricConfig{{Name: "latency", Type: "gauge"}}}
+	prompt := buildPrompt(config, nil, 1)
+	encoded, err := json.Marshal(prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := promptSchema(config.specVersion())
+	properties := schema["properties"].(map[string]interface{})
+	for field := range decoded {
+		if _, ok := properties[field]; !ok {
+			t.Errorf("prompt field %q is not in the schema", field)
+		}
+	}
+	for _, field := range schema["required"].([]string) {
+		if _, ok := decoded[field]; !ok {
+			t.Errorf("required field %q missing from prompt %s", field, encoded)
+		}
+	}
+}
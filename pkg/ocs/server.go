@@ -1,27 +1,111 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
 )
 
+// version identifies the server build in the User-Agent of Prometheus
+// requests. Release builds set it with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// defaultShutdownGracePeriod is how long shutdown waits for in-flight requests by default
+const defaultShutdownGracePeriod = 30 * time.Second
+
 func main() {
+	ocsConfigPath := flag.String("ocs-config", envOrDefault("OCS_CONFIG_PATH", defaultOCSConfigPath), "path to the OCS config file (env: OCS_CONFIG_PATH)")
+	promConfigPath := flag.String("prometheus-config", envOrDefault("PROMETHEUS_CONFIG_PATH", defaultPrometheusConfigPath), "path to the Prometheus config file (env: PROMETHEUS_CONFIG_PATH)")
+	pprofDefault, err := pprofEnabledFromEnv()
+	if err != nil {
+		slog.Error("Invalid profiling configuration", "error", err)
+		os.Exit(1)
+	}
+	enablePprof := flag.Bool("enable-pprof", pprofDefault, "serve runtime profiles under /debug/pprof (env: ENABLE_PPROF)")
+	flag.Parse()
+
 	// Initialize server
-	server, err := NewServer()
+	server, err := NewServer(*ocsConfigPath, *promConfigPath)
 	if err != nil {
-		log.Fatalf("Failed to initialize server: %v", err)
+		slog.Error("Failed to initialize server", "error", err)
+		os.Exit(1)
 	}
 	defer server.Close()
 
+	auth, err := loadAPIKeyAuth()
+	if err != nil {
+		server.Close()
+		slog.Error("Invalid API key configuration", "error", err)
+		os.Exit(1)
+	}
+	if !auth.enabled() {
+		slog.Warn("No API keys configured, all endpoints are unauthenticated")
+	}
+
+	for _, tenantID := range auth.keyTenants() {
+		if !server.hasTenant(tenantID) {
+			server.Close()
+			slog.Error("Tenant API key names a tenant missing from OCS_TENANTS", "tenant", tenantID)
+			os.Exit(1)
+		}
+	}
+
+	limits, err := loadEndpointLimits(auth)
+	if err != nil {
+		server.Close()
+		slog.Error("Invalid rate limit configuration", "error", err)
+		os.Exit(1)
+	}
+
+	autoCollectInterval, err := loadAutoCollectInterval()
+	if err != nil {
+		server.Close()
+		slog.Error("Invalid scheduled collection configuration", "error", err)
+		os.Exit(1)
+	}
+	if autoCollectInterval > 0 && server.multiTenant() {
+		server.Close()
+		slog.Error("AUTO_COLLECT_INTERVAL cannot be combined with OCS_TENANTS; schedule collection per tenant instead")
+		os.Exit(1)
+	}
+
+	grpcPort, err := loadGRPCPort()
+	if err != nil {
+		server.Close()
+		slog.Error("Invalid gRPC configuration", "error", err)
+		os.Exit(1)
+	}
+
+	if err := server.WatchConfig(); err != nil {
+		slog.Warn("OCS config changes will not be picked up automatically; use POST /reload", "error", err)
+	}
+
 	// Setup Gin router
 	router := gin.Default()
+	// Client IPs, used for rate limiting, come from X-Forwarded-For only when sent by a trusted proxy
+	if err := router.SetTrustedProxies(splitList(os.Getenv("TRUSTED_PROXIES"))); err != nil {
+		server.Close()
+		slog.Error("Invalid TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
+	router.Use(requestIDMiddleware, server.trackInFlight, gzipMiddleware)
 
-	// Register routes
-	router.GET("/get_ocs_prompt", server.getOCSPromptHandler)
-	router.POST("/collect_istio_metrics", server.collectIstioMetricsHandler)
-	router.GET("/health", server.healthCheckHandler)
+	registerRoutes(router, server, auth, limits)
+	// Profiling is mounted only on request so it can't be exposed by accident
+	if *enablePprof {
+		registerPprofRoutes(router, auth)
+		slog.Warn("Runtime profiling enabled under /debug/pprof")
+	}
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -29,8 +113,150 @@ func main() {
 		port = "8000"
 	}
 
-	log.Printf("Starting OCS server on port %s", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	gracePeriod := defaultShutdownGracePeriod
+	if value := os.Getenv("SHUTDOWN_GRACE_PERIOD"); value != "" {
+		gracePeriod, err = time.ParseDuration(value)
+		if err != nil {
+			slog.Error("Invalid SHUTDOWN_GRACE_PERIOD", "value", value, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		slog.Error("Invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	httpServer := &http.Server{
+		Addr:      ":" + port,
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+
+	if autoCollectInterval > 0 {
+		server.StartAutoCollect(autoCollectInterval)
+	}
+
+	serverErr := make(chan error, 2)
+
+	// The gRPC API shares the server's state, auth and TLS certificate on its own port
+	var grpcServer *grpc.Server
+	if grpcPort != "" {
+		listener, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			server.Close()
+			slog.Error("Failed to listen for gRPC", "port", grpcPort, "error", err)
+			os.Exit(1)
+		}
+		grpcServer = newGRPCServer(server, auth, limits, tlsConfig)
+		go func() {
+			slog.Info("Starting OCS gRPC server", "port", grpcPort, "tls", tlsConfig != nil)
+			serverErr <- grpcServer.Serve(listener)
+		}()
 	}
+
+	go func() {
+		if tlsConfig != nil {
+			slog.Info("Starting OCS server", "port", port, "tls", true)
+			// The certificate comes from TLSConfig.GetCertificate
+			serverErr <- httpServer.ListenAndServeTLS("", "")
+			return
+		}
+		slog.Info("Starting OCS server", "port", port, "tls", false)
+		serverErr <- httpServer.ListenAndServe()
+	}()
+
+	// Wait for a shutdown signal or a server failure
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		server.Close()
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
+	case sig := <-quit:
+		slog.Info("Shutting down", "signal", sig.String(), "grace_period", gracePeriod.String())
+	}
+
+	// Stop accepting new connections and wait for in-flight requests to finish
+	inFlight := server.BeginShutdown()
+	slog.Info("Draining in-flight requests", "in_flight", inFlight)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		go func() {
+			// Cut off RPCs still running when the grace period ends
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				grpcServer.Stop()
+			}
+		}()
+	}
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Warn("Graceful shutdown timed out", "in_flight", server.InFlight(), "error", err)
+	} else {
+		slog.Info("Server stopped", "drained", inFlight)
+	}
+	server.WaitAutoCollect(ctx)
+	server.WaitWebhooks(ctx)
+}
+
+// registerRoutes registers every HTTP endpoint with its authentication, rate
+// limiting and tenant resolution
+func registerRoutes(router *gin.Engine, server *Server, auth *apiKeyAuth, limits endpointLimits) {
+	// Endpoints that query Prometheus or change state always require an API key when keys are configured
+	// Every endpoint touching snapshots acts on the tenant resolved after authentication
+	writes := router.Group("/", auth.requireKey, server.resolveTenant)
+	writes.POST("/collect_istio_metrics", limits.collect.middleware, server.collectIstioMetricsHandler)
+	writes.DELETE("/topologies", limits.prune.middleware, server.pruneTopologiesHandler)
+	writes.POST("/collect/backfill", limits.backfill.middleware, server.startBackfillHandler)
+	writes.DELETE("/collect/backfill/:id", server.cancelBackfillHandler)
+	writes.GET("/workloads/:name/metrics", server.workloadMetricsHandler)
+
+	// Reloading the shared config affects every tenant, so tenant-bound keys can't
+	router.POST("/reload", auth.requireKey, auth.requireUnscopedKey, limits.reload.middleware, server.reloadConfigHandler)
+
+	// Validating a config reads no tenant's data, so it only needs a key where reads do
+	router.POST("/config/validate", auth.requireKeyForReads, validateConfigHandler)
+
+	reads := router.Group("/", auth.requireKeyForReads, server.resolveTenant)
+	reads.GET("/get_ocs_prompt", server.getOCSPromptHandler)
+	reads.POST("/get_ocs_prompt", server.bulkPromptHandler)
+	reads.POST("/preview_prompt", server.previewPromptHandler)
+	reads.GET("/status", server.statusHandler)
+	reads.GET("/collect/auto", server.autoCollectStatusHandler)
+	reads.GET("/collect/backfill/:id", server.getBackfillHandler)
+	reads.GET("/topologies", server.listTopologiesHandler)
+	reads.GET("/topologies/diff", server.diffTopologiesHandler)
+	reads.GET("/topologies/:id", server.getTopologyHandler)
+	reads.GET("/topology/union", server.topologyUnionHandler)
+	reads.GET("/topology/stream", server.topologyStreamHandler)
+	reads.GET("/topology/cycles", server.topologyCyclesHandler)
+	reads.GET("/topology/scc", server.topologySCCHandler)
+	reads.GET("/topology/errors", server.topologyErrorsHandler)
+	reads.GET("/topology/export", server.topologyExportHandler)
+	reads.GET("/topology/neighbors/*workload", server.topologyNeighborsHandler)
+	reads.GET("/topology/path", server.topologyPathHandler)
+	reads.GET("/topology/centrality", server.topologyCentralityHandler)
+
+	// Probes and metrics scraping stay open
+	router.GET("/health", server.healthCheckHandler)
+	router.GET("/ready", server.readinessHandler)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// The API description and prompt schema are public so integrators can read them before holding a key
+	router.GET("/openapi.json", openAPISpecHandler)
+	router.GET("/docs", apiDocsHandler)
+	router.GET("/schema/ocs_prompt.json", server.promptSchemaHandler)
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/store"
+)
+
+// parseStaleAfterParam parses the stale_after query parameter, returning zero when it is absent
+func parseStaleAfterParam(c *gin.Context) (time.Duration, error) {
+	value := c.Query("stale_after")
+	if value == "" {
+		return 0, nil
+	}
+	staleAfter, err := time.ParseDuration(value)
+	if err != nil || staleAfter <= 0 {
+		return 0, fmt.Errorf("invalid stale_after duration. Use a positive Go duration (e.g., 30m)")
+	}
+	return staleAfter, nil
+}
+
+// edgeLastSeen returns when the edge from source to dest was last observed in
+// a snapshot. Snapshots stored before edge timestamps were recorded fall back
+// to the snapshot's own timestamp.
+func edgeLastSeen(snapshot *store.AdjacencyListDocument, source, dest string) time.Time {
+	if seen, ok := snapshot.EdgeLastSeen[source][dest]; ok {
+		return seen
+	}
+	return snapshot.Timestamp
+}
+
+// dropStaleEdges returns a copy of snapshot without the edges last observed
+// before cutoff, leaving snapshot itself untouched
+func dropStaleEdges(snapshot *store.AdjacencyListDocument, cutoff time.Time) *store.AdjacencyListDocument {
+	fresh := func(source, dest string) bool {
+		return !edgeLastSeen(snapshot, source, dest).Before(cutoff)
+	}
+
+	adjacencyList := adjacencyListOf(snapshot)
+
+	filtered := *snapshot
+	filtered.AdjacencyList = make(map[string][]string, len(adjacencyList))
+	filtered.TotalConnections = 0
+	for source, destinations := range adjacencyList {
+		var kept []string
+		for _, dest := range destinations {
+			if fresh(source, dest) {
+				kept = append(kept, dest)
+			}
+		}
+		if len(kept) > 0 {
+			filtered.AdjacencyList[source] = kept
+			filtered.TotalConnections += len(kept)
+		}
+	}
+	filtered.SourceCount = len(filtered.AdjacencyList)
+	filtered.EdgeWeights = filterEdges(snapshot.EdgeWeights, fresh)
+	filtered.EdgeInstances = filterEdges(snapshot.EdgeInstances, fresh)
+	filtered.EdgeErrorRates = filterEdges(snapshot.EdgeErrorRates, fresh)
+	filtered.EdgeLastSeen = filterEdges(snapshot.EdgeLastSeen, fresh)
+	return &filtered
+}
+
+// filterEdges copies a per-edge attribute map, keeping only the edges keep
+// accepts. A nil map stays nil so absent attributes remain absent.
+func filterEdges[M ~map[string]map[string]V, V any](edges M, keep func(source, dest string) bool) M {
+	if edges == nil {
+		return nil
+	}
+	filtered := make(M, len(edges))
+	for source, destinations := range edges {
+		for dest, value := range destinations {
+			if !keep(source, dest) {
+				continue
+			}
+			if filtered[source] == nil {
+				filtered[source] = make(map[string]V)
+			}
+			filtered[source][dest] = value
+		}
+	}
+	return filtered
+}
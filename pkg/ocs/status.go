@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// collectionTracker remembers the outcome of the most recent collection
+// attempt, whether requested or scheduled. Dry runs and backfills are not
+// tracked. The zero value is ready to use.
+type collectionTracker struct {
+	mu            sync.Mutex
+	lastAttemptAt time.Time
+	lastError     string // Empty when the last attempt succeeded
+	lastErrorAt   time.Time
+}
+
+// record stores the outcome of a collection attempt
+func (t *collectionTracker) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastAttemptAt = time.Now()
+	t.lastError = ""
+	if err != nil {
+		t.lastError = err.Error()
+		t.lastErrorAt = t.lastAttemptAt
+	}
+}
+
+// statusHandler handles GET /status, summarizing the last successful
+// collection from the latest snapshot, whether the most recent attempt
+// failed, and how long the server has been up
+func (s *Server) statusHandler(c *gin.Context) {
+	t := tenantOf(c)
+	snapshot, err := t.repo.GetLatestSnapshot()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+		return
+	}
+
+	config := s.config()
+	uptime := time.Since(s.startedAt)
+	response := gin.H{
+		"status":               "ok",
+		"started_at":           s.startedAt.Format(time.RFC3339),
+		"uptime_seconds":       int64(uptime.Seconds()),
+		"auto_collect_enabled": s.autoCollector != nil,
+		"error_rates_enabled":  config.ErrorRates,
+		"error_classification": config.errorClassification(),
+		"timestamp":            time.Now().Format(time.RFC3339),
+	}
+
+	if snapshot != nil {
+		response["last_collection"] = gin.H{
+			"document_id":  snapshot.ID.Hex(),
+			"timestamp":    snapshot.Timestamp.Format(time.RFC3339),
+			"age_seconds":  int64(time.Since(snapshot.Timestamp).Seconds()),
+			"source_count": snapshot.SourceCount,
+			"edge_count":   topology.CountEdges(adjacencyListOf(snapshot)),
+		}
+	}
+
+	last := &t.lastCollection
+	last.mu.Lock()
+	if !last.lastAttemptAt.IsZero() {
+		response["last_attempt_at"] = last.lastAttemptAt.Format(time.RFC3339)
+	}
+	if last.lastError != "" {
+		response["status"] = "degraded"
+		response["last_error"] = last.lastError
+		response["last_error_at"] = last.lastErrorAt.Format(time.RFC3339)
+	}
+	last.mu.Unlock()
+
+	c.JSON(http.StatusOK, response)
+}
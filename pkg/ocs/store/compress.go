@@ -0,0 +1,133 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// mongoMaxDocumentBytes is MongoDB's hard limit on the size of one BSON document
+const mongoMaxDocumentBytes = 16 * 1024 * 1024
+
+// defaultMongoCompressThresholdBytes is the encoded size above which a
+// snapshot's graph is stored gzip-compressed
+const defaultMongoCompressThresholdBytes = 8 * 1024 * 1024
+
+// ErrDocumentTooLarge is returned when a snapshot doesn't fit in one MongoDB
+// document even after compressing its graph
+var ErrDocumentTooLarge = errors.New("topology snapshot too large to store")
+
+// storedGraph holds the graph fields of a snapshot as they are encoded into
+// compressed_graph
+type storedGraph struct {
+	AdjacencyList   map[string][]string            `bson:"adjacency_list"`
+	EdgeWeights     topology.EdgeWeights           `bson:"edge_weights,omitempty"`
+	EdgeInstances   map[string]map[string][]string `bson:"edge_instances,omitempty"`
+	EdgeErrorRates  topology.EdgeErrorRates        `bson:"edge_error_rates,omitempty"`
+	EdgeLastSeen    topology.EdgeLastSeen          `bson:"edge_last_seen,omitempty"`
+	WorkloadLabels  topology.WorkloadLabels        `bson:"workload_labels,omitempty"`
+	WorkloadMetrics topology.WorkloadMetrics       `bson:"workload_metrics,omitempty"`
+}
+
+// fitDocument keeps doc within the repository's size limits. Documents
+// larger than the compression threshold have their graph moved into the
+// gzip-compressed compressed_graph field; documents still larger than the
+// maximum fail with ErrDocumentTooLarge rather than MongoDB's own error.
+func (r *MongoDBRepository) fitDocument(doc *AdjacencyListDocument) error {
+	encoded, err := bson.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	size := len(encoded)
+	if r.compressThresholdBytes > 0 && size > r.compressThresholdBytes {
+		if err := compressGraph(doc); err != nil {
+			return err
+		}
+		if encoded, err = bson.Marshal(doc); err != nil {
+			return fmt.Errorf("failed to encode document: %w", err)
+		}
+		size = len(encoded)
+	}
+
+	limit := r.maxDocumentBytes
+	if limit <= 0 {
+		limit = mongoMaxDocumentBytes
+	}
+	if size > limit {
+		return fmt.Errorf("%w: %d workloads and %d connections encode to %d bytes, over the %d byte limit; "+
+			"narrow collection with namespaces, extra_filters or exclude_unknown, or shorten time_window_minutes",
+			ErrDocumentTooLarge, doc.SourceCount, doc.TotalConnections, size, limit)
+	}
+	return nil
+}
+
+// compressGraph moves the graph fields of doc into compressed_graph
+func compressGraph(doc *AdjacencyListDocument) error {
+	encoded, err := bson.Marshal(storedGraph{
+		AdjacencyList:   doc.AdjacencyList,
+		EdgeWeights:     doc.EdgeWeights,
+		EdgeInstances:   doc.EdgeInstances,
+		EdgeErrorRates:  doc.EdgeErrorRates,
+		EdgeLastSeen:    doc.EdgeLastSeen,
+		WorkloadLabels:  doc.WorkloadLabels,
+		WorkloadMetrics: doc.WorkloadMetrics,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode graph: %w", err)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(encoded); err != nil {
+		return fmt.Errorf("failed to compress graph: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress graph: %w", err)
+	}
+
+	doc.CompressedGraph = buf.Bytes()
+	doc.AdjacencyList = nil
+	doc.EdgeWeights = nil
+	doc.EdgeInstances = nil
+	doc.EdgeErrorRates = nil
+	doc.EdgeLastSeen = nil
+	doc.WorkloadLabels = nil
+	doc.WorkloadMetrics = nil
+	return nil
+}
+
+// decompressGraph restores the graph fields of a document stored with
+// compressed_graph
+func decompressGraph(doc *AdjacencyListDocument) error {
+	if len(doc.CompressedGraph) == 0 {
+		return nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(doc.CompressedGraph))
+	if err != nil {
+		return fmt.Errorf("failed to decompress graph of document %s: %w", doc.ID.Hex(), err)
+	}
+	encoded, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("failed to decompress graph of document %s: %w", doc.ID.Hex(), err)
+	}
+
+	var graph storedGraph
+	if err := bson.Unmarshal(encoded, &graph); err != nil {
+		return fmt.Errorf("failed to decode graph of document %s: %w", doc.ID.Hex(), err)
+	}
+	doc.AdjacencyList = graph.AdjacencyList
+	doc.EdgeWeights = graph.EdgeWeights
+	doc.EdgeInstances = graph.EdgeInstances
+	doc.EdgeErrorRates = graph.EdgeErrorRates
+	doc.EdgeLastSeen = graph.EdgeLastSeen
+	doc.WorkloadLabels = graph.WorkloadLabels
+	doc.WorkloadMetrics = graph.WorkloadMetrics
+	doc.CompressedGraph = nil
+	return nil
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// defaultMemoryDatabaseName names the snapshots of an InMemoryRepository
+// created by NewInMemoryRepository
+const defaultMemoryDatabaseName = "memory"
+
+// InMemoryRepository keeps topology snapshots in process memory. It offers
+// the same operations as MongoDBRepository for demos and tests that don't
+// need persistence; snapshots are lost when the process exits.
+type InMemoryRepository struct {
+	name string
+
+	mu        sync.Mutex
+	docs      []AdjacencyListDocument // Sorted oldest-first by timestamp
+	retention time.Duration           // Snapshots older than this are dropped; zero keeps them forever
+}
+
+// NewInMemoryRepository creates an empty in-memory repository
+func NewInMemoryRepository() *InMemoryRepository {
+	return &InMemoryRepository{name: defaultMemoryDatabaseName}
+}
+
+// ForDatabase returns a separate, empty in-memory repository named dbName
+func (r *InMemoryRepository) ForDatabase(dbName string) (*InMemoryRepository, error) {
+	return &InMemoryRepository{name: dbName}, nil
+}
+
+// DatabaseName returns the name the repository was created with
+func (r *InMemoryRepository) DatabaseName() string {
+	return r.name
+}
+
+// Ping always succeeds
+func (r *InMemoryRepository) Ping() error {
+	return nil
+}
+
+// Close is a no-op; there is no connection to release
+func (r *InMemoryRepository) Close() error {
+	return nil
+}
+
+// EnsureRetentionIndex drops snapshots older than retention, now and
+// whenever the repository is next used, as MongoDB's TTL index would
+func (r *InMemoryRepository) EnsureRetentionIndex(retention time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retention = retention
+	r.expire()
+	return nil
+}
+
+// expire drops snapshots older than the retention. r.mu must be held.
+func (r *InMemoryRepository) expire() {
+	if r.retention <= 0 {
+		return
+	}
+	r.pruneBefore(time.Now().Add(-r.retention))
+}
+
+// pruneBefore drops snapshots with a timestamp before t and returns how many
+// were dropped. r.mu must be held.
+func (r *InMemoryRepository) pruneBefore(t time.Time) int {
+	n := sort.Search(len(r.docs), func(i int) bool { return !r.docs[i].Timestamp.Before(t) })
+	r.docs = append([]AdjacencyListDocument(nil), r.docs[n:]...)
+	return n
+}
+
+// PruneOlderThan deletes snapshots with a timestamp before t and returns the
+// number of snapshots deleted
+func (r *InMemoryRepository) PruneOlderThan(t time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(r.pruneBefore(t)), nil
+}
+
+// GetLatestAdjacencyList returns the most recent adjacency list, or nil when none has been saved
+func (r *InMemoryRepository) GetLatestAdjacencyList() (map[string][]string, error) {
+	doc, err := r.GetLatestSnapshot()
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	return doc.AdjacencyList, nil
+}
+
+// GetLatestSnapshot returns the most recent snapshot, or nil when none has been saved
+func (r *InMemoryRepository) GetLatestSnapshot() (*AdjacencyListDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+
+	if len(r.docs) == 0 {
+		return nil, nil
+	}
+	doc := r.docs[len(r.docs)-1]
+	return &doc, nil
+}
+
+// GetAdjacencyListByID returns the snapshot with the given hex ObjectID
+func (r *InMemoryRepository) GetAdjacencyListByID(id string) (*AdjacencyListDocument, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshotID, id)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+
+	for _, doc := range r.docs {
+		if doc.ID == objectID {
+			return &doc, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+}
+
+// ListAdjacencyLists returns snapshots sorted newest-first, without their
+// adjacency data, along with the total number of stored snapshots
+func (r *InMemoryRepository) ListAdjacencyLists(limit, offset int) ([]AdjacencyListDocument, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+
+	docs := make([]AdjacencyListDocument, 0)
+	for i := len(r.docs) - 1 - offset; i >= 0 && len(docs) < limit; i-- {
+		doc := r.docs[i]
+		docs = append(docs, AdjacencyListDocument{
+			ID:               doc.ID,
+			SchemaVersion:    doc.SchemaVersion,
+			Timestamp:        doc.Timestamp,
+			SourceCount:      doc.SourceCount,
+			TotalConnections: doc.TotalConnections,
+		})
+	}
+	return docs, int64(len(r.docs)), nil
+}
+
+// ListSnapshotsBetween returns every snapshot taken between from and to
+// inclusive, oldest-first, returning at most limit snapshots
+func (r *InMemoryRepository) ListSnapshotsBetween(from, to time.Time, limit int) ([]AdjacencyListDocument, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+
+	docs := make([]AdjacencyListDocument, 0)
+	for _, doc := range r.docs {
+		if len(docs) >= limit || doc.Timestamp.After(to) {
+			break
+		}
+		if !doc.Timestamp.Before(from) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// SaveAdjacencyList saves a collected topology as a snapshot taken now
+func (r *InMemoryRepository) SaveAdjacencyList(graph topology.Graph) (primitive.ObjectID, error) {
+	return r.SaveAdjacencyListAt(time.Now(), graph)
+}
+
+// SaveAdjacencyListAt saves a snapshot timestamped at timestamp rather than
+// now, keeping snapshots ordered by timestamp
+func (r *InMemoryRepository) SaveAdjacencyListAt(timestamp time.Time, graph topology.Graph) (primitive.ObjectID, error) {
+	doc := newDocument(timestamp, graph)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.insert(doc)
+	return doc.ID, nil
+}
+
+// SaveAdjacencyListInBucket saves a snapshot taken now, merging it into the
+// snapshot already saved in the same time bucket rather than inserting
+// another, as MongoDBRepository does
+func (r *InMemoryRepository) SaveAdjacencyListInBucket(bucket time.Duration, graph topology.Graph) (primitive.ObjectID, error) {
+	now := time.Now()
+	bucketStart := BucketStart(now, bucket)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var existing *AdjacencyListDocument
+	i := slices.IndexFunc(r.docs, func(doc AdjacencyListDocument) bool {
+		return doc.Bucket != nil && doc.Bucket.Equal(bucketStart)
+	})
+	if i >= 0 {
+		existing = &r.docs[i]
+	}
+	doc := newBucketDocument(existing, bucketStart, now, graph)
+	if i >= 0 {
+		// The merged snapshot is newer, so it moves rather than being replaced in place
+		r.docs = slices.Delete(r.docs, i, i+1)
+	}
+	r.insert(doc)
+	return doc.ID, nil
+}
+
+// insert adds doc in timestamp order and drops expired snapshots. The caller holds r.mu.
+func (r *InMemoryRepository) insert(doc AdjacencyListDocument) {
+	i := sort.Search(len(r.docs), func(i int) bool { return r.docs[i].Timestamp.After(doc.Timestamp) })
+	r.docs = slices.Insert(r.docs, i, doc)
+	r.expire()
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+func TestInMemoryRepository(t *testing.T) {
+	repo := NewInMemoryRepository()
+	if doc, err := repo.GetLatestSnapshot(); doc != nil || err != nil {
+		t.Fatalf("empty repository: got %v, %v; want no snapshot", doc, err)
+	}
+
+	now := time.Now()
+	graph := func(dest string) topology.Graph {
+		return topology.Graph{AdjacencyList: map[string][]string{"app": {dest}}}
+	}
+	newest, _ := repo.SaveAdjacencyListAt(now, graph("db"))
+	oldest, _ := repo.SaveAdjacencyListAt(now.Add(-2*time.Hour), graph("cache"))
+	middle, _ := repo.SaveAdjacencyListAt(now.Add(-time.Hour), graph("queue"))
+
+	latest, err := repo.GetLatestAdjacencyList()
+	if err != nil || latest["app"][0] != "db" {
+		t.Errorf("latest = %v, %v; want the newest snapshot even though it was saved first", latest, err)
+	}
+
+	docs, total, _ := repo.ListAdjacencyLists(2, 1)
+	if total != 3 || len(docs) != 2 || docs[0].ID != middle || docs[1].ID != oldest {
+		t.Errorf("list = %+v (total %d), want the middle and oldest snapshots of 3", docs, total)
+	}
+	if docs[0].AdjacencyList != nil || docs[0].SourceCount != 1 {
+		t.Errorf("listed snapshot = %+v, want summary fields only", docs[0])
+	}
+
+	ranged, _ := repo.ListSnapshotsBetween(now.Add(-time.Hour), now, 10)
+	if len(ranged) != 2 || ranged[0].ID != middle || ranged[1].ID != newest {
+		t.Errorf("snapshots in the last hour = %+v, want the middle and newest oldest-first", ranged)
+	}
+
+	if doc, err := repo.GetAdjacencyListByID(newest.Hex()); err != nil || doc.SchemaVersion != currentSchemaVersion {
+		t.Errorf("get by ID = %+v, %v", doc, err)
+	}
+	if _, err := repo.GetAdjacencyListByID("nope"); !errors.Is(err, ErrInvalidSnapshotID) {
+		t.Errorf("invalid ID: got %v", err)
+	}
+
+	if deleted, _ := repo.PruneOlderThan(now.Add(-90 * time.Minute)); deleted != 1 {
+		t.Errorf("pruned %d snapshots, want 1", deleted)
+	}
+	if _, err := repo.GetAdjacencyListByID(oldest.Hex()); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("pruned snapshot: got %v, want not found", err)
+	}
+
+	repo.EnsureRetentionIndex(30 * time.Minute)
+	if _, total, _ := repo.ListAdjacencyLists(10, 0); total != 1 {
+		t.Errorf("%d snapshots after retention, want only the newest", total)
+	}
+}
+
+func TestInMemoryRepositorySaveInBucket(t *testing.T) {
+	// A bucket this long can't be crossed between the two saves
+	const century = 100 * 365 * 24 * time.Hour
+
+	repo := NewInMemoryRepository()
+	first, err := repo.SaveAdjacencyListInBucket(century, topology.Graph{
+		AdjacencyList: map[string][]string{"app": {"db"}},
+		EdgeWeights:   topology.EdgeWeights{"app": {"db": 10}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.SaveAdjacencyListInBucket(century, topology.Graph{
+		AdjacencyList: map[string][]string{"app": {"cache", "db"}},
+		EdgeWeights:   topology.EdgeWeights{"app": {"cache": 3, "db": 12}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The merged snapshot gets a new ID so watchers of the latest snapshot see it change
+	if second == first {
+		t.Fatalf("merged save kept ID %s, want a new one", first.Hex())
+	}
+
+	if _, total, _ := repo.ListAdjacencyLists(10, 0); total != 1 {
+		t.Fatalf("stored %d snapshots, want 1", total)
+	}
+	doc, _ := repo.GetLatestSnapshot()
+	if doc.ID != second {
+		t.Errorf("latest snapshot = %s, want the merged %s", doc.ID.Hex(), second.Hex())
+	}
+	if got := doc.AdjacencyList["app"]; len(got) != 2 || got[0] != "cache" || got[1] != "db" {
+		t.Errorf("adjacency list = %v, want app -> cache, db", doc.AdjacencyList)
+	}
+	if doc.EdgeWeights["app"]["db"] != 12 || doc.TotalConnections != 2 {
+		t.Errorf("weights = %v, total = %d; want the newer db weight and 2 edges", doc.EdgeWeights, doc.TotalConnections)
+	}
+	if doc.Bucket == nil || !doc.Bucket.Equal(time.Unix(0, 0)) {
+		t.Errorf("bucket = %v, want the Unix epoch", doc.Bucket)
+	}
+
+	// Individually saved snapshots are never merged into
+	repo.SaveAdjacencyList(topology.Graph{AdjacencyList: map[string][]string{"app": {"queue"}}})
+	if _, total, _ := repo.ListAdjacencyLists(10, 0); total != 2 {
+		t.Errorf("stored %d snapshots after an unbucketed save, want 2", total)
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	at := time.Date(2024, 3, 5, 10, 42, 17, 0, time.UTC)
+	for bucket, want := range map[time.Duration]time.Time{
+		time.Minute:        time.Date(2024, 3, 5, 10, 42, 0, 0, time.UTC),
+		15 * time.Minute:   time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC),
+		7 * 24 * time.Hour: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), // Weeks start on Thursday, like the epoch
+	} {
+		if got := BucketStart(at, bucket); !got.Equal(want) {
+			t.Errorf("BucketStart(%v) = %v, want %v", bucket, got, want)
+		}
+	}
+}
@@ -0,0 +1,789 @@
+// Package store persists topology snapshots in MongoDB.
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	drivertopology "go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+
+	"github.com/contexture/ocs/pkg/ocs/internal/backoff"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+var (
+	// ErrSnapshotNotFound is returned when no adjacency list document matches the requested ID
+	ErrSnapshotNotFound = errors.New("topology snapshot not found")
+	// ErrInvalidSnapshotID is returned when a snapshot ID is not a valid ObjectID hex string
+	ErrInvalidSnapshotID = errors.New("invalid topology snapshot ID")
+	// ErrUnsupportedSchemaVersion is returned for documents written in a newer storage format
+	ErrUnsupportedSchemaVersion = errors.New("unsupported topology schema version")
+)
+
+// Adjacency list document schema versions. Bump currentSchemaVersion and
+// extend migrateDocument whenever the stored representation changes.
+const (
+	// schemaVersionAdjacencyOnly documents hold only adjacency_list; documents without schema_version are this version
+	schemaVersionAdjacencyOnly = 1
+	// schemaVersionEdgeAttributes documents add the optional edge_weights, edge_instances and edge_error_rates
+	schemaVersionEdgeAttributes = 2
+	// schemaVersionWorkloadLabels documents add the optional workload_labels
+	schemaVersionWorkloadLabels = 3
+	// schemaVersionWorkloadMetrics documents add the optional workload_metrics
+	schemaVersionWorkloadMetrics = 4
+	// schemaVersionEdgeLastSeen documents add the optional edge_last_seen
+	schemaVersionEdgeLastSeen = 5
+	// schemaVersionBucket documents add the optional bucket
+	schemaVersionBucket = 6
+	// schemaVersionCompressedGraph documents may store their graph gzip-compressed in compressed_graph instead
+	schemaVersionCompressedGraph = 7
+
+	currentSchemaVersion = schemaVersionCompressedGraph
+)
+
+// AdjacencyListDocument represents the MongoDB document structure
+type AdjacencyListDocument struct {
+	ID               primitive.ObjectID             `bson:"_id,omitempty" json:"document_id"`
+	SchemaVersion    int                            `bson:"schema_version,omitempty" json:"schema_version"`
+	AdjacencyList    map[string][]string            `bson:"adjacency_list" json:"adjacency_list"`
+	EdgeWeights      topology.EdgeWeights           `bson:"edge_weights,omitempty" json:"edge_weights,omitempty"`
+	EdgeInstances    map[string]map[string][]string `bson:"edge_instances,omitempty" json:"edge_instances,omitempty"`
+	EdgeErrorRates   topology.EdgeErrorRates        `bson:"edge_error_rates,omitempty" json:"edge_error_rates,omitempty"`
+	EdgeLastSeen     topology.EdgeLastSeen          `bson:"edge_last_seen,omitempty" json:"edge_last_seen,omitempty"`
+	WorkloadLabels   topology.WorkloadLabels        `bson:"workload_labels,omitempty" json:"workload_labels,omitempty"`
+	WorkloadMetrics  topology.WorkloadMetrics       `bson:"workload_metrics,omitempty" json:"workload_metrics,omitempty"`
+	CompressedGraph  []byte                         `bson:"compressed_graph,omitempty" json:"-"` // Gzip-compressed BSON of the graph fields above for documents too large to store plainly; emptied once read
+	Timestamp        time.Time                      `bson:"timestamp" json:"timestamp"`
+	Bucket           *time.Time                     `bson:"bucket,omitempty" json:"bucket,omitempty"` // Start of the time bucket collections were merged into; nil for snapshots saved individually
+	SourceCount      int                            `bson:"source_count" json:"source_count"`
+	TotalConnections int                            `bson:"total_connections" json:"total_connections"`
+}
+
+// migrateDocument interprets a stored document according to its schema version,
+// treating documents without one as version 1. It fails for versions newer than
+// this server understands rather than misreading them.
+func migrateDocument(doc *AdjacencyListDocument) error {
+	if doc.SchemaVersion == 0 {
+		doc.SchemaVersion = schemaVersionAdjacencyOnly
+	}
+	if doc.SchemaVersion > currentSchemaVersion {
+		return fmt.Errorf("%w: document %s has version %d, newest supported is %d", ErrUnsupportedSchemaVersion, doc.ID.Hex(), doc.SchemaVersion, currentSchemaVersion)
+	}
+	return decompressGraph(doc)
+}
+
+// newDocument builds the snapshot document for a topology collected at timestamp
+func newDocument(timestamp time.Time, graph topology.Graph) AdjacencyListDocument {
+	totalConnections := 0
+	for _, dests := range graph.AdjacencyList {
+		totalConnections += len(dests)
+	}
+
+	return AdjacencyListDocument{
+		ID:               primitive.NewObjectID(),
+		SchemaVersion:    currentSchemaVersion,
+		AdjacencyList:    graph.AdjacencyList,
+		EdgeWeights:      graph.EdgeWeights,
+		EdgeInstances:    graph.EdgeInstances,
+		EdgeErrorRates:   graph.EdgeErrorRates,
+		EdgeLastSeen:     graph.EdgeLastSeen,
+		WorkloadLabels:   graph.WorkloadLabels,
+		WorkloadMetrics:  graph.WorkloadMetrics,
+		Timestamp:        timestamp,
+		SourceCount:      len(graph.AdjacencyList),
+		TotalConnections: totalConnections,
+	}
+}
+
+// graph returns the topology stored in doc, deriving the adjacency list from
+// the edge weights for documents stored without one
+func (doc *AdjacencyListDocument) graph() topology.Graph {
+	adjacencyList := doc.AdjacencyList
+	if adjacencyList == nil && doc.EdgeWeights != nil {
+		adjacencyList = doc.EdgeWeights.AdjacencyList()
+	}
+	return topology.Graph{
+		AdjacencyList:   adjacencyList,
+		EdgeWeights:     doc.EdgeWeights,
+		EdgeInstances:   doc.EdgeInstances,
+		EdgeErrorRates:  doc.EdgeErrorRates,
+		EdgeLastSeen:    doc.EdgeLastSeen,
+		WorkloadLabels:  doc.WorkloadLabels,
+		WorkloadMetrics: doc.WorkloadMetrics,
+	}
+}
+
+// BucketStart returns the start of the time bucket holding t, for buckets of
+// the given length aligned to the Unix epoch
+func BucketStart(t time.Time, bucket time.Duration) time.Time {
+	nanos := t.UnixNano()
+	return time.Unix(0, nanos-nanos%int64(bucket)).UTC()
+}
+
+// newBucketDocument builds the snapshot document for a topology collected at
+// timestamp in the bucket starting at bucketStart, merged into existing when
+// the bucket already holds a snapshot
+func newBucketDocument(existing *AdjacencyListDocument, bucketStart, timestamp time.Time, graph topology.Graph) AdjacencyListDocument {
+	if existing != nil {
+		graph = topology.Merge(existing.graph(), graph)
+	}
+	doc := newDocument(timestamp, graph)
+	doc.Bucket = &bucketStart
+	return doc
+}
+
+// retentionIndexName is the name of the TTL index expiring old snapshots
+const retentionIndexName = "timestamp_ttl"
+
+// latestIndexName is the name of the index serving newest-first snapshot lookups
+const latestIndexName = "timestamp_desc"
+
+// bucketIndexName is the name of the unique index keeping one snapshot per time bucket
+const bucketIndexName = "bucket_unique"
+
+// bucketSaveMaxAttempts bounds how often a bucketed save is retried when
+// another collection writes the same bucket concurrently
+const bucketSaveMaxAttempts = 5
+
+// errBucketConflict reports that a bucket changed between reading and writing it
+var errBucketConflict = errors.New("time bucket was written concurrently")
+
+// Defaults for the MongoDB client and write retries, overridable through the
+// MONGODB_* environment variables
+const (
+	defaultMongoMaxPoolSize            = 100
+	defaultMongoRetryWrites            = true
+	defaultMongoServerSelectionTimeout = 10 * time.Second
+	defaultMongoWriteMaxAttempts       = 3
+	defaultMongoWriteRetryDelay        = 200 * time.Millisecond
+)
+
+// MongoDBRepository handles all MongoDB operations
+type MongoDBRepository struct {
+	client     *mongo.Client
+	database   *mongo.Database
+	collection *mongo.Collection
+
+	writeMaxAttempts int           // Attempts per snapshot write, including the first
+	writeRetryDelay  time.Duration // Base delay before the first write retry, doubled on each further retry
+
+	compressThresholdBytes int // Encoded size above which a snapshot's graph is compressed; 0 never compresses
+	maxDocumentBytes       int // Largest snapshot document written; 0 means MongoDB's 16MiB limit
+
+	// OnWriteError, when set, is called for every failed snapshot write or
+	// prune. Repositories returned by ForDatabase share it.
+	OnWriteError func(err error)
+}
+
+// mongoDBOptions holds the client and write retry settings read from the environment
+type mongoDBOptions struct {
+	maxPoolSize            uint64
+	retryWrites            bool
+	serverSelectionTimeout time.Duration
+	writeMaxAttempts       int
+	writeRetryDelay        time.Duration
+	compressThresholdBytes int
+	maxDocumentBytes       int
+	tlsConfig              *tls.Config // Nil unless a MONGODB_TLS* variable is set
+}
+
+// loadMongoDBOptions reads the MONGODB_* client settings, falling back to the
+// defaults for unset variables
+func loadMongoDBOptions() (mongoDBOptions, error) {
+	opts := mongoDBOptions{
+		maxPoolSize:            defaultMongoMaxPoolSize,
+		retryWrites:            defaultMongoRetryWrites,
+		serverSelectionTimeout: defaultMongoServerSelectionTimeout,
+		writeMaxAttempts:       defaultMongoWriteMaxAttempts,
+		writeRetryDelay:        defaultMongoWriteRetryDelay,
+		compressThresholdBytes: defaultMongoCompressThresholdBytes,
+		maxDocumentBytes:       mongoMaxDocumentBytes,
+	}
+
+	var err error
+	if value := os.Getenv("MONGODB_MAX_POOL_SIZE"); value != "" {
+		if opts.maxPoolSize, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return opts, fmt.Errorf("invalid MONGODB_MAX_POOL_SIZE %q: %w", value, err)
+		}
+	}
+	if value := os.Getenv("MONGODB_RETRY_WRITES"); value != "" {
+		if opts.retryWrites, err = strconv.ParseBool(value); err != nil {
+			return opts, fmt.Errorf("invalid MONGODB_RETRY_WRITES %q: %w", value, err)
+		}
+	}
+	if value := os.Getenv("MONGODB_SERVER_SELECTION_TIMEOUT"); value != "" {
+		if opts.serverSelectionTimeout, err = time.ParseDuration(value); err != nil || opts.serverSelectionTimeout <= 0 {
+			return opts, fmt.Errorf("invalid MONGODB_SERVER_SELECTION_TIMEOUT %q: must be a positive duration", value)
+		}
+	}
+	if value := os.Getenv("MONGODB_WRITE_MAX_ATTEMPTS"); value != "" {
+		if opts.writeMaxAttempts, err = strconv.Atoi(value); err != nil || opts.writeMaxAttempts < 1 {
+			return opts, fmt.Errorf("invalid MONGODB_WRITE_MAX_ATTEMPTS %q: must be at least 1", value)
+		}
+	}
+	if value := os.Getenv("MONGODB_WRITE_RETRY_DELAY"); value != "" {
+		if opts.writeRetryDelay, err = time.ParseDuration(value); err != nil || opts.writeRetryDelay < 0 {
+			return opts, fmt.Errorf("invalid MONGODB_WRITE_RETRY_DELAY %q: must be a non-negative duration", value)
+		}
+	}
+	if value := os.Getenv("MONGODB_COMPRESS_THRESHOLD_BYTES"); value != "" {
+		if opts.compressThresholdBytes, err = strconv.Atoi(value); err != nil || opts.compressThresholdBytes < 0 {
+			return opts, fmt.Errorf("invalid MONGODB_COMPRESS_THRESHOLD_BYTES %q: must be a non-negative number of bytes", value)
+		}
+	}
+	if value := os.Getenv("MONGODB_MAX_DOCUMENT_BYTES"); value != "" {
+		if opts.maxDocumentBytes, err = strconv.Atoi(value); err != nil || opts.maxDocumentBytes < 1 || opts.maxDocumentBytes > mongoMaxDocumentBytes {
+			return opts, fmt.Errorf("invalid MONGODB_MAX_DOCUMENT_BYTES %q: must be between 1 and %d", value, mongoMaxDocumentBytes)
+		}
+	}
+	if opts.tlsConfig, err = loadMongoTLSConfig(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// NewMongoDBRepository creates a new MongoDB repository
+func NewMongoDBRepository() (*MongoDBRepository, error) {
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017/"
+	}
+
+	dbName := os.Getenv("MONGODB_DB_NAME")
+	if dbName == "" {
+		dbName = "ocs"
+	}
+
+	collectionName := os.Getenv("MONGODB_COLLECTION")
+	if collectionName == "" {
+		collectionName = "workload_adjacency"
+	}
+
+	mongoOpts, err := loadMongoDBOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientOpts := options.Client().
+		ApplyURI(mongoURI).
+		SetMaxPoolSize(mongoOpts.maxPoolSize).
+		SetRetryWrites(mongoOpts.retryWrites).
+		SetServerSelectionTimeout(mongoOpts.serverSelectionTimeout)
+	if mongoOpts.tlsConfig != nil {
+		clientOpts.SetTLSConfig(mongoOpts.tlsConfig)
+	}
+	client, err := mongo.Connect(ctx, clientOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+
+	// Test connection
+	if err := client.Ping(ctx, nil); err != nil {
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	database := client.Database(dbName)
+	collection := database.Collection(collectionName)
+
+	slog.Info("Connected to MongoDB", "mongodb_uri", redactMongoURI(mongoURI), "database", dbName, "collection", collectionName)
+
+	repo := &MongoDBRepository{
+		client:                 client,
+		database:               database,
+		collection:             collection,
+		writeMaxAttempts:       mongoOpts.writeMaxAttempts,
+		writeRetryDelay:        mongoOpts.writeRetryDelay,
+		compressThresholdBytes: mongoOpts.compressThresholdBytes,
+		maxDocumentBytes:       mongoOpts.maxDocumentBytes,
+	}
+	if err := repo.ensureLatestIndex(ctx); err != nil {
+		repo.Close()
+		return nil, err
+	}
+	if err := repo.ensureBucketIndex(ctx); err != nil {
+		repo.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+// ForDatabase returns a repository for the same collection in another
+// database, sharing this repository's client and write settings. The
+// returned repository must not be closed; closing this one disconnects both.
+func (r *MongoDBRepository) ForDatabase(dbName string) (*MongoDBRepository, error) {
+	database := r.client.Database(dbName)
+	repo := &MongoDBRepository{
+		client:                 r.client,
+		database:               database,
+		collection:             database.Collection(r.collection.Name()),
+		writeMaxAttempts:       r.writeMaxAttempts,
+		writeRetryDelay:        r.writeRetryDelay,
+		compressThresholdBytes: r.compressThresholdBytes,
+		maxDocumentBytes:       r.maxDocumentBytes,
+		OnWriteError:           r.OnWriteError,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := repo.ensureLatestIndex(ctx); err != nil {
+		return nil, err
+	}
+	if err := repo.ensureBucketIndex(ctx); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// DatabaseName returns the name of the database snapshots are stored in
+func (r *MongoDBRepository) DatabaseName() string {
+	return r.database.Name()
+}
+
+// ensureLatestIndex creates a descending index on the timestamp field so
+// finding the latest snapshot doesn't sort the whole collection in memory.
+// Creating an index that already exists is a no-op.
+func (r *MongoDBRepository) ensureLatestIndex(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "timestamp", Value: -1}},
+		Options: options.Index().SetName(latestIndexName),
+	}
+	if _, err := r.collection.Indexes().CreateOne(ctx, index); err != nil {
+		return fmt.Errorf("failed to create timestamp index: %w", err)
+	}
+	return nil
+}
+
+// ensureBucketIndex creates a unique index on the bucket field so concurrent
+// bucketed saves can't create two snapshots for one bucket. It is partial, so
+// snapshots saved individually, which have no bucket, are not indexed.
+func (r *MongoDBRepository) ensureBucketIndex(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "bucket", Value: 1}},
+		Options: options.Index().
+			SetName(bucketIndexName).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "bucket", Value: bson.D{{Key: "$exists", Value: true}}}}),
+	}
+	if _, err := r.collection.Indexes().CreateOne(ctx, index); err != nil {
+		return fmt.Errorf("failed to create bucket index: %w", err)
+	}
+	return nil
+}
+
+// Close closes the MongoDB connection
+func (r *MongoDBRepository) Close() error {
+	if r.client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return r.client.Disconnect(ctx)
+	}
+	return nil
+}
+
+// Ping checks that MongoDB is reachable
+func (r *MongoDBRepository) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := r.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+	return nil
+}
+
+// EnsureRetentionIndex creates a TTL index on the timestamp field so MongoDB
+// expires documents older than retention. An existing TTL index with a
+// different expiry is replaced.
+func (r *MongoDBRepository) EnsureRetentionIndex(retention time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "timestamp", Value: 1}},
+		Options: options.Index().
+			SetName(retentionIndexName).
+			SetExpireAfterSeconds(int32(retention / time.Second)),
+	}
+
+	_, err := r.collection.Indexes().CreateOne(ctx, index)
+	if err != nil && isIndexConflict(err) {
+		// The expiry changed since the index was created; rebuild it
+		if _, dropErr := r.collection.Indexes().DropOne(ctx, retentionIndexName); dropErr != nil {
+			return fmt.Errorf("failed to drop retention index: %w", dropErr)
+		}
+		_, err = r.collection.Indexes().CreateOne(ctx, index)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create retention index: %w", err)
+	}
+
+	slog.Info("Topology snapshot retention index ready", "retention", retention.String())
+	return nil
+}
+
+// isIndexConflict reports whether err is MongoDB rejecting an index that
+// already exists with different options
+func isIndexConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == 85 || cmdErr.Code == 86 // IndexOptionsConflict, IndexKeySpecsConflict
+	}
+	return false
+}
+
+// PruneOlderThan deletes adjacency list documents with a timestamp before t
+// and returns the number of documents deleted
+func (r *MongoDBRepository) PruneOlderThan(t time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.D{{Key: "timestamp", Value: bson.D{{Key: "$lt", Value: t}}}})
+	if err != nil {
+		r.writeFailed(err)
+		return 0, fmt.Errorf("failed to delete documents: %w", err)
+	}
+
+	return result.DeletedCount, nil
+}
+
+// GetLatestAdjacencyList retrieves the most recent adjacency list from MongoDB
+func (r *MongoDBRepository) GetLatestAdjacencyList() (map[string][]string, error) {
+	doc, err := r.GetLatestSnapshot()
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	return doc.AdjacencyList, nil
+}
+
+// GetLatestSnapshot retrieves the most recent adjacency list document from MongoDB
+func (r *MongoDBRepository) GetLatestSnapshot() (*AdjacencyListDocument, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Find the latest document sorted by timestamp
+	var doc AdjacencyListDocument
+	opts := options.FindOne().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	err := r.collection.FindOne(ctx, bson.D{}, opts).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil // No documents found, return nil
+		}
+		return nil, fmt.Errorf("failed to query MongoDB: %w", err)
+	}
+
+	if err := migrateDocument(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// GetAdjacencyListByID retrieves the adjacency list document with the given hex ObjectID
+func (r *MongoDBRepository) GetAdjacencyListByID(id string) (*AdjacencyListDocument, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSnapshotID, id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var doc AdjacencyListDocument
+	err = r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: objectID}}).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to query MongoDB: %w", err)
+	}
+
+	if err := migrateDocument(&doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// ListAdjacencyLists returns adjacency list documents sorted newest-first, without
+// their adjacency data, along with the total number of stored documents
+func (r *MongoDBRepository) ListAdjacencyLists(limit, offset int) ([]AdjacencyListDocument, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	total, err := r.collection.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count documents: %w", err)
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit)).
+		SetProjection(bson.D{
+			{Key: "schema_version", Value: 1},
+			{Key: "timestamp", Value: 1},
+			{Key: "source_count", Value: 1},
+			{Key: "total_connections", Value: 1},
+		})
+
+	cursor, err := r.collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query MongoDB: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	docs := make([]AdjacencyListDocument, 0)
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode documents: %w", err)
+	}
+	for i := range docs {
+		// Listing only reports the version, so newer documents are not an error here
+		if docs[i].SchemaVersion == 0 {
+			docs[i].SchemaVersion = schemaVersionAdjacencyOnly
+		}
+	}
+
+	return docs, total, nil
+}
+
+// ListSnapshotsBetween returns the adjacency data of every snapshot taken
+// between from and to inclusive, oldest-first, reading at most limit snapshots
+func (r *MongoDBRepository) ListSnapshotsBetween(from, to time.Time, limit int) ([]AdjacencyListDocument, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "timestamp", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lte", Value: to}}}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.D{
+			{Key: "schema_version", Value: 1},
+			{Key: "adjacency_list", Value: 1},
+			{Key: "edge_weights", Value: 1},
+			{Key: "compressed_graph", Value: 1},
+			{Key: "timestamp", Value: 1},
+		})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query MongoDB: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	docs := make([]AdjacencyListDocument, 0)
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %w", err)
+	}
+	for i := range docs {
+		if err := migrateDocument(&docs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// SaveAdjacencyList saves a collected topology with its edge weights,
+// reporting instances, error rates, labels and metric values to MongoDB.
+// Optional parts such as error rates may be nil when not collected.
+func (r *MongoDBRepository) SaveAdjacencyList(graph topology.Graph) (primitive.ObjectID, error) {
+	return r.SaveAdjacencyListAt(time.Now(), graph)
+}
+
+// SaveAdjacencyListAt saves a snapshot timestamped at timestamp rather than
+// now, for topology collected over a past window
+func (r *MongoDBRepository) SaveAdjacencyListAt(timestamp time.Time, graph topology.Graph) (primitive.ObjectID, error) {
+	doc := newDocument(timestamp, graph)
+	if err := r.fitDocument(&doc); err != nil {
+		r.writeFailed(err)
+		return primitive.NilObjectID, err
+	}
+	if err := r.insertWithRetry(doc); err != nil {
+		r.writeFailed(err)
+		return primitive.NilObjectID, fmt.Errorf("failed to insert document: %w", err)
+	}
+
+	return doc.ID, nil
+}
+
+// SaveAdjacencyListInBucket saves a snapshot taken now, merging it into the
+// snapshot already saved in the same time bucket rather than inserting
+// another. Buckets are bucket long and aligned to the Unix epoch. The merged
+// snapshot takes the newest timestamp and a new document ID, so clients
+// waiting for a newer snapshot see the merge.
+func (r *MongoDBRepository) SaveAdjacencyListInBucket(bucket time.Duration, graph topology.Graph) (primitive.ObjectID, error) {
+	now := time.Now()
+	bucketStart := BucketStart(now, bucket)
+
+	var err error
+	for attempt := 1; attempt <= bucketSaveMaxAttempts; attempt++ {
+		var id primitive.ObjectID
+		if id, graph, err = r.saveInBucket(bucketStart, now, graph); err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, errBucketConflict) {
+			break
+		}
+	}
+	r.writeFailed(err)
+	return primitive.NilObjectID, fmt.Errorf("failed to save document in bucket: %w", err)
+}
+
+// saveInBucket makes one attempt at a bucketed save, failing with
+// errBucketConflict when another save changed the bucket first. It returns
+// the graph to retry with, which includes the old bucket's edges if they were
+// already moved out of it.
+//
+// A document's _id can't change, so a merge inserts the merged snapshot
+// outside the bucket, deletes the old one and then moves the new one in.
+// Readers see the merged snapshot as the latest as soon as it is inserted.
+func (r *MongoDBRepository) saveInBucket(bucketStart, timestamp time.Time, graph topology.Graph) (primitive.ObjectID, topology.Graph, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var existing AdjacencyListDocument
+	err := r.collection.FindOne(ctx, bson.M{"bucket": bucketStart}).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		doc := newBucketDocument(nil, bucketStart, timestamp, graph)
+		if err := r.fitDocument(&doc); err != nil {
+			return primitive.NilObjectID, graph, err
+		}
+		if err := r.insertWithRetry(doc); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return primitive.NilObjectID, graph, errBucketConflict
+			}
+			return primitive.NilObjectID, graph, err
+		}
+		return doc.ID, graph, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, graph, err
+	}
+	if err := migrateDocument(&existing); err != nil {
+		return primitive.NilObjectID, graph, err
+	}
+
+	doc := newBucketDocument(&existing, bucketStart, timestamp, graph)
+	merged := doc.graph()
+	if err := r.fitDocument(&doc); err != nil {
+		return primitive.NilObjectID, graph, err
+	}
+	doc.Bucket = nil
+	if err := r.insertWithRetry(doc); err != nil {
+		return primitive.NilObjectID, graph, err
+	}
+
+	// Matching the timestamp too makes the delete miss if another save merged into the bucket meanwhile
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": existing.ID, "timestamp": existing.Timestamp})
+	if err != nil || result.DeletedCount == 0 {
+		r.discardUnbucketed(ctx, doc.ID)
+		if err != nil {
+			return primitive.NilObjectID, graph, err
+		}
+		return primitive.NilObjectID, graph, errBucketConflict
+	}
+
+	if _, err := r.collection.UpdateByID(ctx, doc.ID, bson.M{"$set": bson.M{"bucket": bucketStart}}); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Another save filled the emptied bucket; the merged edges, old bucket's included, go into its snapshot
+			r.discardUnbucketed(ctx, doc.ID)
+			return primitive.NilObjectID, merged, errBucketConflict
+		}
+		return primitive.NilObjectID, graph, err
+	}
+	return doc.ID, graph, nil
+}
+
+// discardUnbucketed deletes a merged snapshot that could not be moved into
+// its bucket. A failure is only logged, leaving a stray unbucketed snapshot.
+func (r *MongoDBRepository) discardUnbucketed(ctx context.Context, id primitive.ObjectID) {
+	if _, err := r.collection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		slog.Warn("Failed to delete merged snapshot left outside its bucket", "document_id", id.Hex(), "error", err)
+	}
+}
+
+// writeFailed reports a failed write to the OnWriteError hook, if any
+func (r *MongoDBRepository) writeFailed(err error) {
+	if r.OnWriteError != nil {
+		r.OnWriteError(err)
+	}
+}
+
+// insertWithRetry inserts doc, retrying transient failures such as network
+// errors and primary failover with exponential backoff. The document's ID is
+// fixed, so a retry after an insert that succeeded but whose reply was lost
+// reports a duplicate _id, which counts as success. A duplicate on any other
+// index is a conflict with a different document and is returned.
+func (r *MongoDBRepository) insertWithRetry(doc AdjacencyListDocument) error {
+	maxAttempts := max(r.writeMaxAttempts, 1)
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff.Delay(r.writeRetryDelay, attempt-1))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = r.collection.InsertOne(ctx, doc)
+		cancel()
+
+		if err == nil || (attempt > 1 && isDuplicateIDError(err)) {
+			return nil
+		}
+		if !isTransientWriteError(err) || attempt == maxAttempts {
+			break
+		}
+		slog.Warn("Retrying MongoDB write", "attempt", attempt, "max_attempts", maxAttempts, "error", err)
+	}
+	return err
+}
+
+// isDuplicateIDError reports whether err is a duplicate key error on the _id
+// index, as opposed to another unique index such as the bucket index
+func isDuplicateIDError(err error) bool {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, we := range writeErr.WriteErrors {
+		if we.Code != 11000 {
+			continue
+		}
+		if keyPattern, ok := we.Raw.Lookup("keyPattern").DocumentOK(); ok {
+			elements, err := keyPattern.Elements()
+			if err == nil && len(elements) == 1 && elements[0].Key() == "_id" {
+				return true
+			}
+			continue
+		}
+		// Servers before 4.2 only name the index in the message
+		if strings.Contains(we.Message, "index: _id_ ") {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransientWriteError reports whether a failed write may succeed if retried
+func isTransientWriteError(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError")
+	}
+	var selectionErr drivertopology.ServerSelectionError
+	return errors.As(err, &selectionErr)
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sensitiveURIOptions are MongoDB connection string options whose values are
+// secrets and must not be logged
+var sensitiveURIOptions = map[string]bool{
+	"authmechanismproperties":         true,
+	"tlscertificatekeyfilepassword":   true,
+	"sslclientcertificatekeypassword": true,
+}
+
+// redactMongoURI returns uri safe to log: the userinfo holding the username
+// and password is replaced, as are the values of options carrying secrets
+func redactMongoURI(uri string) string {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return "<unparseable MongoDB URI>"
+	}
+
+	// The hosts end at the first / or ?; the userinfo ends at the last @ before them
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		end = len(rest)
+	}
+	if at := strings.LastIndex(rest[:end], "@"); at >= 0 {
+		rest = "REDACTED@" + rest[at+1:]
+	}
+
+	path, query, hasQuery := strings.Cut(rest, "?")
+	if !hasQuery {
+		return scheme + "://" + path
+	}
+	options := strings.Split(query, "&")
+	for i, option := range options {
+		name, _, _ := strings.Cut(option, "=")
+		if sensitiveURIOptions[strings.ToLower(name)] {
+			options[i] = name + "=REDACTED"
+		}
+	}
+	return scheme + "://" + path + "?" + strings.Join(options, "&")
+}
+
+// loadMongoTLSConfig builds the client TLS config from MONGODB_TLS,
+// MONGODB_TLS_CA_FILE, MONGODB_TLS_CERT_KEY_FILE and MONGODB_TLS_INSECURE.
+// It returns nil when none is set, leaving TLS to the connection string. The
+// files are read up front so a bad path fails startup rather than the first
+// connection.
+func loadMongoTLSConfig() (*tls.Config, error) {
+	enabled := false
+	explicit := false
+	if value := os.Getenv("MONGODB_TLS"); value != "" {
+		var err error
+		if enabled, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid MONGODB_TLS %q: %w", value, err)
+		}
+		explicit = true
+	}
+	caFile := os.Getenv("MONGODB_TLS_CA_FILE")
+	certKeyFile := os.Getenv("MONGODB_TLS_CERT_KEY_FILE")
+	insecure := false
+	if value := os.Getenv("MONGODB_TLS_INSECURE"); value != "" {
+		var err error
+		if insecure, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid MONGODB_TLS_INSECURE %q: %w", value, err)
+		}
+	}
+
+	configured := caFile != "" || certKeyFile != "" || insecure
+	if explicit && !enabled {
+		if configured {
+			return nil, fmt.Errorf("MONGODB_TLS=false conflicts with the other MONGODB_TLS_* settings")
+		}
+		return nil, nil
+	}
+	if !enabled && !configured {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MONGODB_TLS_CA_FILE: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("MONGODB_TLS_CA_FILE %s holds no PEM certificates", caFile)
+		}
+	}
+	if certKeyFile != "" {
+		// MongoDB keeps the client certificate and its key in one PEM file
+		cert, err := tls.LoadX509KeyPair(certKeyFile, certKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MONGODB_TLS_CERT_KEY_FILE: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if insecure {
+		slog.Warn("MONGODB_TLS_INSECURE is set; the MongoDB server certificate is not verified")
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
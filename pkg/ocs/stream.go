@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/store"
+)
+
+const (
+	// topologyStreamHeartbeat is how often an idle stream sends a comment so
+	// proxies and load balancers don't close it
+	topologyStreamHeartbeat = 15 * time.Second
+
+	// topologyStreamWriteTimeout is how long a write may block before the
+	// client is considered stalled and disconnected
+	topologyStreamWriteTimeout = 30 * time.Second
+)
+
+// TopologyUpdate is the data of a topology event on the topology stream
+type TopologyUpdate struct {
+	DocumentID       string              `json:"document_id"`
+	Timestamp        string              `json:"timestamp"`
+	SourceCount      int                 `json:"source_count"`
+	TotalConnections int                 `json:"total_connections"`
+	AdjacencyList    map[string][]string `json:"adjacency_list"`
+}
+
+// topologyStreamHandler handles the topology/stream endpoint, a Server-Sent
+// Events stream that sends the latest adjacency list on connect and again
+// whenever a collection saves a new snapshot. Each wake-up sends only the
+// latest snapshot, so a slow client skips the ones saved while it caught up.
+func (s *Server) topologyStreamHandler(c *gin.Context) {
+	repo := tenantOf(c).repo
+	snapshot, err := repo.GetLatestSnapshot()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+		return
+	}
+
+	logger := requestLogger(c)
+	controller := http.NewResponseController(c.Writer)
+	// The connection may be reused after the stream, so don't leave a deadline behind
+	defer controller.SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	c.Status(http.StatusOK)
+
+	// write sends one SSE frame and flushes it, giving up on a stalled client
+	write := func(frame string) bool {
+		controller.SetWriteDeadline(time.Now().Add(topologyStreamWriteTimeout))
+		if _, err := c.Writer.WriteString(frame); err != nil {
+			return false
+		}
+		return controller.Flush() == nil
+	}
+
+	// A reconnecting client that already has the latest snapshot isn't sent it again
+	lastSent := c.GetHeader("Last-Event-ID")
+	if !write(": connected\n\n") {
+		return
+	}
+
+	heartbeat := time.NewTicker(topologyStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		// Subscribe before sending so a collection finishing in between still wakes us
+		updated := s.notifier.Subscribe()
+
+		if snapshot != nil && snapshot.ID.Hex() != lastSent {
+			frame, err := topologyEventFrame(snapshot)
+			if err != nil {
+				logger.Error("Failed to encode topology event", "error", err)
+				return
+			}
+			if !write(frame) {
+				logger.Debug("Topology stream client stopped reading", "document_id", snapshot.ID.Hex())
+				return
+			}
+			lastSent = snapshot.ID.Hex()
+		}
+
+		if !s.waitForSnapshot(c, updated, heartbeat.C, write) {
+			return
+		}
+		if snapshot, err = repo.GetLatestSnapshot(); err != nil {
+			logger.Warn("Failed to retrieve topology for stream", "error", err)
+			snapshot = nil
+		}
+	}
+}
+
+// waitForSnapshot blocks until updated is closed, sending heartbeats in the
+// meantime. It returns false when the stream should end.
+func (s *Server) waitForSnapshot(c *gin.Context, updated <-chan struct{}, heartbeat <-chan time.Time, write func(string) bool) bool {
+	for {
+		select {
+		case <-updated:
+			return true
+		case <-heartbeat:
+			if !write(": keep-alive\n\n") {
+				return false
+			}
+		case <-s.shutdown:
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	}
+}
+
+// topologyEventFrame encodes a snapshot as a topology event, using its
+// document ID as the event ID so clients can resume with Last-Event-ID
+func topologyEventFrame(snapshot *store.AdjacencyListDocument) (string, error) {
+	adjacencyList := adjacencyListOf(snapshot)
+	if adjacencyList == nil {
+		adjacencyList = map[string][]string{}
+	}
+	data, err := json.Marshal(TopologyUpdate{
+		DocumentID:       snapshot.ID.Hex(),
+		Timestamp:        snapshot.Timestamp.Format(time.RFC3339),
+		SourceCount:      snapshot.SourceCount,
+		TotalConnections: snapshot.TotalConnections,
+		AdjacencyList:    adjacencyList,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("id: %s\nevent: topology\ndata: %s\n\n", snapshot.ID.Hex(), data), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/store"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// readSSEEvent reads lines up to the end of the next event, skipping comments
+func readSSEEvent(t *testing.T, r *bufio.Reader) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(fields) > 0 {
+				return fields
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		fields[name] = value
+	}
+}
+
+func TestTopologyStreamHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := store.NewInMemoryRepository()
+	firstID, err := repo.SaveAdjacencyList(topology.Graph{AdjacencyList: map[string][]string{"frontend": {"cart"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		defaultTenant: newTenant("", repo),
+		notifier:      NewSnapshotNotifier(),
+		shutdown:      make(chan struct{}),
+	}
+	router := gin.New()
+	// Streams must get through the gzip middleware unbuffered
+	router.Use(gzipMiddleware)
+	router.GET("/topology/stream", s.resolveTenant, s.topologyStreamHandler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/topology/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	event := readSSEEvent(t, reader)
+	if event["event"] != "topology" || event["id"] != firstID.Hex() {
+		t.Fatalf("first event = %v, want the latest snapshot %s", event, firstID.Hex())
+	}
+
+	secondID, err := s.saveTopology(s.defaultTenant, &OCSConfig{}, topology.Graph{AdjacencyList: map[string][]string{"frontend": {"cart", "checkout"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = readSSEEvent(t, reader)
+	if event["id"] != secondID.Hex() {
+		t.Fatalf("second event ID = %q, want %s", event["id"], secondID.Hex())
+	}
+	var update TopologyUpdate
+	if err := json.Unmarshal([]byte(event["data"]), &update); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]string{"frontend": {"cart", "checkout"}}; !reflect.DeepEqual(update.AdjacencyList, want) {
+		t.Errorf("adjacency_list = %v, want %v", update.AdjacencyList, want)
+	}
+
+	// Shutdown ends the stream
+	close(s.shutdown)
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("stream still open after shutdown")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// tenantHeader selects the tenant a request acts on when tenants are configured
+	tenantHeader = "X-Tenant-ID"
+	// tenantKey stores the request's resolved tenant in the Gin context
+	tenantKey = "tenant"
+)
+
+// tenantIDPattern keeps tenant IDs safe to use in MongoDB database names
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// tenant holds the state scoped to one tenant: its snapshots, its cached
+// prompt and the outcome of its last collection. Without configured tenants
+// the server has a single default tenant using MONGODB_DB_NAME.
+type tenant struct {
+	id             string // Empty for the default tenant
+	repo           TopologyStore
+	promptCache    *promptCache
+	lastCollection collectionTracker
+}
+
+// newTenant creates a tenant storing its snapshots in repo
+func newTenant(id string, repo TopologyStore) *tenant {
+	return &tenant{id: id, repo: repo, promptCache: newPromptCache()}
+}
+
+// loadTenantIDs reads the comma-separated tenant IDs from OCS_TENANTS. An
+// empty result leaves the server single-tenant.
+func loadTenantIDs() ([]string, error) {
+	ids := splitList(os.Getenv("OCS_TENANTS"))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !tenantIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid tenant ID %q in OCS_TENANTS: use up to 32 lowercase letters, digits, '-' or '_'", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate tenant ID %q in OCS_TENANTS", id)
+		}
+		seen[id] = true
+	}
+	return ids, nil
+}
+
+// tenantDatabaseName returns the database holding a tenant's snapshots.
+// Each tenant gets its own database so no query can reach another tenant's data.
+func tenantDatabaseName(baseName, id string) string {
+	return baseName + "_" + id
+}
+
+// newTenants creates a tenant for each ID with its own database alongside
+// base's, or the single default tenant on base when ids is empty
+func newTenants(base StoreBackend, ids []string) (*tenant, map[string]*tenant, error) {
+	if len(ids) == 0 {
+		return newTenant("", base), nil, nil
+	}
+
+	tenants := make(map[string]*tenant, len(ids))
+	for _, id := range ids {
+		repo, err := forDatabase(base, tenantDatabaseName(base.DatabaseName(), id))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to initialize tenant %q: %w", id, err)
+		}
+		tenants[id] = newTenant(id, repo)
+	}
+	return nil, tenants, nil
+}
+
+// multiTenant reports whether tenants are configured
+func (s *Server) multiTenant() bool {
+	return s.tenants != nil
+}
+
+// hasTenant reports whether id is a configured tenant
+func (s *Server) hasTenant(id string) bool {
+	_, ok := s.tenants[id]
+	return ok
+}
+
+// allTenants returns every tenant, ordered by ID
+func (s *Server) allTenants() []*tenant {
+	if !s.multiTenant() {
+		return []*tenant{s.defaultTenant}
+	}
+	all := make([]*tenant, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		all = append(all, t)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].id < all[j].id })
+	return all
+}
+
+// resolveTenant is middleware selecting the tenant a request acts on. With
+// tenants configured, an API key bound to a tenant selects it and may not be
+// used for any other; otherwise the X-Tenant-ID header names the tenant.
+// Requests naming no tenant or an unknown one are rejected.
+func (s *Server) resolveTenant(c *gin.Context) {
+	if !s.multiTenant() {
+		c.Set(tenantKey, s.defaultTenant)
+		return
+	}
+
+	t, status, err := s.selectTenant(c.GetHeader(tenantHeader), c.GetString(apiKeyTenantKey))
+	if err != nil {
+		respondError(c, status, ErrCodeInvalidTenant, err.Error())
+		c.Abort()
+		return
+	}
+	c.Set(tenantKey, t)
+	c.Set(requestLoggerKey, requestLogger(c).With("tenant", t.id))
+}
+
+// selectTenant returns the configured tenant a request acts on, given the
+// tenant it names and the tenant its API key is bound to, either of which
+// may be empty. On failure it returns the HTTP status to reject it with.
+func (s *Server) selectTenant(requested, keyTenant string) (*tenant, int, error) {
+	id := requested
+	if keyTenant != "" {
+		if id != "" && id != keyTenant {
+			return nil, http.StatusForbidden, fmt.Errorf("API key is not valid for tenant %q", id)
+		}
+		id = keyTenant
+	}
+	if id == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("Missing %s header", tenantHeader)
+	}
+
+	t, ok := s.tenants[id]
+	if !ok {
+		return nil, http.StatusBadRequest, fmt.Errorf("Unknown tenant %q", id)
+	}
+	return t, 0, nil
+}
+
+// tenantOf returns the tenant resolveTenant selected for the request
+func tenantOf(c *gin.Context) *tenant {
+	return c.MustGet(tenantKey).(*tenant)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenantRouter serves an endpoint echoing the resolved tenant's ID
+func tenantRouter(s *Server, auth *apiKeyAuth) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(requestIDMiddleware)
+	router.GET("/topologies", auth.requireKeyForReads, s.resolveTenant, func(c *gin.Context) {
+		c.String(http.StatusOK, tenantOf(c).id)
+	})
+	return router
+}
+
+func TestResolveTenant(t *testing.T) {
+	t.Setenv("OCS_API_KEYS", "admin-key")
+	t.Setenv("OCS_TENANT_API_KEYS", "acme=acme-key,globex=globex-key")
+	auth, err := loadAPIKeyAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.protectReads {
+		t.Fatal("tenant API keys should protect reads")
+	}
+
+	s := &Server{tenants: map[string]*tenant{
+		"acme":   newTenant("acme", nil),
+		"globex": newTenant("globex", nil),
+	}}
+	router := tenantRouter(s, auth)
+
+	tests := []struct {
+		name       string
+		key        string
+		tenant     string
+		wantStatus int
+		wantTenant string
+	}{
+		{"tenant key selects its tenant", "acme-key", "", http.StatusOK, "acme"},
+		{"tenant key with matching header", "globex-key", "globex", http.StatusOK, "globex"},
+		{"tenant key for another tenant", "acme-key", "globex", http.StatusForbidden, ""},
+		{"admin key selects by header", "admin-key", "globex", http.StatusOK, "globex"},
+		{"admin key without header", "admin-key", "", http.StatusBadRequest, ""},
+		{"unknown tenant", "admin-key", "initech", http.StatusBadRequest, ""},
+		{"no key", "", "acme", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/topologies", nil)
+		if tt.key != "" {
+			req.Header.Set(apiKeyHeader, tt.key)
+		}
+		if tt.tenant != "" {
+			req.Header.Set(tenantHeader, tt.tenant)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus == http.StatusOK && w.Body.String() != tt.wantTenant {
+			t.Errorf("%s: tenant = %q, want %q", tt.name, w.Body.String(), tt.wantTenant)
+		}
+	}
+}
+
+func TestLoadTenantIDs(t *testing.T) {
+	t.Setenv("OCS_TENANTS", "acme, globex")
+	if ids, err := loadTenantIDs(); err != nil || len(ids) != 2 {
+		t.Errorf("got %v, %v; want two tenants", ids, err)
+	}
+	for _, value := range []string{"Acme", "acme,acme", "a.b"} {
+		t.Setenv("OCS_TENANTS", value)
+		if _, err := loadTenantIDs(); err == nil {
+			t.Errorf("OCS_TENANTS=%q: expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsVersions maps the accepted TLS_MIN_VERSION values to their protocol versions
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// defaultTLSMinVersion is the minimum protocol version when TLS_MIN_VERSION is unset
+const defaultTLSMinVersion = "1.2"
+
+// loadTLSConfig builds the server TLS config from TLS_CERT_FILE, TLS_KEY_FILE
+// and TLS_MIN_VERSION. It returns nil when neither file is set, leaving the
+// server on plain HTTP. The certificate is loaded up front so a bad pair
+// fails startup rather than the first handshake.
+func loadTLSConfig() (*tls.Config, error) {
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	minVersionName := envOrDefault("TLS_MIN_VERSION", defaultTLSMinVersion)
+	minVersion, ok := tlsVersions[minVersionName]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS_MIN_VERSION %q: must be \"1.2\" or \"1.3\"", minVersionName)
+	}
+
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.getCertificate,
+	}, nil
+}
+
+// certReloader serves a certificate from files that may be replaced while the
+// server runs, as happens when a mounted Kubernetes secret is rotated. The
+// pair is reloaded on the next handshake after the certificate file changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// load reads the certificate and key pair, remembering the certificate file's modification time
+func (r *certReloader) load() error {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = info.ModTime()
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate. If a changed
+// certificate cannot be loaded, for example because only one of the files
+// has been replaced so far, the previous certificate keeps being served.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info, err := os.Stat(r.certFile); err == nil && !info.ModTime().Equal(r.modTime) {
+		if err := r.load(); err != nil {
+			slog.Warn("Keeping current TLS certificate, reload failed", "error", err)
+		} else {
+			slog.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
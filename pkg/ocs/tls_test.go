@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and key for commonName into dir
+func writeTestCert(t *testing.T, dir, commonName string, modTime time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// servedCommonName connects to the server and returns the common name of its
+// certificate. The client sends SNI, without which httptest's built-in
+// certificate would be served instead of calling GetCertificate.
+func servedCommonName(t *testing.T, server *httptest.Server, maxVersion uint16) (string, error) {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion, ServerName: "localhost"},
+	}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	if config, err := loadTLSConfig(); config != nil || err != nil {
+		t.Fatalf("expected plain HTTP when unset, got %v, %v", config, err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first", time.Now().Add(-time.Minute))
+	t.Setenv("TLS_CERT_FILE", certFile)
+	if _, err := loadTLSConfig(); err == nil {
+		t.Fatal("expected an error with only TLS_CERT_FILE set")
+	}
+	t.Setenv("TLS_KEY_FILE", keyFile)
+
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("loadTLSConfig: %v", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion = %x, want TLS 1.2", config.MinVersion)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = config
+	server.StartTLS()
+	defer server.Close()
+
+	if name, err := servedCommonName(t, server, 0); err != nil || name != "first" {
+		t.Fatalf("served %q, %v; want the first certificate", name, err)
+	}
+	if _, err := servedCommonName(t, server, tls.VersionTLS11); err == nil {
+		t.Error("expected a TLS 1.1 client to be refused")
+	}
+
+	// A rotated secret is picked up without a restart
+	writeTestCert(t, dir, "second", time.Now())
+	if name, err := servedCommonName(t, server, 0); err != nil || name != "second" {
+		t.Errorf("served %q, %v; want the rotated certificate", name, err)
+	}
+
+	t.Setenv("TLS_MIN_VERSION", "1.1")
+	if _, err := loadTLSConfig(); err == nil {
+		t.Error("expected an error for TLS_MIN_VERSION 1.1")
+	}
+}
@@ -0,0 +1,122 @@
+// Package topology holds the workload dependency graph collected from Istio
+// metrics and the graph algorithms run on it.
+package topology
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Graph is the topology extracted from one collection's Prometheus result
+type Graph struct {
+	AdjacencyList   map[string][]string
+	EdgeWeights     EdgeWeights
+	EdgeInstances   map[string]map[string][]string
+	EdgeErrorRates  EdgeErrorRates // Nil unless error_rates is enabled
+	EdgeLastSeen    EdgeLastSeen
+	WorkloadLabels  WorkloadLabels
+	WorkloadMetrics WorkloadMetrics // Values of metrics with health thresholds; collected separately from the topology
+}
+
+// EdgeWeights maps each source workload to its destinations and the request count on each edge
+type EdgeWeights map[string]map[string]float64
+
+// AdjacencyList returns the edges as the unweighted source -> destinations list
+func (w EdgeWeights) AdjacencyList() map[string][]string {
+	adjacencyList := make(map[string][]string, len(w))
+	for source, destinations := range w {
+		for dest := range destinations {
+			adjacencyList[source] = append(adjacencyList[source], dest)
+		}
+		sort.Strings(adjacencyList[source])
+	}
+	return adjacencyList
+}
+
+// WorkloadLabels maps each workload to the identity labels observed on its series, by bare label name
+type WorkloadLabels map[string]map[string]string
+
+// WorkloadMetrics maps each workload to the collected value of each metric with health thresholds, by metric name
+type WorkloadMetrics map[string]map[string]float64
+
+// EdgeErrorRates maps source workload -> destination workload -> fraction of requests answered with a 5xx response code
+type EdgeErrorRates map[string]map[string]float64
+
+// EdgeLastSeen maps source workload -> destination workload -> when the edge was last observed carrying traffic
+type EdgeLastSeen map[string]map[string]time.Time
+
+// QualifyWorkload forms a namespace/workload key, or returns the workload alone if either part is empty
+func QualifyWorkload(namespace, workload string) string {
+	if namespace == "" || workload == "" {
+		return workload
+	}
+	return namespace + "/" + workload
+}
+
+// SplitWorkloadKey splits a namespace/workload key into its namespace and workload.
+// Unqualified keys have an empty namespace.
+func SplitWorkloadKey(key string) (string, string) {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+// Merge combines two collections of the same topology, older collected
+// before newer, into one graph holding the edges of both. Where both observed
+// an edge or workload, newer's attributes win, except that an edge's last
+// seen time is the later of the two and its instances are combined.
+func Merge(older, newer Graph) Graph {
+	adjacencyList, _ := Union([]map[string][]string{older.AdjacencyList, newer.AdjacencyList})
+
+	return Graph{
+		AdjacencyList:   adjacencyList,
+		EdgeWeights:     mergeNested(older.EdgeWeights, newer.EdgeWeights, keepNewer[float64]),
+		EdgeErrorRates:  mergeNested(older.EdgeErrorRates, newer.EdgeErrorRates, keepNewer[float64]),
+		EdgeLastSeen:    mergeNested(older.EdgeLastSeen, newer.EdgeLastSeen, laterTime),
+		WorkloadLabels:  mergeNested(older.WorkloadLabels, newer.WorkloadLabels, keepNewer[string]),
+		WorkloadMetrics: mergeNested(older.WorkloadMetrics, newer.WorkloadMetrics, keepNewer[float64]),
+		EdgeInstances:   mergeNested(older.EdgeInstances, newer.EdgeInstances, unionStrings),
+	}
+}
+
+// mergeNested combines two two-level maps, resolving entries present in both
+// with combine(older, newer). It returns nil when both are nil.
+func mergeNested[M ~map[string]map[string]V, V any](older, newer M, combine func(older, newer V) V) M {
+	if older == nil && newer == nil {
+		return nil
+	}
+	merged := make(M, max(len(older), len(newer)))
+	for _, m := range []M{older, newer} {
+		for outer, inner := range m {
+			if merged[outer] == nil {
+				merged[outer] = make(map[string]V, len(inner))
+			}
+			for key, value := range inner {
+				if existing, ok := merged[outer][key]; ok {
+					value = combine(existing, value)
+				}
+				merged[outer][key] = value
+			}
+		}
+	}
+	return merged
+}
+
+func keepNewer[V any](_, newer V) V { return newer }
+
+// unionStrings returns the sorted distinct strings of a and b
+func unionStrings(a, b []string) []string {
+	union := append(slices.Clone(a), b...)
+	slices.Sort(union)
+	return slices.Compact(union)
+}
+
+func laterTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
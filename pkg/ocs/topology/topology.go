@@ -0,0 +1,572 @@
+package topology
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Diff compares two adjacency lists and returns the edges that
+// were added in to, removed from from, and present in both
+func Diff(from, to map[string][]string) (added, removed, unchanged map[string][]string) {
+	added = make(map[string][]string)
+	removed = make(map[string][]string)
+	unchanged = make(map[string][]string)
+
+	fromEdges := edgeSet(from)
+	toEdges := edgeSet(to)
+
+	for source, destinations := range to {
+		for _, dest := range destinations {
+			if fromEdges[source][dest] {
+				unchanged[source] = append(unchanged[source], dest)
+			} else {
+				added[source] = append(added[source], dest)
+			}
+		}
+	}
+
+	for source, destinations := range from {
+		for _, dest := range destinations {
+			if !toEdges[source][dest] {
+				removed[source] = append(removed[source], dest)
+			}
+		}
+	}
+
+	return added, removed, unchanged
+}
+
+// edgeSet converts an adjacency list into a source -> destination set
+func edgeSet(adjacencyList map[string][]string) map[string]map[string]bool {
+	edges := make(map[string]map[string]bool, len(adjacencyList))
+	for source, destinations := range adjacencyList {
+		edges[source] = make(map[string]bool, len(destinations))
+		for _, dest := range destinations {
+			edges[source][dest] = true
+		}
+	}
+	return edges
+}
+
+// CountEdges returns the total number of edges in an adjacency list
+func CountEdges(adjacencyList map[string][]string) int {
+	total := 0
+	for _, destinations := range adjacencyList {
+		total += len(destinations)
+	}
+	return total
+}
+
+// Union merges adjacency lists into one holding every edge present in any of
+// them, with sorted destinations, and counts how many of the lists each edge
+// appeared in
+func Union(adjacencyLists []map[string][]string) (map[string][]string, map[string]map[string]int) {
+	observations := make(map[string]map[string]int)
+	for _, adjacencyList := range adjacencyLists {
+		for source, destinations := range edgeSet(adjacencyList) {
+			if len(destinations) == 0 {
+				continue
+			}
+			if observations[source] == nil {
+				observations[source] = make(map[string]int)
+			}
+			for dest := range destinations {
+				observations[source][dest]++
+			}
+		}
+	}
+
+	union := make(map[string][]string, len(observations))
+	for source, destinations := range observations {
+		for dest := range destinations {
+			union[source] = append(union[source], dest)
+		}
+		sort.Strings(union[source])
+	}
+	return union, observations
+}
+
+// Reverse inverts an adjacency list, mapping each workload to the
+// sorted workloads that depend on it
+func Reverse(adj map[string][]string) map[string][]string {
+	reverse := make(map[string][]string)
+	for source, destinations := range adj {
+		for _, dest := range destinations {
+			reverse[dest] = append(reverse[dest], source)
+		}
+	}
+	for _, sources := range reverse {
+		sort.Strings(sources)
+	}
+	return reverse
+}
+
+// ReachableWithin returns every workload reachable from start in at most
+// maxHops edges, mapped to its shortest hop distance. The breadth-first
+// traversal visits each workload once, so cycles terminate; start itself is
+// excluded even when a cycle leads back to it.
+func ReachableWithin(adj map[string][]string, start string, maxHops int) map[string]int {
+	hops := map[string]int{start: 0}
+	frontier := []string{start}
+
+	for hop := 1; hop <= maxHops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, node := range frontier {
+			for _, neighbor := range adj[node] {
+				if _, seen := hops[neighbor]; !seen {
+					hops[neighbor] = hop
+					next = append(next, neighbor)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	delete(hops, start)
+	return hops
+}
+
+// WorkloadsAtHop returns the workloads whose shortest distance from start is
+// exactly hop edges, sorted
+func WorkloadsAtHop(adj map[string][]string, start string, hop int) []string {
+	workloads := make([]string, 0)
+	for workload, distance := range ReachableWithin(adj, start, hop) {
+		if distance == hop {
+			workloads = append(workloads, workload)
+		}
+	}
+	sort.Strings(workloads)
+	return workloads
+}
+
+// ShortestPath returns the workloads on a shortest path from one workload to
+// another, both included, or nil when to is unreachable. Destinations are
+// explored in sorted order, so ties resolve the same way every time, and each
+// workload is visited once, so cycles terminate. A workload reaches itself
+// with a one-workload path.
+func ShortestPath(adjacencyList map[string][]string, from, to string) []string {
+	if from == to {
+		return []string{from}
+	}
+	_, neighbors := sortedGraph(adjacencyList)
+	previous := map[string]string{from: ""}
+	frontier := []string{from}
+
+	for len(frontier) > 0 {
+		var next []string
+		for _, node := range frontier {
+			for _, neighbor := range neighbors[node] {
+				if _, seen := previous[neighbor]; seen {
+					continue
+				}
+				previous[neighbor] = node
+				if neighbor == to {
+					path := []string{to}
+					for node := previous[to]; node != ""; node = previous[node] {
+						path = append(path, node)
+					}
+					slices.Reverse(path)
+					return path
+				}
+				next = append(next, neighbor)
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+// SimplePaths returns the simple paths from one workload to another of at
+// most maxHops edges, shortest first and then in lexicographic order. A
+// workload never appears twice on a path, so cycles terminate. At most limit
+// paths are returned; truncated reports whether more exist. Paths are
+// searched one length at a time, so the search stops as soon as the limit is
+// exceeded rather than enumerating every path of a dense graph.
+func SimplePaths(adjacencyList map[string][]string, from, to string, maxHops, limit int) (paths [][]string, truncated bool) {
+	_, neighbors := sortedGraph(adjacencyList)
+	paths = make([][]string, 0)
+	onPath := map[string]bool{from: true}
+	path := []string{from}
+
+	// walk extends path by depth more edges, collecting the paths that end at to
+	var walk func(node string, depth int) bool
+	walk = func(node string, depth int) bool {
+		if depth == 0 {
+			if node == to {
+				paths = append(paths, slices.Clone(path))
+			}
+			return len(paths) <= limit
+		}
+		if node == to {
+			// A simple path can't pass through its destination
+			return true
+		}
+		for _, neighbor := range neighbors[node] {
+			if onPath[neighbor] {
+				continue
+			}
+			onPath[neighbor] = true
+			path = append(path, neighbor)
+			more := walk(neighbor, depth-1)
+			path = path[:len(path)-1]
+			onPath[neighbor] = false
+			if !more {
+				return false
+			}
+		}
+		return true
+	}
+
+	for hops := 0; hops <= maxHops; hops++ {
+		if !walk(from, hops) {
+			return paths[:limit], true
+		}
+	}
+	return paths, false
+}
+
+// Degrees returns each workload's in-degree, the number of workloads calling
+// it, and out-degree, the number of workloads it calls. Every workload in the
+// adjacency list appears in both maps; duplicate edges count once.
+func Degrees(adjacencyList map[string][]string) (in, out map[string]int) {
+	nodes, neighbors := sortedGraph(adjacencyList)
+	in = make(map[string]int, len(nodes))
+	out = make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		in[node] = 0
+	}
+	for _, node := range nodes {
+		out[node] = len(neighbors[node])
+		for _, neighbor := range neighbors[node] {
+			in[neighbor]++
+		}
+	}
+	return in, out
+}
+
+// PageRank scores workloads by how much of the call graph depends on them,
+// running iterations rounds of PageRank with damping factor damping along
+// dependency edges. A workload called by many others, or by workloads that
+// are themselves heavily depended on, scores high. Scores sum to 1; workloads
+// calling nothing spread their score evenly over every workload.
+func PageRank(adjacencyList map[string][]string, damping float64, iterations int) map[string]float64 {
+	nodes, neighbors := sortedGraph(adjacencyList)
+	if len(nodes) == 0 {
+		return map[string]float64{}
+	}
+	n := float64(len(nodes))
+	rank := make(map[string]float64, len(nodes))
+	for _, node := range nodes {
+		rank[node] = 1 / n
+	}
+
+	for i := 0; i < iterations; i++ {
+		dangling := 0.0
+		for _, node := range nodes {
+			if len(neighbors[node]) == 0 {
+				dangling += rank[node]
+			}
+		}
+		next := make(map[string]float64, len(nodes))
+		for _, node := range nodes {
+			next[node] = (1-damping)/n + damping*dangling/n
+		}
+		for _, node := range nodes {
+			if len(neighbors[node]) == 0 {
+				continue
+			}
+			share := damping * rank[node] / float64(len(neighbors[node]))
+			for _, neighbor := range neighbors[node] {
+				next[neighbor] += share
+			}
+		}
+		rank = next
+	}
+	return rank
+}
+
+// DetectCycles returns every simple cycle in the dependency graph, each as an
+// ordered slice of workload names starting from its lexicographically smallest
+// workload. Self-loops are reported as single-workload cycles.
+//
+// It uses Johnson's algorithm: cycles are enumerated from each start node in
+// sorted order over the subgraph of nodes not yet used as a start, and nodes
+// that cannot currently reach the start stay blocked so they are not re-walked.
+func DetectCycles(adjacencyList map[string][]string) [][]string {
+	nodes, neighbors := sortedGraph(adjacencyList)
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		index[node] = i
+	}
+
+	cycles := make([][]string, 0)
+	for startIdx, start := range nodes {
+		blocked := make(map[string]bool)
+		blockedBy := make(map[string]map[string]bool)
+		var stack []string
+
+		var unblock func(node string)
+		unblock = func(node string) {
+			blocked[node] = false
+			for w := range blockedBy[node] {
+				delete(blockedBy[node], w)
+				if blocked[w] {
+					unblock(w)
+				}
+			}
+		}
+
+		var circuit func(node string) bool
+		circuit = func(node string) bool {
+			found := false
+			stack = append(stack, node)
+			blocked[node] = true
+
+			for _, next := range neighbors[node] {
+				if index[next] < startIdx {
+					continue
+				}
+				if next == start {
+					cycles = append(cycles, append([]string(nil), stack...))
+					found = true
+				} else if !blocked[next] && circuit(next) {
+					found = true
+				}
+			}
+
+			if found {
+				unblock(node)
+			} else {
+				for _, next := range neighbors[node] {
+					if index[next] < startIdx {
+						continue
+					}
+					if blockedBy[next] == nil {
+						blockedBy[next] = make(map[string]bool)
+					}
+					blockedBy[next][node] = true
+				}
+			}
+
+			stack = stack[:len(stack)-1]
+			return found
+		}
+
+		circuit(start)
+	}
+
+	return cycles
+}
+
+// StronglyConnectedComponents groups workloads into strongly connected
+// components using Tarjan's algorithm. Only nontrivial components are
+// returned: those with more than one workload, or a single workload that
+// calls itself. Workloads within a component are sorted, and components
+// are ordered by their first workload, so the grouping is stable.
+func StronglyConnectedComponents(adjacencyList map[string][]string) [][]string {
+	nodes, neighbors := sortedGraph(adjacencyList)
+
+	index := make(map[string]int, len(nodes))
+	lowLink := make(map[string]int, len(nodes))
+	onStack := make(map[string]bool, len(nodes))
+	var stack []string
+	nextIndex := 0
+	components := make([][]string, 0)
+
+	var strongConnect func(node string)
+	strongConnect = func(node string) {
+		index[node] = nextIndex
+		lowLink[node] = nextIndex
+		nextIndex++
+		stack = append(stack, node)
+		onStack[node] = true
+
+		selfLoop := false
+		for _, next := range neighbors[node] {
+			if next == node {
+				selfLoop = true
+			}
+			if _, visited := index[next]; !visited {
+				strongConnect(next)
+				lowLink[node] = min(lowLink[node], lowLink[next])
+			} else if onStack[next] {
+				lowLink[node] = min(lowLink[node], index[next])
+			}
+		}
+
+		// node is the root of a component; pop it off the stack
+		if lowLink[node] == index[node] {
+			var component []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == node {
+					break
+				}
+			}
+
+			if len(component) > 1 || selfLoop {
+				sort.Strings(component)
+				components = append(components, component)
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		if _, visited := index[node]; !visited {
+			strongConnect(node)
+		}
+	}
+
+	sort.Slice(components, func(i, j int) bool {
+		return components[i][0] < components[j][0]
+	})
+	return components
+}
+
+// sortedGraph returns every workload in the adjacency list (sources and
+// destinations) in sorted order, with each workload's deduplicated, sorted destinations
+func sortedGraph(adjacencyList map[string][]string) ([]string, map[string][]string) {
+	neighbors := make(map[string][]string)
+	seen := make(map[string]bool)
+
+	for source, destinations := range adjacencyList {
+		seen[source] = true
+		edges := make(map[string]bool, len(destinations))
+		for _, dest := range destinations {
+			seen[dest] = true
+			if !edges[dest] {
+				edges[dest] = true
+				neighbors[source] = append(neighbors[source], dest)
+			}
+		}
+		sort.Strings(neighbors[source])
+	}
+
+	nodes := make([]string, 0, len(seen))
+	for node := range seen {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	return nodes, neighbors
+}
+
+// ToDOT serializes the topology as a GraphViz DOT digraph with one node per
+// workload and one edge per dependency. Edges are labelled with their weight
+// when edgeWeights is non-nil and has an entry for the edge.
+func ToDOT(adjacencyList map[string][]string, edgeWeights EdgeWeights) string {
+	nodes, neighbors := sortedGraph(adjacencyList)
+
+	var b strings.Builder
+	b.WriteString("digraph topology {\n")
+	for _, node := range nodes {
+		fmt.Fprintf(&b, "  %s;\n", dotID(node))
+	}
+	for _, source := range nodes {
+		for _, dest := range neighbors[source] {
+			if weight, ok := edgeWeights[source][dest]; ok {
+				fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", dotID(source), dotID(dest), dotID(strconv.FormatFloat(weight, 'f', -1, 64)))
+			} else {
+				fmt.Fprintf(&b, "  %s -> %s;\n", dotID(source), dotID(dest))
+			}
+		}
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// WriteCSV streams the topology to w as CSV with a header row and one
+// source,destination row per dependency, sorted. A weight column is added
+// when edgeWeights is non-nil, left empty for edges without a weight. The csv
+// writer quotes workload names containing commas, quotes or newlines.
+func WriteCSV(w io.Writer, adjacencyList map[string][]string, edgeWeights EdgeWeights) error {
+	nodes, neighbors := sortedGraph(adjacencyList)
+	withWeights := edgeWeights != nil
+
+	cw := csv.NewWriter(w)
+	header := []string{"source", "destination"}
+	if withWeights {
+		header = append(header, "weight")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, source := range nodes {
+		for _, dest := range neighbors[source] {
+			row := []string{source, dest}
+			if withWeights {
+				weight := ""
+				if value, ok := edgeWeights[source][dest]; ok {
+					weight = strconv.FormatFloat(value, 'f', -1, 64)
+				}
+				row = append(row, weight)
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// dotID quotes a workload name as a DOT identifier, escaping quotes and backslashes
+func dotID(name string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(name)
+	return `"` + escaped + `"`
+}
+
+// ForWorkload builds topology information for a specific workload.
+// dependents is the reverse of adjacencyList, as built by Reverse.
+// When edge weights are available, the request count on each edge is
+// included under dependency_weights and dependent_weights. With a depth
+// above 1, every workload reachable within depth hops is included under
+// transitive_dependencies with its hop distance.
+func ForWorkload(adjacencyList, dependents map[string][]string, edgeWeights EdgeWeights, workload string, depth int) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	// Add dependencies (destinations this workload connects to)
+	if destinations, exists := adjacencyList[workload]; exists && len(destinations) > 0 {
+		result["dependencies"] = destinations
+
+		dependencyWeights := make(map[string]float64)
+		for _, dest := range destinations {
+			if weight, ok := edgeWeights[workload][dest]; ok {
+				dependencyWeights[dest] = weight
+			}
+		}
+		if len(dependencyWeights) > 0 {
+			result["dependency_weights"] = dependencyWeights
+		}
+
+		if depth > 1 {
+			result["transitive_dependencies"] = ReachableWithin(adjacencyList, workload, depth)
+		}
+	}
+
+	// Add reverse dependencies (workloads that connect to this one)
+	reverseDeps := dependents[workload]
+	dependentWeights := make(map[string]float64)
+	for _, source := range reverseDeps {
+		if weight, ok := edgeWeights[source][workload]; ok {
+			dependentWeights[source] = weight
+		}
+	}
+	if len(reverseDeps) > 0 {
+		result["dependents"] = reverseDeps
+	}
+	if len(dependentWeights) > 0 {
+		result["dependent_weights"] = dependentWeights
+	}
+
+	return result
+}
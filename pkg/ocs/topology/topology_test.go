@@ -0,0 +1,387 @@
+package topology
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectCycles(t *testing.T) {
+	tests := []struct {
+		name          string
+		adjacencyList map[string][]string
+		want          [][]string
+	}{
+		{
+			name: "self-loop",
+			adjacencyList: map[string][]string{
+				"worker": {"worker", "database"},
+			},
+			want: [][]string{
+				{"worker"},
+			},
+		},
+		{
+			name: "two-node cycle",
+			adjacencyList: map[string][]string{
+				"app":      {"database"},
+				"database": {"app"},
+			},
+			want: [][]string{
+				{"app", "database"},
+			},
+		},
+		{
+			name: "disjoint cycles",
+			adjacencyList: map[string][]string{
+				"app":      {"database"},
+				"database": {"queue"},
+				"queue":    {"app"},
+				"gateway":  {"auth"},
+				"auth":     {"gateway", "cache"},
+				"cache":    {},
+			},
+			want: [][]string{
+				{"app", "database", "queue"},
+				{"auth", "gateway"},
+			},
+		},
+		{
+			name: "acyclic graph has no cycles",
+			adjacencyList: map[string][]string{
+				"app":      {"database", "cache"},
+				"database": {"cache"},
+			},
+			want: [][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map iteration order is random, so repeat to check the order is stable
+			for i := 0; i < 20; i++ {
+				got := DetectCycles(tt.adjacencyList)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("DetectCycles() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectCyclesDenseGraph(t *testing.T) {
+	// Every workload calls every other: 21+70+210+504+840+720 simple cycles
+	workloads := []string{"a", "b", "c", "d", "e", "f", "g"}
+	adjacencyList := make(map[string][]string, len(workloads))
+	for _, source := range workloads {
+		for _, dest := range workloads {
+			if dest != source {
+				adjacencyList[source] = append(adjacencyList[source], dest)
+			}
+		}
+	}
+
+	done := make(chan [][]string, 1)
+	go func() { done <- DetectCycles(adjacencyList) }()
+	select {
+	case cycles := <-done:
+		if len(cycles) != 2365 {
+			t.Errorf("found %d cycles, want 2365", len(cycles))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("DetectCycles did not finish on a complete graph of 7 workloads")
+	}
+}
+
+func TestStronglyConnectedComponents(t *testing.T) {
+	tests := []struct {
+		name          string
+		adjacencyList map[string][]string
+		want          [][]string
+	}{
+		{
+			name: "two nontrivial components",
+			adjacencyList: map[string][]string{
+				"app":      {"database", "gateway"},
+				"database": {"queue"},
+				"queue":    {"app"},
+				"gateway":  {"auth"},
+				"auth":     {"gateway", "cache"},
+				"cache":    {},
+			},
+			want: [][]string{
+				{"app", "database", "queue"},
+				{"auth", "gateway"},
+			},
+		},
+		{
+			name: "self-loop is its own component",
+			adjacencyList: map[string][]string{
+				"worker": {"worker", "database"},
+			},
+			want: [][]string{
+				{"worker"},
+			},
+		},
+		{
+			name: "acyclic graph has no components",
+			adjacencyList: map[string][]string{
+				"app":      {"database"},
+				"database": {"cache"},
+			},
+			want: [][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map iteration order is random, so repeat to check the grouping is stable
+			for i := 0; i < 20; i++ {
+				got := StronglyConnectedComponents(tt.adjacencyList)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("StronglyConnectedComponents() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReverseAdjacency(t *testing.T) {
+	adjacencyList := map[string][]string{
+		"proxy": {"app"},
+		"app":   {"database", "cache"},
+		"batch": {"database"},
+	}
+	want := map[string][]string{
+		"app":      {"proxy"},
+		"database": {"app", "batch"},
+		"cache":    {"app"},
+	}
+
+	if got := Reverse(adjacencyList); !reflect.DeepEqual(got, want) {
+		t.Errorf("Reverse() = %v, want %v", got, want)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	union, observations := Union([]map[string][]string{
+		{"app": {"database", "cache"}},
+		{"app": {"database"}, "batch": {}},
+		{"app": {"database", "database"}, "proxy": {"app"}},
+	})
+
+	wantUnion := map[string][]string{"app": {"cache", "database"}, "proxy": {"app"}}
+	if !reflect.DeepEqual(union, wantUnion) {
+		t.Errorf("Union() = %v, want %v", union, wantUnion)
+	}
+	wantObservations := map[string]map[string]int{"app": {"cache": 1, "database": 3}, "proxy": {"app": 1}}
+	if !reflect.DeepEqual(observations, wantObservations) {
+		t.Errorf("observations = %v, want %v", observations, wantObservations)
+	}
+}
+
+func TestReachableWithin(t *testing.T) {
+	adjacencyList := map[string][]string{
+		"proxy":    {"app"},
+		"app":      {"database", "cache"},
+		"cache":    {"database"},
+		"database": {"app"}, // Cycle back to app
+	}
+
+	tests := []struct {
+		name    string
+		start   string
+		maxHops int
+		want    map[string]int
+	}{
+		{"one hop", "proxy", 1, map[string]int{"app": 1}},
+		{"shortest distance wins", "proxy", 3, map[string]int{"app": 1, "database": 2, "cache": 2}},
+		{"cycle excludes start", "app", 5, map[string]int{"database": 1, "cache": 1}},
+		{"leaf", "unknown", 3, map[string]int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReachableWithin(adjacencyList, tt.start, tt.maxHops); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReachableWithin(%q, %d) = %v, want %v", tt.start, tt.maxHops, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkloadsAtHop(t *testing.T) {
+	adjacencyList := map[string][]string{
+		"gateway":  {"frontend"},
+		"frontend": {"cart", "catalog"},
+		"cart":     {"database"},
+		"catalog":  {"database", "cart"},
+	}
+
+	if got, want := WorkloadsAtHop(adjacencyList, "gateway", 2), []string{"cart", "catalog"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second hop dependencies = %v, want %v", got, want)
+	}
+	// database is two hops from frontend via both cart and catalog, listed once
+	if got, want := WorkloadsAtHop(adjacencyList, "frontend", 2), []string{"database"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second hop dependencies = %v, want %v", got, want)
+	}
+	if got, want := WorkloadsAtHop(Reverse(adjacencyList), "database", 2), []string{"frontend"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second hop dependents = %v, want %v", got, want)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	adjacencyList := map[string][]string{
+		"app":          {"database", "cache"},
+		"billing, inc": {"app"},
+	}
+
+	var unweighted strings.Builder
+	if err := WriteCSV(&unweighted, adjacencyList, nil); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	want := "source,destination\napp,cache\napp,database\n\"billing, inc\",app\n"
+	if unweighted.String() != want {
+		t.Errorf("unweighted CSV = %q, want %q", unweighted.String(), want)
+	}
+
+	var weighted strings.Builder
+	if err := WriteCSV(&weighted, adjacencyList, EdgeWeights{"app": {"cache": 2.5}}); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	want = "source,destination,weight\napp,cache,2.5\napp,database,\n\"billing, inc\",app,\n"
+	if weighted.String() != want {
+		t.Errorf("weighted CSV = %q, want %q", weighted.String(), want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	earlier := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Minute)
+	older := Graph{
+		AdjacencyList:  map[string][]string{"app": {"cache", "db"}},
+		EdgeWeights:    EdgeWeights{"app": {"cache": 5, "db": 10}},
+		EdgeInstances:  map[string]map[string][]string{"app": {"db": {"prom-a"}}},
+		EdgeLastSeen:   EdgeLastSeen{"app": {"cache": later, "db": earlier}},
+		WorkloadLabels: WorkloadLabels{"app": {"version": "v1"}},
+	}
+	newer := Graph{
+		AdjacencyList:  map[string][]string{"app": {"db"}, "db": {"disk"}},
+		EdgeWeights:    EdgeWeights{"app": {"db": 12}, "db": {"disk": 1}},
+		EdgeInstances:  map[string]map[string][]string{"app": {"db": {"prom-b", "prom-a"}}},
+		EdgeLastSeen:   EdgeLastSeen{"app": {"cache": earlier, "db": later}},
+		WorkloadLabels: WorkloadLabels{"app": {"version": "v2"}},
+	}
+
+	merged := Merge(older, newer)
+	if want := map[string][]string{"app": {"cache", "db"}, "db": {"disk"}}; !reflect.DeepEqual(merged.AdjacencyList, want) {
+		t.Errorf("adjacency list = %v, want %v", merged.AdjacencyList, want)
+	}
+	if want := (EdgeWeights{"app": {"cache": 5, "db": 12}, "db": {"disk": 1}}); !reflect.DeepEqual(merged.EdgeWeights, want) {
+		t.Errorf("weights = %v, want %v", merged.EdgeWeights, want)
+	}
+	if got := merged.EdgeInstances["app"]["db"]; !reflect.DeepEqual(got, []string{"prom-a", "prom-b"}) {
+		t.Errorf("instances = %v, want both instances once", got)
+	}
+	if !merged.EdgeLastSeen["app"]["cache"].Equal(later) || !merged.EdgeLastSeen["app"]["db"].Equal(later) {
+		t.Errorf("last seen = %v, want the later time of each edge", merged.EdgeLastSeen)
+	}
+	if merged.WorkloadLabels["app"]["version"] != "v2" {
+		t.Errorf("labels = %v, want the newer version", merged.WorkloadLabels)
+	}
+	if merged.EdgeErrorRates != nil {
+		t.Errorf("error rates = %v, want nil when neither graph has them", merged.EdgeErrorRates)
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	adjacencyList := map[string][]string{
+		"gateway":  {"frontend"},
+		"frontend": {"catalog", "cart"},
+		"cart":     {"database", "frontend"}, // Cycle back to frontend
+		"catalog":  {"database"},
+	}
+
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{"gateway", "database", []string{"gateway", "frontend", "cart", "database"}}, // cart sorts before catalog
+		{"cart", "cart", []string{"cart"}},
+		{"database", "gateway", nil},
+		{"unknown", "database", nil},
+	}
+	for _, tt := range tests {
+		if got := ShortestPath(adjacencyList, tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ShortestPath(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestSimplePaths(t *testing.T) {
+	adjacencyList := map[string][]string{
+		"frontend": {"cart", "catalog", "database"},
+		"cart":     {"catalog", "frontend"},
+		"catalog":  {"database", "cart"},
+	}
+
+	paths, truncated := SimplePaths(adjacencyList, "frontend", "database", 5, 10)
+	want := [][]string{
+		{"frontend", "database"},
+		{"frontend", "catalog", "database"},
+		{"frontend", "cart", "catalog", "database"},
+	}
+	if !reflect.DeepEqual(paths, want) || truncated {
+		t.Errorf("SimplePaths = %v, %v; want %v", paths, truncated, want)
+	}
+
+	if paths, _ := SimplePaths(adjacencyList, "frontend", "database", 2, 10); len(paths) != 2 {
+		t.Errorf("with max 2 hops, paths = %v, want the two shortest", paths)
+	}
+	if paths, truncated := SimplePaths(adjacencyList, "frontend", "database", 5, 1); !reflect.DeepEqual(paths, want[:1]) || !truncated {
+		t.Errorf("with limit 1, paths = %v, %v; want the shortest and truncated", paths, truncated)
+	}
+	if paths, truncated := SimplePaths(adjacencyList, "database", "frontend", 5, 10); len(paths) != 0 || truncated {
+		t.Errorf("unreachable: paths = %v, %v; want none", paths, truncated)
+	}
+}
+
+func TestDegrees(t *testing.T) {
+	adjacencyList := map[string][]string{
+		"gateway":  {"frontend"},
+		"frontend": {"cart", "catalog", "cart"}, // Duplicate edge counts once
+		"cart":     {"database"},
+		"catalog":  {"database"},
+	}
+
+	in, out := Degrees(adjacencyList)
+	wantIn := map[string]int{"gateway": 0, "frontend": 1, "cart": 1, "catalog": 1, "database": 2}
+	wantOut := map[string]int{"gateway": 1, "frontend": 2, "cart": 1, "catalog": 1, "database": 0}
+	if !reflect.DeepEqual(in, wantIn) || !reflect.DeepEqual(out, wantOut) {
+		t.Errorf("Degrees = %v, %v; want %v, %v", in, out, wantIn, wantOut)
+	}
+}
+
+func TestPageRank(t *testing.T) {
+	adjacencyList := map[string][]string{
+		"frontend": {"database"},
+		"cart":     {"database"},
+		"catalog":  {"database", "cart"},
+	}
+
+	rank := PageRank(adjacencyList, 0.85, 50)
+	total := 0.0
+	for _, score := range rank {
+		total += score
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("scores sum to %g, want 1", total)
+	}
+	if !(rank["database"] > rank["cart"] && rank["cart"] > rank["frontend"]) {
+		t.Errorf("rank = %v, want database above cart above the uncalled frontend", rank)
+	}
+	if rank["frontend"] != rank["catalog"] {
+		t.Errorf("uncalled workloads scored %g and %g, want equal scores", rank["frontend"], rank["catalog"])
+	}
+}
@@ -1,10 +1,6 @@
 package main
 
-import (
-	"time"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
-)
+import "github.com/contexture/ocs/pkg/ocs/prometheus"
 
 // MetricConfig represents a metric configuration
 type MetricConfig struct {
@@ -18,53 +14,91 @@ type MetricConfig struct {
 
 // OCSConfig represents the OCS configuration structure
 type OCSConfig struct {
-	Policy            []string       `yaml:"policy"`
-	Metrics           []MetricConfig `yaml:"metrics"`
-	Workload          []string       `yaml:"workload"`
-	TimeWindowMinutes *int           `yaml:"time_window_minutes"` // Optional: if set, use time window for queries
+	Policy                   []string                        `yaml:"policy"`
+	Metrics                  []MetricConfig                  `yaml:"metrics"`
+	Workload                 []string                        `yaml:"workload"`
+	TimeWindowMinutes        *int                            `yaml:"time_window_minutes"`          // Optional: if set, use time window for queries
+	LogLevel                 string                          `yaml:"log_level"`                    // Optional: "info" (default) or "debug"
+	LogFormat                string                          `yaml:"log_format"`                   // Optional: "text" (default) or "json"
+	QuietQueries             bool                            `yaml:"quiet_queries"`                // Optional: log only the first query of a collection run at info level
+	EdgeDecayHalfLifeMinutes *float64                        `yaml:"edge_decay_half_life_minutes"` // Optional: half-life for decaying range query edge weights
+	MetricName               string                          `yaml:"metric_name"`                  // Optional: metric the topology is built from (default: istio_requests_total)
+	Namespaces               []string                        `yaml:"namespaces"`                   // Optional: only collect source workloads in these namespaces
+	QualifyNamespaces        bool                            `yaml:"qualify_namespaces"`           // Optional: key workloads as namespace/workload
+	RetentionDays            *int                            `yaml:"retention_days"`               // Optional: expire topology snapshots older than this many days
+	ErrorRates               bool                            `yaml:"error_rates"`                  // Optional: compute per-edge 5xx ratios from response_code
+	ErrorClassification      *prometheus.ErrorClassification `yaml:"error_classification"`         // Optional: response codes and flags error_rates counts as errors (default: 5xx)
+	ErrorRateThreshold       *float64                        `yaml:"error_rate_threshold"`         // Optional: default threshold for /topology/errors (default: 0.05)
+	PromptCacheTTLSeconds    *int                            `yaml:"prompt_cache_ttl_seconds"`     // Optional: cache get_ocs_prompt responses this long, 0 disables (default: 30)
+	MaxContextDefinitions    *int                            `yaml:"max_context_definitions"`      // Optional: cap get_ocs_prompt at this many definitions, most-connected workloads first (default: no cap)
+	SpecVersion              string                          `yaml:"spec_version"`                 // Optional: OCS spec version of generated prompts (default: 0.1)
+	Domain                   string                          `yaml:"domain"`                       // Optional: domain of generated context definitions (default: compute.k8s)
+	CollectionMode           string                          `yaml:"collection_mode"`              // Optional: force "instant" or "range" queries (default: range only with timestamps or time_window_minutes)
+	IdentityLabels           []string                        `yaml:"identity_labels"`              // Optional: Istio labels, e.g. app, version, cluster, added to each workload's identity
+	WorkloadRules            []prometheus.NormalizationRule  `yaml:"workload_rules"`               // Optional: regex rewrites collapsing workload names onto canonical ones
+	WorkloadAliases          map[string]string               `yaml:"workload_aliases"`             // Optional: static raw -> canonical workload name map, applied before workload_rules
+	ExcludeUnknown           bool                            `yaml:"exclude_unknown"`              // Optional: drop edges to or from workloads Istio could not attribute
+	UnknownWorkloads         []string                        `yaml:"unknown_workloads"`            // Optional: workload names exclude_unknown drops (default: unknown)
+	ExtraFilters             map[string]string               `yaml:"extra_filters"`                // Optional: label="value" matchers added to the topology query, e.g. connection_security_policy: mutual_tls
+	Reporter                 string                          `yaml:"reporter"`                     // Optional: Istio reporter the topology is counted from: source, destination, waypoint or all (default: source)
+	DropSelfEdges            bool                            `yaml:"drop_self_edges"`              // Optional: drop edges from a workload to itself
+	SnapshotBucketSeconds    *int                            `yaml:"snapshot_bucket_seconds"`      // Optional: merge collections within each bucket this long into one snapshot
+}
+
+// CollectOverrides replaces OCS config fields for a single collection. Unset
+// fields keep the running config's values.
+type CollectOverrides struct {
+	Workload   []string `yaml:"workload" json:"workload,omitempty"`
+	Namespaces []string `yaml:"namespaces" json:"namespaces,omitempty"`
+	MetricName string   `yaml:"metric_name" json:"metric_name,omitempty"`
+}
+
+// Collection modes forcing the Prometheus query type regardless of timestamps
+const (
+	// CollectionModeInstant always runs an instant query
+	CollectionModeInstant = "instant"
+	// CollectionModeRange always runs a range query, over time_window_minutes when no timestamps are given
+	CollectionModeRange = "range"
+)
+
+// WorkloadHealth is a workload's health computed from health_config thresholds
+type WorkloadHealth struct {
+	Status  string         `json:"status"`  // Worst status across Metrics: ok, warn or crit
+	Metrics []MetricHealth `json:"metrics"` // Each thresholded metric with a collected value
 }
 
-// PrometheusConfig represents Prometheus configuration
-type PrometheusConfig struct {
-	PrometheusInstances []struct {
-		Name       string            `yaml:"name"`
-		BaseURL    string            `yaml:"base_url"`
-		Headers    map[string]string `yaml:"headers"`
-		DisableSSL bool              `yaml:"disable_ssl"`
-	} `yaml:"prometheus_instances"`
+// MetricHealth is one metric's value and status for a workload
+type MetricHealth struct {
+	Name   string  `json:"name"`
+	Value  float64 `json:"value"`
+	Status string  `json:"status"`
 }
 
-// PrometheusQueryResult represents a Prometheus instant query result
-type PrometheusQueryResult struct {
-	Status string `json:"status"`
-	Data   struct {
-		ResultType string `json:"resultType"`
-		Result     []struct {
-			Metric map[string]string `json:"metric"`
-			Value  []interface{}     `json:"value"`
-		} `json:"result"`
-	} `json:"data"`
+// ErrorEdge is an edge reported by the topology errors endpoint
+type ErrorEdge struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	ErrorRate   float64 `json:"error_rate"`
+	Weight      float64 `json:"weight,omitempty"`
 }
 
-// PrometheusQueryRangeResult represents a Prometheus range query result
-type PrometheusQueryRangeResult struct {
-	Status string `json:"status"`
-	Data   struct {
-		ResultType string `json:"resultType"`
-		Result     []struct {
-			Metric map[string]string `json:"metric"`
-			Values [][]interface{}   `json:"values"`
-		} `json:"result"`
-	} `json:"data"`
+// WorkloadCentrality is a workload's connectedness reported by the topology
+// centrality endpoint
+type WorkloadCentrality struct {
+	Workload  string   `json:"workload"`
+	InDegree  int      `json:"in_degree"`          // Workloads calling it
+	OutDegree int      `json:"out_degree"`         // Workloads it calls
+	Degree    int      `json:"degree"`             // InDegree plus OutDegree
+	PageRank  *float64 `json:"pagerank,omitempty"` // Only with pagerank=true
 }
 
-// AdjacencyListDocument represents the MongoDB document structure
-type AdjacencyListDocument struct {
-	ID               primitive.ObjectID  `bson:"_id,omitempty"`
-	AdjacencyList    map[string][]string `bson:"adjacency_list"`
-	Timestamp        time.Time           `bson:"timestamp"`
-	SourceCount      int                 `bson:"source_count"`
-	TotalConnections int                 `bson:"total_connections"`
+// TopologySummary represents a stored adjacency list snapshot in the topologies listing
+type TopologySummary struct {
+	DocumentID       string `json:"document_id"`
+	SchemaVersion    int    `json:"schema_version"`
+	Timestamp        string `json:"timestamp"`
+	SourceCount      int    `json:"source_count"`
+	TotalConnections int    `json:"total_connections"`
 }
 
 // OCSContextDefinition represents a context definition in the OCS prompt response
@@ -75,10 +109,33 @@ type OCSContextDefinition struct {
 	Metrics    []MetricConfig         `json:"metrics,omitempty"`
 	Topology   map[string]interface{} `json:"topology,omitempty"`
 	Policy     []string               `json:"policy,omitempty"`
+	LastSeen   string                 `json:"last_seen,omitempty"` // When this workload's edges were last observed
+	Health     *WorkloadHealth        `json:"health,omitempty"`    // Set when a metric with health thresholds has a value for this workload
 }
 
 // OCSPromptResponse represents the OCS prompt response structure
 type OCSPromptResponse struct {
 	SpecVersion        string                 `json:"spec_version"`
+	SnapshotID         string                 `json:"snapshot_id,omitempty"` // ID of the topology snapshot the prompt was built from
 	ContextDefinitions []OCSContextDefinition `json:"context_definitions"`
+	NextCursor         string                 `json:"next_cursor,omitempty"`       // Cursor for the next page when the prompt is paginated and more definitions remain
+	Truncated          bool                   `json:"truncated,omitempty"`         // Set when the definitions were capped to the most-connected workloads
+	TotalDefinitions   int                    `json:"total_definitions,omitempty"` // Number of definitions before capping, when Truncated
+}
+
+// PromptRequest selects the context definitions POST /get_ocs_prompt returns,
+// by resource ID or workload key. Both lists may be given; definitions follow
+// resource IDs first, then workloads, each in request order.
+type PromptRequest struct {
+	ResourceIDs []string `json:"resource_ids,omitempty"`
+	Workloads   []string `json:"workloads,omitempty"`
+}
+
+// ErrorResponse represents the error response returned by all handlers
+type ErrorResponse struct {
+	Status    string      `json:"status"`
+	Message   string      `json:"message"`
+	Code      string      `json:"code"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
 }
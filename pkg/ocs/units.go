@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unit dimensions: values can only be converted between units of the same dimension
+const (
+	dimensionTime    = "time"
+	dimensionBytes   = "bytes"
+	dimensionPercent = "percent"
+	dimensionRate    = "rate"
+	dimensionCPU     = "cpu"
+	dimensionCount   = "count"
+)
+
+// unitDefinition places a unit in its dimension. factor is the size of the
+// unit in the dimension's smallest unit, so every factor is a whole number and
+// conversions between them stay exact for typical values.
+type unitDefinition struct {
+	dimension string
+	factor    float64
+}
+
+// unitRegistry holds every unit a metric's unit may name, keyed in lower case
+var unitRegistry = map[string]unitDefinition{
+	// Time, in nanoseconds
+	"ns":           {dimensionTime, 1},
+	"nanoseconds":  {dimensionTime, 1},
+	"us":           {dimensionTime, 1e3},
+	"µs":           {dimensionTime, 1e3},
+	"microseconds": {dimensionTime, 1e3},
+	"ms":           {dimensionTime, 1e6},
+	"milliseconds": {dimensionTime, 1e6},
+	"s":            {dimensionTime, 1e9},
+	"seconds":      {dimensionTime, 1e9},
+	"min":          {dimensionTime, 60e9},
+	"minutes":      {dimensionTime, 60e9},
+	"h":            {dimensionTime, 3600e9},
+	"hours":        {dimensionTime, 3600e9},
+
+	// Bytes, decimal and binary multiples
+	"b":     {dimensionBytes, 1},
+	"bytes": {dimensionBytes, 1},
+	"kb":    {dimensionBytes, 1e3},
+	"mb":    {dimensionBytes, 1e6},
+	"gb":    {dimensionBytes, 1e9},
+	"tb":    {dimensionBytes, 1e12},
+	"kib":   {dimensionBytes, 1 << 10},
+	"mib":   {dimensionBytes, 1 << 20},
+	"gib":   {dimensionBytes, 1 << 30},
+	"tib":   {dimensionBytes, 1 << 40},
+
+	// Proportions, in percent
+	"percent":    {dimensionPercent, 1},
+	"percentage": {dimensionPercent, 1},
+	"%":          {dimensionPercent, 1},
+	"ratio":      {dimensionPercent, 100},
+
+	// Rates, in events per hour
+	"requests/s":   {dimensionRate, 3600},
+	"rps":          {dimensionRate, 3600},
+	"ops/s":        {dimensionRate, 3600},
+	"requests/min": {dimensionRate, 60},
+	"rpm":          {dimensionRate, 60},
+	"requests/h":   {dimensionRate, 1},
+
+	// CPU, in millicores
+	"millicores": {dimensionCPU, 1},
+	"cores":      {dimensionCPU, 1e3},
+
+	"count": {dimensionCount, 1},
+}
+
+// displayUnits is the unit values of each dimension are presented in
+var displayUnits = map[string]string{
+	dimensionTime:    "ms",
+	dimensionBytes:   "MB",
+	dimensionPercent: "percent",
+	dimensionRate:    "requests/s",
+	dimensionCPU:     "cores",
+	dimensionCount:   "count",
+}
+
+// lookupUnit returns the registry entry for unit, ignoring case and surrounding space
+func lookupUnit(unit string) (unitDefinition, bool) {
+	definition, ok := unitRegistry[strings.ToLower(strings.TrimSpace(unit))]
+	return definition, ok
+}
+
+// validateUnit reports a unit missing from the registry. An empty unit is
+// allowed and leaves values unconverted.
+func validateUnit(unit string) error {
+	if unit == "" {
+		return nil
+	}
+	if _, ok := lookupUnit(unit); !ok {
+		return fmt.Errorf("unit %q is not recognized, use a unit such as ms, seconds, bytes, MB, percent, ratio, requests/s or cores", unit)
+	}
+	return nil
+}
+
+// convertUnit converts value from one unit to another of the same dimension
+func convertUnit(value float64, from, to string) (float64, error) {
+	fromUnit, ok := lookupUnit(from)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	toUnit, ok := lookupUnit(to)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if fromUnit.dimension != toUnit.dimension {
+		return 0, fmt.Errorf("cannot convert %s to %s", from, to)
+	}
+	if fromUnit.factor == toUnit.factor {
+		return value, nil
+	}
+	return value * fromUnit.factor / toUnit.factor, nil
+}
+
+// presentationUnit returns the unit values measured in unit are presented in:
+// its dimension's display unit, or unit itself when it already has the
+// display unit's size or isn't a registered unit
+func presentationUnit(unit string) string {
+	definition, ok := lookupUnit(unit)
+	if !ok {
+		return unit
+	}
+	display := displayUnits[definition.dimension]
+	if displayDefinition, _ := lookupUnit(display); displayDefinition.factor == definition.factor {
+		return unit
+	}
+	return display
+}
+
+// presentValue converts a value measured in unit to its presentation unit,
+// returning the converted value and that unit
+func presentValue(value float64, unit string) (float64, string) {
+	display := presentationUnit(unit)
+	if display == unit {
+		return value, unit
+	}
+	converted, err := convertUnit(value, unit, display)
+	if err != nil {
+		return value, unit
+	}
+	return converted, display
+}
+
+// presentedMetrics returns metrics as shown in prompts: each unit replaced by
+// its presentation unit and health_config thresholds converted to match, so
+// thresholds and health values read in the same unit. metrics itself is
+// returned when no unit needs converting.
+func presentedMetrics(metrics []MetricConfig) []MetricConfig {
+	var presented []MetricConfig
+	for i, metric := range metrics {
+		if presentationUnit(metric.Unit) == metric.Unit {
+			if presented != nil {
+				presented = append(presented, metric)
+			}
+			continue
+		}
+		if presented == nil {
+			presented = append(make([]MetricConfig, 0, len(metrics)), metrics[:i]...)
+		}
+		presented = append(presented, presentMetric(metric))
+	}
+	if presented == nil {
+		return metrics
+	}
+	return presented
+}
+
+// healthThresholdKeys are the health_config keys holding thresholds in the metric's unit
+var healthThresholdKeys = []string{"warn", "warning_threshold", "crit", "critical_threshold"}
+
+// presentMetric converts one metric's unit and thresholds to its presentation unit
+func presentMetric(metric MetricConfig) MetricConfig {
+	unit := metric.Unit
+	if len(metric.HealthConfig) > 0 {
+		healthConfig := make(map[string]interface{}, len(metric.HealthConfig))
+		for key, value := range metric.HealthConfig {
+			healthConfig[key] = value
+		}
+		for _, key := range healthThresholdKeys {
+			// Invalid thresholds are rejected by Validate
+			if threshold, err := healthNumber(metric.HealthConfig, key); err == nil && threshold != nil {
+				healthConfig[key], _ = presentValue(*threshold, unit)
+			}
+		}
+		metric.HealthConfig = healthConfig
+	}
+	metric.Unit = presentationUnit(unit)
+	return metric
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConvertUnit(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		want     float64
+		wantErr  bool
+	}{
+		{0.25, "seconds", "ms", 250, false},
+		{1500000, "bytes", "MB", 1.5, false},
+		{1, "GiB", "MB", 1073.741824, false},
+		{0.05, "ratio", "percent", 5, false},
+		{120, "rpm", "requests/s", 2, false},
+		{250, "millicores", "Cores", 0.25, false},
+		{1, "seconds", "MB", 0, true},
+		{1, "furlongs", "ms", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := convertUnit(tt.value, tt.from, tt.to)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("convertUnit(%v, %s, %s) error = %v, want error %v", tt.value, tt.from, tt.to, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("convertUnit(%v, %s, %s) = %v, want %v", tt.value, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestPresentValue(t *testing.T) {
+	tests := []struct {
+		value    float64
+		unit     string
+		want     float64
+		wantUnit string
+	}{
+		{0.3, "s", 300, "ms"},
+		{2048000, "bytes", 2.048, "MB"},
+		{82.5, "percentage", 82.5, "percentage"}, // Already the display unit's size, kept as configured
+		{7, "", 7, ""},
+	}
+	for _, tt := range tests {
+		got, unit := presentValue(tt.value, tt.unit)
+		if got != tt.want || unit != tt.wantUnit {
+			t.Errorf("presentValue(%v, %q) = %v %q, want %v %q", tt.value, tt.unit, got, unit, tt.want, tt.wantUnit)
+		}
+	}
+}
+
+func TestPresentedMetrics(t *testing.T) {
+	metrics := []MetricConfig{
+		{Name: "cpu", Unit: "percentage"},
+		{Name: "latency", Unit: "seconds", HealthConfig: map[string]interface{}{"warn": 0.2, "critical_threshold": 1, "polarity": "high_is_bad"}},
+	}
+	if got := presentedMetrics(metrics[:1]); &got[0] != &metrics[0] {
+		t.Error("metrics needing no conversion were copied")
+	}
+
+	presented := presentedMetrics(metrics)
+	latency := presented[1]
+	if latency.Unit != "ms" || latency.HealthConfig["warn"] != 200.0 || latency.HealthConfig["critical_threshold"] != 1000.0 || latency.HealthConfig["polarity"] != "high_is_bad" {
+		t.Errorf("latency presented as %+v, want thresholds in ms", latency)
+	}
+	if metrics[1].Unit != "seconds" || metrics[1].HealthConfig["warn"] != 0.2 {
+		t.Errorf("presentedMetrics modified the configured metric: %+v", metrics[1])
+	}
+
+	health := evaluateHealth(metrics, map[string]float64{"latency": 0.5})
+	if health == nil || health.Status != HealthWarn || health.Metrics[0].Value != 500 {
+		t.Errorf("health = %+v, want warn at 500 (ms)", health)
+	}
+}
+
+func TestValidateRejectsUnknownUnits(t *testing.T) {
+	config := &OCSConfig{
+		Workload: []string{"app"},
+		Metrics: []MetricConfig{
+			{Name: "latency", Type: "gauge", Unit: "MS"},
+			{Name: "distance", Type: "gauge", Unit: "furlongs"},
+		},
+	}
+	var validationErr *ConfigValidationError
+	if err := config.Validate(); !errors.As(err, &validationErr) || len(validationErr.Problems) != 1 {
+		t.Errorf("Validate() = %v, want one problem for the unknown unit", err)
+	}
+}
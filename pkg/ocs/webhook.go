@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/contexture/ocs/pkg/ocs/internal/backoff"
+	"github.com/contexture/ocs/pkg/ocs/store"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+const (
+	// topologyChangedEvent names the event sent when a collection changes the topology
+	topologyChangedEvent = "topology.changed"
+
+	// Headers sent with every webhook delivery
+	webhookEventHeader     = "X-OCS-Event"
+	webhookDeliveryHeader  = "X-OCS-Delivery"
+	webhookSignatureHeader = "X-OCS-Signature"
+
+	// Defaults for the WEBHOOK_* retry settings
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookRetryDelay  = time.Second
+	defaultWebhookTimeout     = 10 * time.Second
+)
+
+// TopologyChangeEvent is the payload POSTed to webhooks when a collection
+// saves a topology whose edges differ from the previous snapshot
+type TopologyChangeEvent struct {
+	Event              string              `json:"event"`
+	ID                 string              `json:"id"` // Unique per event, repeated across retries so receivers can deduplicate
+	Tenant             string              `json:"tenant,omitempty"`
+	DocumentID         string              `json:"document_id"`
+	Timestamp          string              `json:"timestamp"`
+	PreviousDocumentID string              `json:"previous_document_id,omitempty"` // Omitted for a tenant's first snapshot
+	PreviousTimestamp  string              `json:"previous_timestamp,omitempty"`
+	Added              map[string][]string `json:"added"`
+	Removed            map[string][]string `json:"removed"`
+	AddedCount         int                 `json:"added_count"`
+	RemovedCount       int                 `json:"removed_count"`
+}
+
+// newTopologyChangeEvent describes how the topology saved as docID differs
+// from previous, which is nil for a tenant's first snapshot. It returns nil
+// when no edge was added or removed.
+func newTopologyChangeEvent(tenantID string, previous *store.AdjacencyListDocument, docID primitive.ObjectID, timestamp time.Time, adjacencyList map[string][]string) *TopologyChangeEvent {
+	var previousAdjacency map[string][]string
+	if previous != nil {
+		previousAdjacency = adjacencyListOf(previous)
+	}
+	added, removed, _ := topology.Diff(previousAdjacency, adjacencyList)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	event := &TopologyChangeEvent{
+		Event:        topologyChangedEvent,
+		ID:           newRequestID(),
+		Tenant:       tenantID,
+		DocumentID:   docID.Hex(),
+		Timestamp:    timestamp.UTC().Format(time.RFC3339),
+		Added:        added,
+		Removed:      removed,
+		AddedCount:   topology.CountEdges(added),
+		RemovedCount: topology.CountEdges(removed),
+	}
+	if previous != nil {
+		event.PreviousDocumentID = previous.ID.Hex()
+		event.PreviousTimestamp = previous.Timestamp.UTC().Format(time.RFC3339)
+	}
+	return event
+}
+
+// webhookSender POSTs topology change events to the WEBHOOK_URLS, retrying
+// failed deliveries in the background with exponential backoff
+type webhookSender struct {
+	urls        []string
+	secret      []byte // Signs each body with HMAC-SHA256 when set
+	maxAttempts int
+	baseDelay   time.Duration
+	client      *http.Client
+
+	ctx    context.Context // Cancelled by stop to abandon pending deliveries
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// loadWebhookSender reads webhook settings from the environment. It returns
+// nil when WEBHOOK_URLS is unset, leaving webhooks disabled.
+func loadWebhookSender() (*webhookSender, error) {
+	urls := splitList(os.Getenv("WEBHOOK_URLS"))
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid WEBHOOK_URLS entry %q: must be an absolute http or https URL", raw)
+		}
+	}
+
+	maxAttempts := defaultWebhookMaxAttempts
+	if value := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS %q: must be a positive integer", value)
+		}
+		maxAttempts = n
+	}
+
+	durations := map[string]time.Duration{
+		"WEBHOOK_RETRY_BASE_DELAY": defaultWebhookRetryDelay,
+		"WEBHOOK_TIMEOUT":          defaultWebhookTimeout,
+	}
+	for key := range durations {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be a positive duration", key, value)
+		}
+		durations[key] = d
+	}
+
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		slog.Warn("WEBHOOK_SECRET is not set; webhook deliveries are unsigned")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookSender{
+		urls:        urls,
+		secret:      []byte(secret),
+		maxAttempts: maxAttempts,
+		baseDelay:   durations["WEBHOOK_RETRY_BASE_DELAY"],
+		client:      &http.Client{Timeout: durations["WEBHOOK_TIMEOUT"]},
+		ctx:         ctx,
+		cancel:      cancel,
+	}, nil
+}
+
+// send delivers event to every URL in the background
+func (w *webhookSender) send(event *TopologyChangeEvent, logger *slog.Logger) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Failed to encode webhook event", "error", err)
+		return
+	}
+	for _, target := range w.urls {
+		w.wg.Add(1)
+		go func(target string) {
+			defer w.wg.Done()
+			w.deliver(target, event, body, logger.With("webhook_url", target, "event_id", event.ID))
+		}(target)
+	}
+}
+
+// deliver POSTs body to target until it is accepted, the attempts run out,
+// or the response shows a retry can't help
+func (w *webhookSender) deliver(target string, event *TopologyChangeEvent, body []byte, logger *slog.Logger) {
+	for attempt := 1; ; attempt++ {
+		retryable, err := w.post(target, event, body)
+		if err == nil {
+			webhookDeliveriesTotal.WithLabelValues("success").Inc()
+			logger.Info("Delivered webhook", "attempts", attempt)
+			return
+		}
+		if !retryable || attempt >= w.maxAttempts {
+			webhookDeliveriesTotal.WithLabelValues("failure").Inc()
+			logger.Warn("Failed to deliver webhook", "attempts", attempt, "error", err)
+			return
+		}
+
+		delay := backoff.Delay(w.baseDelay, attempt)
+		logger.Debug("Retrying webhook", "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-w.ctx.Done():
+			webhookDeliveriesTotal.WithLabelValues("failure").Inc()
+			logger.Warn("Abandoned webhook delivery at shutdown", "attempts", attempt, "error", err)
+			return
+		}
+	}
+}
+
+// post makes one delivery attempt, reporting whether a failure is worth retrying
+func (w *webhookSender) post(target string, event *TopologyChangeEvent, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event.Event)
+	req.Header.Set(webhookDeliveryHeader, event.ID)
+	if len(w.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, signWebhookBody(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("webhook responded with HTTP %d", resp.StatusCode)
+}
+
+// signWebhookBody returns the X-OCS-Signature value for body: sha256= followed
+// by the hex HMAC-SHA256 of the body keyed with secret
+func signWebhookBody(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// wait waits for in-flight deliveries to finish, or for ctx to expire
+func (w *webhookSender) wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// stop abandons pending deliveries and retries
+func (w *webhookSender) stop() {
+	w.cancel()
+}
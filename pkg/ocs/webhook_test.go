@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/contexture/ocs/pkg/ocs/store"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+func TestNewTopologyChangeEvent(t *testing.T) {
+	previous := &store.AdjacencyListDocument{
+		ID:            primitive.NewObjectID(),
+		Timestamp:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		AdjacencyList: map[string][]string{"frontend": {"cart", "catalog"}},
+	}
+	docID := primitive.NewObjectID()
+	now := previous.Timestamp.Add(time.Minute)
+
+	if event := newTopologyChangeEvent("acme", previous, docID, now, map[string][]string{"frontend": {"catalog", "cart"}}); event != nil {
+		t.Errorf("unchanged topology produced event %+v", event)
+	}
+
+	event := newTopologyChangeEvent("acme", previous, docID, now, map[string][]string{"frontend": {"catalog", "checkout"}})
+	if event == nil {
+		t.Fatal("changed topology produced no event")
+	}
+	if event.AddedCount != 1 || event.Added["frontend"][0] != "checkout" {
+		t.Errorf("added = %v (%d), want frontend -> checkout", event.Added, event.AddedCount)
+	}
+	if event.RemovedCount != 1 || event.Removed["frontend"][0] != "cart" {
+		t.Errorf("removed = %v (%d), want frontend -> cart", event.Removed, event.RemovedCount)
+	}
+	if event.PreviousDocumentID != previous.ID.Hex() || event.DocumentID != docID.Hex() || event.Tenant != "acme" {
+		t.Errorf("event identifiers = %+v", event)
+	}
+
+	first := newTopologyChangeEvent("", nil, docID, now, map[string][]string{"frontend": {"cart"}})
+	if first == nil || first.PreviousDocumentID != "" || first.AddedCount != 1 {
+		t.Errorf("first snapshot event = %+v, want one added edge and no previous document", first)
+	}
+}
+
+func TestTopologyChangeEventForBucketMerge(t *testing.T) {
+	// A bucket this long can't be crossed between the saves
+	bucketSeconds := 100 * 365 * 24 * 60 * 60
+	config := &OCSConfig{SnapshotBucketSeconds: &bucketSeconds}
+	repo := store.NewInMemoryRepository()
+	tenant := newTenant("", repo)
+
+	save := func(adjacencyList map[string][]string) (*store.AdjacencyListDocument, *TopologyChangeEvent) {
+		t.Helper()
+		previous, err := repo.GetLatestSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		docID, err := repo.SaveAdjacencyListInBucket(config.snapshotBucket(), topology.Graph{AdjacencyList: adjacencyList})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := storedAdjacency(tenant, config, docID, adjacencyList)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return previous, newTopologyChangeEvent("", previous, docID, time.Now(), stored)
+	}
+
+	save(map[string][]string{"frontend": {"cart"}})
+
+	// The bucket keeps frontend -> cart, so only the new edge is reported
+	previous, event := save(map[string][]string{"frontend": {"checkout"}})
+	if event == nil {
+		t.Fatal("merge adding an edge produced no event")
+	}
+	if event.AddedCount != 1 || event.Added["frontend"][0] != "checkout" || event.RemovedCount != 0 {
+		t.Errorf("added = %v, removed = %v; want only frontend -> checkout added", event.Added, event.Removed)
+	}
+	if event.PreviousDocumentID != previous.ID.Hex() || event.DocumentID == event.PreviousDocumentID {
+		t.Errorf("document_id = %s, previous_document_id = %s; want the merged and pre-merge snapshots", event.DocumentID, event.PreviousDocumentID)
+	}
+
+	if _, event := save(map[string][]string{"frontend": {"cart"}}); event != nil {
+		t.Errorf("merge adding nothing produced event %+v", event)
+	}
+}
+
+func TestWebhookSenderRetriesAndSigns(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer server.Close()
+
+	t.Setenv("WEBHOOK_URLS", server.URL)
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+	t.Setenv("WEBHOOK_RETRY_BASE_DELAY", "1ms")
+	sender, err := loadWebhookSender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.stop()
+
+	event := &TopologyChangeEvent{Event: topologyChangedEvent, ID: "evt-1", AddedCount: 1}
+	sender.send(event, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sender.wait(ctx)
+
+	select {
+	case r := <-received:
+		if got := attempts.Load(); got != 2 {
+			t.Errorf("attempts = %d, want 2", got)
+		}
+		if got := r.Header.Get(webhookDeliveryHeader); got != "evt-1" {
+			t.Errorf("%s = %q, want evt-1", webhookDeliveryHeader, got)
+		}
+		if got, want := r.Header.Get(webhookSignatureHeader), signWebhookBody([]byte("s3cret"), body); got != want {
+			t.Errorf("%s = %q, want %q", webhookSignatureHeader, got, want)
+		}
+		var decoded TopologyChangeEvent
+		if err := json.Unmarshal(body, &decoded); err != nil || decoded.ID != "evt-1" {
+			t.Errorf("body = %s, want the encoded event", body)
+		}
+	default:
+		t.Fatal("webhook was never delivered")
+	}
+}
+
+func TestLoadWebhookSender(t *testing.T) {
+	t.Setenv("WEBHOOK_URLS", "")
+	if sender, err := loadWebhookSender(); sender != nil || err != nil {
+		t.Errorf("loadWebhookSender() = %v, %v with no URLs, want nil, nil", sender, err)
+	}
+
+	for key, value := range map[string]string{
+		"WEBHOOK_URLS":         "ftp://example.com/hook",
+		"WEBHOOK_MAX_ATTEMPTS": "0",
+		"WEBHOOK_TIMEOUT":      "soon",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv("WEBHOOK_URLS", "https://example.com/hook")
+			t.Setenv(key, value)
+			if _, err := loadWebhookSender(); err == nil {
+				t.Errorf("%s=%q was accepted", key, value)
+			}
+		})
+	}
+}
+
+func TestSignWebhookBody(t *testing.T) {
+	// printf '{}' | openssl dgst -sha256 -hmac key
+	want := "sha256=a777724d943eb48dc69bca8a4a6d57a04db3f9ec7e1de4e581e860265bdf3032"
+	if got := signWebhookBody([]byte("key"), []byte("{}")); got != want {
+		t.Errorf("signWebhookBody() = %q, want %q", got, want)
+	}
+}
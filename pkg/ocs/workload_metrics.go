@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+// defaultMetricsWindow is the rate window of the workload metrics endpoint when window is not given
+const defaultMetricsWindow = 5 * time.Minute
+
+// WorkloadMetricValue is the live value of one configured metric for a workload
+type WorkloadMetricValue struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Unit        string   `json:"unit,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Aggregation string   `json:"aggregation"`
+	Query       string   `json:"query,omitempty"`
+	Value       *float64 `json:"value"`           // Nil when the workload has no series for the metric or the query failed
+	Error       string   `json:"error,omitempty"` // Why the metric has no value, when its query failed
+}
+
+// WorkloadMetricsResponse is the response of the workload metrics endpoint
+type WorkloadMetricsResponse struct {
+	Status     string                 `json:"status"`
+	Workload   string                 `json:"workload"`
+	Namespace  string                 `json:"namespace,omitempty"`
+	Window     string                 `json:"window"`
+	Timestamp  string                 `json:"timestamp"`
+	SnapshotID string                 `json:"snapshot_id,omitempty"`
+	Topology   map[string]interface{} `json:"topology,omitempty"` // The workload's neighbors in the latest snapshot, when it appears there
+	Metrics    []WorkloadMetricValue  `json:"metrics"`
+}
+
+// liveMetricAggregation returns the PromQL aggregation combining a workload's
+// series of metric. aggregation_logic wins when set; otherwise counters,
+// histograms and summaries are summed across instances and gauges averaged.
+func liveMetricAggregation(metric MetricConfig) (string, error) {
+	if metric.AggregationLogic != "" {
+		aggregation, ok := healthAggregations[strings.ToLower(metric.AggregationLogic)]
+		if !ok {
+			return "", fmt.Errorf("aggregation_logic %q is not supported: must be average, sum, max or min", metric.AggregationLogic)
+		}
+		return aggregation, nil
+	}
+	if strings.EqualFold(metric.Type, "gauge") {
+		return "avg", nil
+	}
+	return "sum", nil
+}
+
+// liveMetricQuery returns the PromQL query for metric's current value per
+// workload, restricted to workload and, when set, namespace. Counters are
+// read as a per-second rate over window, histograms and summaries as the mean
+// observation over window, and gauges as their current value. A health_config
+// query is used as is, its series for the workload picked from the result.
+func liveMetricQuery(metric MetricConfig, workloadLabel, workload, namespace string, window time.Duration) (string, string, error) {
+	aggregation, err := liveMetricAggregation(metric)
+	if err != nil {
+		return "", "", err
+	}
+	if thresholds, err := parseHealthThresholds(metric.HealthConfig); err == nil && thresholds != nil && thresholds.query != "" {
+		return thresholds.query, aggregation, nil
+	}
+	if !prometheus.IsValidMetricName(metric.Name) {
+		return "", "", fmt.Errorf("name %q is not a valid PromQL metric name", metric.Name)
+	}
+
+	by := workloadLabel
+	selector := fmt.Sprintf("%s=%s", workloadLabel, strconv.Quote(workload))
+	if namespace != "" {
+		by += ", namespace"
+		selector += fmt.Sprintf(", namespace=%s", strconv.Quote(namespace))
+	}
+	rangeSelector := fmt.Sprintf("{%s}[%ds]", selector, int(window.Seconds()))
+
+	var query string
+	switch strings.ToLower(metric.Type) {
+	case "counter":
+		query = fmt.Sprintf("%s by (%s) (rate(%s%s))", aggregation, by, metric.Name, rangeSelector)
+	case "histogram", "summary":
+		query = fmt.Sprintf("%[1]s by (%[2]s) (rate(%[3]s_sum%[4]s)) / %[1]s by (%[2]s) (rate(%[3]s_count%[4]s))", aggregation, by, metric.Name, rangeSelector)
+	default:
+		query = fmt.Sprintf("%s by (%s) (%s{%s})", aggregation, by, metric.Name, selector)
+	}
+	return query, aggregation, nil
+}
+
+// workloadMetricsHandler handles the workloads/:name/metrics endpoint,
+// querying the live value of every configured metric for one workload and
+// returning them alongside its neighbors in the latest topology
+func (s *Server) workloadMetricsHandler(c *gin.Context) {
+	workload := c.Param("name")
+	namespace := c.Query("namespace")
+
+	window := defaultMetricsWindow
+	if value := c.Query("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < time.Second {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid window duration. Use a Go duration of at least 1s (e.g., 5m)")
+			return
+		}
+		window = parsed.Truncate(time.Second)
+	}
+
+	config := s.config()
+	logger := requestLogger(c)
+	opts := prometheus.QueryOptions{Logger: prometheus.NewQueryLogger(logger, config.QuietQueries)}
+	qualify := namespace != ""
+	key := topology.QualifyWorkload(namespace, workload)
+
+	metrics := make([]WorkloadMetricValue, 0, len(config.Metrics))
+	// Only when every query fails is Prometheus itself in trouble
+	var queried, failed int
+	var queryErr error
+	for _, metric := range config.Metrics {
+		value := WorkloadMetricValue{
+			Name:        metric.Name,
+			Type:        metric.Type,
+			Unit:        presentationUnit(metric.Unit),
+			Description: metric.Description,
+		}
+		workloadLabel := defaultHealthWorkloadLabel
+		if thresholds, err := parseHealthThresholds(metric.HealthConfig); err == nil && thresholds != nil {
+			workloadLabel = thresholds.workloadLabel
+		}
+
+		query, aggregation, err := liveMetricQuery(metric, workloadLabel, workload, namespace, window)
+		value.Query, value.Aggregation = query, aggregation
+		if err != nil {
+			value.Error = err.Error()
+			metrics = append(metrics, value)
+			continue
+		}
+
+		queried++
+		values, errs := s.istioConnector.CollectWorkloadMetrics(c.Request.Context(), []prometheus.WorkloadMetricQuery{{
+			Name:          metric.Name,
+			Query:         query,
+			WorkloadLabel: workloadLabel,
+		}}, qualify, opts)
+		if len(errs) > 0 {
+			logger.Warn("Failed to query workload metric", "metric", metric.Name, "error", errs[0])
+			value.Error = errs[0].Error()
+			failed++
+			queryErr = errs[0]
+		} else if v, ok := values[key][metric.Name]; ok {
+			v, _ = presentValue(v, metric.Unit)
+			value.Value = &v
+		}
+		metrics = append(metrics, value)
+	}
+	if queried > 0 && failed == queried {
+		respondPrometheusError(c, queryErr)
+		return
+	}
+
+	response := WorkloadMetricsResponse{
+		Status:    "success",
+		Workload:  workload,
+		Namespace: namespace,
+		Window:    window.String(),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Metrics:   metrics,
+	}
+
+	snapshot, err := tenantOf(c).repo.GetLatestSnapshot()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, ErrCodeDatabaseError, fmt.Sprintf("Failed to retrieve topology from MongoDB: %v", err))
+		return
+	}
+	if snapshot != nil {
+		response.SnapshotID = snapshot.ID.Hex()
+		topologyKey := workload
+		if config.QualifyNamespaces {
+			topologyKey = key
+		}
+		adjacencyList := adjacencyListOf(snapshot)
+		if neighbors := topology.ForWorkload(adjacencyList, topology.Reverse(adjacencyList), snapshot.EdgeWeights, topologyKey, 1); len(neighbors) > 0 {
+			response.Topology = neighbors
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
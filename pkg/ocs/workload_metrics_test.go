@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/contexture/ocs/pkg/ocs/prometheus"
+	"github.com/contexture/ocs/pkg/ocs/store"
+	"github.com/contexture/ocs/pkg/ocs/topology"
+)
+
+func TestLiveMetricQuery(t *testing.T) {
+	tests := []struct {
+		metric    MetricConfig
+		namespace string
+		want      string
+	}{
+		{
+			metric: MetricConfig{Name: "cpu_utilization", Type: "gauge"},
+			want:   `avg by (workload) (cpu_utilization{workload="checkout"})`,
+		},
+		{
+			metric:    MetricConfig{Name: "http_requests_total", Type: "counter"},
+			namespace: "shop",
+			want:      `sum by (workload, namespace) (rate(http_requests_total{workload="checkout", namespace="shop"}[300s]))`,
+		},
+		{
+			metric: MetricConfig{Name: "latency_seconds", Type: "histogram", AggregationLogic: "max"},
+			want:   `max by (workload) (rate(latency_seconds_sum{workload="checkout"}[300s])) / max by (workload) (rate(latency_seconds_count{workload="checkout"}[300s]))`,
+		},
+		{
+			metric: MetricConfig{Name: "errors", Type: "gauge", HealthConfig: map[string]interface{}{"crit": 1, "query": "sum by (workload) (errors_total)"}},
+			want:   "sum by (workload) (errors_total)",
+		},
+	}
+	for _, tt := range tests {
+		got, _, err := liveMetricQuery(tt.metric, "workload", "checkout", tt.namespace, 5*time.Minute)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: query = %q, %v; want %q", tt.metric.Name, got, err, tt.want)
+		}
+	}
+
+	if _, _, err := liveMetricQuery(MetricConfig{Name: "p95", Type: "gauge", AggregationLogic: "p95"}, "workload", "checkout", "", time.Minute); err == nil {
+		t.Error("unsupported aggregation_logic was accepted")
+	}
+}
+
+func TestWorkloadMetricsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var queries []string
+	promServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.FormValue("query")
+		queries = append(queries, query)
+		value := "0.5"
+		if strings.Contains(query, "rate(") {
+			value = "12.5"
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"workload":"checkout"},"value":[0,"%s"]}]}}`, value)
+	}))
+	defer promServer.Close()
+
+	repo := store.NewInMemoryRepository()
+	repo.SaveAdjacencyList(topology.Graph{AdjacencyList: map[string][]string{"frontend": {"checkout"}, "checkout": {"payments"}}})
+	s := &Server{
+		ocsConfig: &OCSConfig{Metrics: []MetricConfig{
+			{Name: "cpu_utilization", Type: "gauge", Unit: "percentage"},
+			{Name: "http_requests_total", Type: "counter", Unit: "requests/s"},
+		}},
+		istioConnector: prometheus.NewIstioConnector(&prometheus.Config{
+			PrometheusInstances: []prometheus.Instance{{Name: "test", BaseURL: promServer.URL}},
+		}),
+		defaultTenant: newTenant("", repo),
+	}
+	router := gin.New()
+	router.GET("/workloads/:name/metrics", s.resolveTenant, s.workloadMetricsHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/workloads/checkout/metrics?window=1m", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var response WorkloadMetricsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Metrics) != 2 || response.Metrics[0].Value == nil || *response.Metrics[0].Value != 0.5 || response.Metrics[1].Value == nil || *response.Metrics[1].Value != 12.5 {
+		t.Errorf("metrics = %+v, want gauge 0.5 and counter rate 12.5", response.Metrics)
+	}
+	if response.Metrics[1].Unit != "requests/s" || response.Metrics[1].Aggregation != "sum" {
+		t.Errorf("counter metric = %+v, want its unit and sum aggregation", response.Metrics[1])
+	}
+	if len(queries) != 2 || !strings.Contains(queries[1], "[60s]") {
+		t.Errorf("queries = %q, want the counter rated over the 1m window", queries)
+	}
+	if deps := response.Topology["dependencies"]; fmt.Sprint(deps) != "[payments]" {
+		t.Errorf("topology = %v, want checkout's dependency on payments", response.Topology)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/workloads/checkout/metrics?window=soon", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid window: status = %d, want 400", w.Code)
+	}
+}